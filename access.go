@@ -0,0 +1,180 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// FieldIndex returns the index of the field with the given name or -1 if
+// there is no such field.
+func (me *Table) FieldIndex(name string) int {
+	for i, field := range me.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value in the given row of the field with the given name.
+// Returns an error if the row or field doesn't exist or if the value isn't
+// valid for the field's kind (or is null for a not null field).
+func (me *Table) Get(row int, fieldName string) (any, error) {
+	if row < 0 || row >= len(me.Records) {
+		return nil, fmt.Errorf("e%d#%s:row %d out of range (0-%d)", e151,
+			me.Name, row, len(me.Records)-1)
+	}
+	column := me.FieldIndex(fieldName)
+	if column == -1 {
+		return nil, fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			fieldName)
+	}
+	value := me.Records[row][column]
+	if err := checkValue(me.Fields[column], value); err != nil {
+		return nil, fmt.Errorf("e%d#%s.%s:row %d: %s", e153, me.Name,
+			fieldName, row, err)
+	}
+	return value, nil
+}
+
+// IsNull returns true if the value at index i is null (or if i is out of
+// range).
+func (me Record) IsNull(i int) bool {
+	return i < 0 || i >= len(me) || me[i] == nil
+}
+
+// Bool returns the bool at index i or an error if there is no value at
+// that index or if the value isn't a bool.
+func (me Record) Bool(i int) (bool, error) {
+	value, err := me.valueAt(i, BoolField)
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
+
+// Bytes returns the []byte at index i or an error if there is no value at
+// that index or if the value isn't a []byte.
+func (me Record) Bytes(i int) ([]byte, error) {
+	value, err := me.valueAt(i, BytesField)
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+// Date returns the time.Time at index i or an error if there is no value
+// at that index or if the value isn't a time.Time. (This works for both
+// date and datetime fields.)
+func (me Record) Date(i int) (time.Time, error) {
+	value, err := me.valueAt(i, DateTimeField)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return value.(time.Time), nil
+}
+
+// Int returns the int at index i or an error if there is no value at that
+// index or if the value isn't an int.
+func (me Record) Int(i int) (int, error) {
+	value, err := me.valueAt(i, IntField)
+	if err != nil {
+		return 0, err
+	}
+	return value.(int), nil
+}
+
+// Real returns the float64 at index i or an error if there is no value at
+// that index or if the value isn't a float64.
+func (me Record) Real(i int) (float64, error) {
+	value, err := me.valueAt(i, RealField)
+	if err != nil {
+		return 0, err
+	}
+	return value.(float64), nil
+}
+
+// Str returns the string at index i or an error if there is no value at
+// that index or if the value isn't a string.
+func (me Record) Str(i int) (string, error) {
+	value, err := me.valueAt(i, StrField)
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+func (me Record) valueAt(i int, kind FieldKind) (any, error) {
+	if i < 0 || i >= len(me) {
+		return nil, fmt.Errorf("e%d#index %d out of range (0-%d)", e151, i,
+			len(me)-1)
+	}
+	value := me[i]
+	if value == nil {
+		return nil, fmt.Errorf("e%d#%d:null where %s expected", e154, i,
+			kind)
+	}
+	if !valueIsKind(value, kind) {
+		return nil, fmt.Errorf("e%d#%d:got %T, expected %s", e153, i,
+			value, kind)
+	}
+	return value, nil
+}
+
+// checkValue returns nil if the value is valid for the given field;
+// otherwise returns an error (without an error code) explaining why not.
+func checkValue(field *MetaFieldType, value any) error {
+	if value == nil {
+		if field.AllowNull {
+			return nil
+		}
+		return fmt.Errorf("null not allowed: provide a valid %s or "+
+			"change the field's type to %s?", field.Kind, field.Kind)
+	}
+	if !valueIsKind(value, field.Kind) {
+		return fmt.Errorf("got %T, expected a Go %s for %s", value,
+			goTypeForKind(field.Kind), field.Kind)
+	}
+	return nil
+}
+
+// valueIsKind returns true if the (non-nil) value has the Go type that Tdb
+// uses for the given kind.
+func valueIsKind(value any, kind FieldKind) bool {
+	switch value.(type) {
+	case bool:
+		return kind == BoolField
+	case []byte:
+		return kind == BytesField
+	case time.Time:
+		return kind == DateField || kind == DateTimeField
+	case int:
+		return kind == IntField
+	case float64:
+		return kind == RealField
+	case string:
+		return kind == StrField
+	}
+	return false
+}
+
+// goTypeForKind returns the name of the Go type Tdb uses for values of the
+// given kind.
+func goTypeForKind(kind FieldKind) string {
+	switch kind {
+	case BoolField:
+		return "bool"
+	case BytesField:
+		return "[]byte"
+	case DateField, DateTimeField:
+		return "time.Time"
+	case IntField:
+		return "int"
+	case RealField:
+		return "float64"
+	}
+	return "string"
+}
@@ -0,0 +1,305 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import "fmt"
+
+// AggregateFunc identifies one of the aggregate functions that can be used
+// with [Grouping.Aggregate].
+type AggregateFunc uint8
+
+const (
+	CountFunc AggregateFunc = iota
+	SumFunc
+	AvgFunc
+	MinFunc
+	MaxFunc
+)
+
+func (me AggregateFunc) String() string {
+	switch me {
+	case CountFunc:
+		return "count"
+	case SumFunc:
+		return "sum"
+	case AvgFunc:
+		return "avg"
+	case MinFunc:
+		return "min"
+	case MaxFunc:
+		return "max"
+	}
+	panic("invalid AggregateFunc")
+}
+
+// Aggregate specifies an aggregate function to apply to a field of each
+// group of records. Create using [Count], [Sum], [Avg], [Min], or [Max].
+type Aggregate struct {
+	Func      AggregateFunc
+	FieldName string // "" is only valid for Count (meaning all records)
+	Name      string // result fieldname; if "" one is made, e.g., sum_sal
+}
+
+// Count returns an aggregate that counts the non-null values of the given
+// field, or counts all records if fieldName is "". The result is an int.
+func Count(fieldName string) Aggregate {
+	return Aggregate{CountFunc, fieldName, ""}
+}
+
+// Sum returns an aggregate that sums the non-null values of the given int
+// or real field. The result has the same kind as the field, and is null
+// for a group with no non-null values.
+func Sum(fieldName string) Aggregate {
+	return Aggregate{SumFunc, fieldName, ""}
+}
+
+// Avg returns an aggregate that averages the non-null values of the given
+// int or real field. The result is a real, and is null for a group with no
+// non-null values.
+func Avg(fieldName string) Aggregate {
+	return Aggregate{AvgFunc, fieldName, ""}
+}
+
+// Min returns an aggregate that finds the least non-null value of the given
+// field. The result has the same kind as the field, and is null for a
+// group with no non-null values.
+func Min(fieldName string) Aggregate {
+	return Aggregate{MinFunc, fieldName, ""}
+}
+
+// Max returns an aggregate that finds the greatest non-null value of the
+// given field. The result has the same kind as the field, and is null for
+// a group with no non-null values.
+func Max(fieldName string) Aggregate {
+	return Aggregate{MaxFunc, fieldName, ""}
+}
+
+// As returns a copy of the aggregate whose result field will have the
+// given name.
+func (me Aggregate) As(name string) Aggregate {
+	me.Name = name
+	return me
+}
+
+// Grouping holds a table's records grouped by the values of one or more
+// fields, ready for aggregating. Create using [Table.GroupBy].
+type Grouping struct {
+	table   *Table
+	columns []int
+	err     error
+}
+
+// GroupBy groups the table's records by the values of the given fields
+// (nulls are grouped together). Use [Grouping.Aggregate] to produce the
+// result table. If no fields are given all the records are in one group.
+func (me *Table) GroupBy(fieldNames ...string) *Grouping {
+	columns, err := me.fieldIndexes(fieldNames)
+	return &Grouping{me, columns, err}
+}
+
+// Aggregate returns a new table with the same name as the grouped table,
+// whose fields are the group by fields followed by one field for each of
+// the given aggregates, and which has one record per group (in order of
+// each group's first appearance).
+//
+// As in SQL, aggregates (except Count("")) ignore nulls, and if no group by
+// fields were given the result has exactly one record even if the table is
+// empty.
+func (me *Grouping) Aggregate(aggregates ...Aggregate) (*Table, error) {
+	if me.err != nil {
+		return nil, me.err
+	}
+	result, aggColumns, err := me.makeResultTable(aggregates)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]int) // value is index into records
+	records := make([][]Record, 0)
+	if len(me.columns) == 0 {
+		records = append(records, me.table.Records)
+	} else {
+		for _, record := range me.table.Records {
+			key := record.keyFor(me.columns)
+			index, ok := groups[key]
+			if !ok {
+				index = len(records)
+				groups[key] = index
+				records = append(records, nil)
+			}
+			records[index] = append(records[index], record)
+		}
+	}
+	for _, group := range records {
+		record := make(Record, 0, len(result.Fields))
+		for _, column := range me.columns {
+			record = append(record, group[0][column])
+		}
+		for i, aggregate := range aggregates {
+			record = append(record, aggregate.apply(group, aggColumns[i]))
+		}
+		result.Records = append(result.Records, record)
+	}
+	return result, nil
+}
+
+func (me *Grouping) makeResultTable(aggregates []Aggregate) (*Table,
+	[]int, error) {
+	table := me.table
+	result := &Table{MetaTableType{table.Name, make([]*MetaFieldType, 0,
+		len(me.columns)+len(aggregates))}, make([]Record, 0), nil}
+	used := make(map[string]bool)
+	for _, column := range me.columns {
+		field := *table.Fields[column]
+		result.Fields = append(result.Fields, &field)
+		used[field.Name] = true
+	}
+	aggColumns := make([]int, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		column := -1
+		field := &MetaFieldType{Kind: IntField}
+		if aggregate.FieldName != "" || aggregate.Func != CountFunc {
+			column = table.FieldIndex(aggregate.FieldName)
+			if column == -1 {
+				return nil, nil, fmt.Errorf("e%d#%s:no field called %q",
+					e152, table.Name, aggregate.FieldName)
+			}
+			var err error
+			field, err = aggregate.resultField(table.Fields[column],
+				len(me.columns) == 0)
+			if err != nil {
+				return nil, nil, fmt.Errorf("e%d#%s.%s:%s", e162,
+					table.Name, aggregate.FieldName, err)
+			}
+		}
+		if field.Name = aggregate.resultName(used); field.Name == "" {
+			return nil, nil, fmt.Errorf("e%d#%s:invalid or duplicate "+
+				"aggregate name %q", e163, table.Name, aggregate.Name)
+		}
+		result.Fields = append(result.Fields, field)
+		aggColumns = append(aggColumns, column)
+	}
+	return result, aggColumns, nil
+}
+
+// resultField returns the kind and nullability of the aggregate's result
+// (the name is set by the caller).
+func (me Aggregate) resultField(field *MetaFieldType,
+	ungrouped bool) (*MetaFieldType, error) {
+	// With no group by fields an empty table produces a group with no
+	// records whose sum, avg, min, and max are null.
+	result := &MetaFieldType{Kind: field.Kind,
+		AllowNull: field.AllowNull || ungrouped}
+	switch me.Func {
+	case CountFunc:
+		result.Kind = IntField
+		result.AllowNull = false
+	case SumFunc, AvgFunc:
+		if field.Kind != IntField && field.Kind != RealField {
+			return nil, fmt.Errorf("can't %s a %s field", me.Func,
+				field.Kind)
+		}
+		if me.Func == AvgFunc {
+			result.Kind = RealField
+		}
+	case MinFunc, MaxFunc:
+		if field.Kind == BoolField {
+			return nil, fmt.Errorf("can't %s a %s field", me.Func,
+				field.Kind)
+		}
+	}
+	return result, nil
+}
+
+// resultName returns the aggregate's name (or a generated one), or "" if
+// the aggregate's own name is invalid or already used.
+func (me Aggregate) resultName(used map[string]bool) string {
+	if me.Name != "" {
+		if !isIdentifier(me.Name) || used[me.Name] {
+			return ""
+		}
+		used[me.Name] = true
+		return me.Name
+	}
+	name := me.Func.String()
+	if me.FieldName != "" {
+		name += "_" + me.FieldName
+	}
+	return makeIdentifier(name, used)
+}
+
+// apply returns the aggregate's value for the given group; column is the
+// index of the aggregate's field (or -1 for Count("")).
+func (me Aggregate) apply(group []Record, column int) any {
+	if column == -1 {
+		return len(group)
+	}
+	values := make([]any, 0, len(group))
+	for _, record := range group {
+		values = append(values, record[column])
+	}
+	return aggregateValues(me.Func, values)
+}
+
+// aggregateValues returns the result of applying the given aggregate
+// function to the given values, ignoring nulls.
+func aggregateValues(fn AggregateFunc, values []any) any {
+	var result any
+	count := 0
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		count++
+		switch fn {
+		case SumFunc, AvgFunc:
+			result = addValues(result, value)
+		case MinFunc:
+			if result == nil || compareValues(value, result) < 0 {
+				result = value
+			}
+		case MaxFunc:
+			if result == nil || compareValues(value, result) > 0 {
+				result = value
+			}
+		}
+	}
+	switch fn {
+	case CountFunc:
+		return count
+	case AvgFunc:
+		switch total := result.(type) {
+		case int:
+			return float64(total) / float64(count)
+		case float64:
+			return total / float64(count)
+		}
+	}
+	return result
+}
+
+// addValues returns total + value where both are ints or both reals
+// (total may be nil).
+func addValues(total, value any) any {
+	switch v := value.(type) {
+	case int:
+		if t, ok := total.(int); ok {
+			return t + v
+		}
+	case float64:
+		if t, ok := total.(float64); ok {
+			return t + v
+		}
+	}
+	return value
+}
+
+// keyFor returns a string that uniquely identifies the record's values in
+// the given columns.
+func (me Record) keyFor(columns []int) string {
+	values := make(Record, 0, len(columns))
+	for _, column := range columns {
+		values = append(values, me[column])
+	}
+	return recordKey(values)
+}
@@ -0,0 +1,72 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+	"strings"
+)
+
+func runDiff(args []string) {
+	parser := clip.NewParserUser("tdb diff", "")
+	parser.LongDesc = "Outputs the differences between two Tdb files: " +
+		"added and removed tables, added, removed, and retyped fields, " +
+		"and inserted, deleted, and modified records. Records are matched " +
+		"by position unless key fields are given for their table. The " +
+		"exit status is 0 if the files are the same and 1 if they differ."
+	parser.PositionalCount = clip.TwoPositionals
+	parser.PositionalHelp = "FILE1 and FILE2 must be .tdb files."
+	keysOpt := parser.Strs("keys", "Key fields to match records by, "+
+		"given as TABLE:FIELD[,FIELD...], e.g., emp:empno dept:deptno (if "+
+		"this is the last option, follow its values with --).")
+	keysOpt.SetShortName('K')
+	formatOpt := parser.Choice("format", "The output format: text "+
+		"(one change per line) or tdb (tables of changes).",
+		[]string{"text", "tdb"}, "text")
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	for _, infile := range parser.Positionals {
+		if !strings.HasSuffix(infile, ".tdb") {
+			parser.OnError(errors.New("error #1: can only read .tdb files"))
+		}
+	}
+	keys := getKeys(keysOpt.Value(), parser.OnError)
+	a := readTdb(parser.Positionals[0], parser.OnError)
+	b := readTdb(parser.Positionals[1], parser.OnError)
+	diff, err := tdb.DiffKeys(a, b, keys)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #15: failed to diff: %s", err))
+	}
+	if formatOpt.Value() == "tdb" {
+		err = diff.Tdb().Write(os.Stdout)
+	} else {
+		err = diff.Write(os.Stdout)
+	}
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write: %s", err))
+	}
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
+
+// getKeys returns the key fields given as TABLE:FIELD[,FIELD...] specs
+// keyed by table name.
+func getKeys(specs []string, onError func(error)) map[string][]string {
+	keys := make(map[string][]string)
+	for _, spec := range specs {
+		tableName, fieldNames, ok := strings.Cut(spec, ":")
+		if !ok || tableName == "" || fieldNames == "" {
+			onError(fmt.Errorf(
+				"error #10: expected TABLE:FIELD[,FIELD...], got %q", spec))
+		}
+		keys[tableName] = strings.Split(fieldNames, ",")
+	}
+	return keys
+}
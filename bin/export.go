@@ -0,0 +1,232 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+	"strings"
+)
+
+func runExport(args []string) {
+	format := ""
+	if len(args) > 0 {
+		format = args[0]
+		args = args[1:]
+	}
+	switch format {
+	case "csv":
+		exportCSV(args)
+	case "json":
+		exportJSON(args)
+	case "sql":
+		exportSQL(args)
+	case "ini", "toml":
+		exportConfig(format, args)
+	default:
+		onFormatError("export", format)
+	}
+}
+
+func exportCSV(args []string) {
+	parser := clip.NewParserUser("tdb export csv", "")
+	parser.LongDesc = "Converts a table in a Tdb file to CSV."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .tdb file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .csv file."
+	tableOpt := parser.Str("table", "The table to export (default: the "+
+		"first table).", "")
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".csv")) {
+		parser.OnError(errors.New("error #12: can only write .csv files"))
+	}
+	db := readTdb(infile, parser.OnError)
+	table := getTable(db, tableOpt.Value(), parser.OnError)
+	outFile := createOutfile(outfile, parser.OnError)
+	defer outFile.Close()
+	if err := table.WriteCSV(outFile); err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write outfile %q: %s",
+			outfile, err))
+	}
+}
+
+func exportJSON(args []string) {
+	parser := clip.NewParserUser("tdb export json", "")
+	parser.LongDesc = "Converts a Tdb file to JSON: an array with one " +
+		"object per table, each with the table's name, fields, and rows. " +
+		"Or converts a table to NDJSON: one object per record."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .tdb file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .json (or .ndjson) file."
+	tableOpt := parser.Str("table", "The table to export (default: all "+
+		"the tables, or for NDJSON the first table).", "")
+	ndjsonOpt := parser.Flag("ndjson", "Write NDJSON.")
+	hexOpt := parser.Flag("hex", "Write bytes as hex rather than base64.")
+	hexOpt.SetShortName('x')
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".json") ||
+		strings.HasSuffix(outfile, ".ndjson")) {
+		parser.OnError(errors.New(
+			"error #12: can only write .json or .ndjson files"))
+	}
+	db := readTdb(infile, parser.OnError)
+	opts := tdb.JSONOptions{HexBytes: hexOpt.Value()}
+	ndjson := ndjsonOpt.Value() || strings.HasSuffix(outfile, ".ndjson")
+	var table *tdb.Table
+	if ndjson || tableOpt.Value() != "" {
+		table = getTable(db, tableOpt.Value(), parser.OnError)
+	}
+	outFile := createOutfile(outfile, parser.OnError)
+	defer outFile.Close()
+	var err error
+	switch {
+	case ndjson:
+		err = table.WriteNDJSON(outFile, opts)
+	case table != nil:
+		err = table.WriteJSON(outFile, opts)
+	default:
+		err = db.WriteJSON(outFile, opts)
+	}
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write outfile %q: %s",
+			outfile, err))
+	}
+}
+
+func exportSQL(args []string) {
+	parser := clip.NewParserUser("tdb export sql", "")
+	parser.LongDesc = "Converts a Tdb file to an SQL script that creates " +
+		"its tables (CREATE TABLE) and inserts their records (INSERT)."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .tdb file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .sql file."
+	dialectOpt := parser.Choice("dialect", "The SQL dialect.",
+		[]string{"sqlite", "postgres"}, "sqlite")
+	tableOpt := parser.Str("table", "The table to export (default: all "+
+		"the tables).", "")
+	batchOpt := parser.IntInRange("batch", "The maximum records per "+
+		"INSERT.", 1, 100000, 100)
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".sql")) {
+		parser.OnError(errors.New("error #12: can only write .sql files"))
+	}
+	db := readTdb(infile, parser.OnError)
+	opts := tdb.SQLOptions{Dialect: tdb.SQLite, BatchSize: batchOpt.Value()}
+	if dialectOpt.Value() == "postgres" {
+		opts.Dialect = tdb.Postgres
+	}
+	if tableOpt.Value() != "" {
+		table := getTable(db, tableOpt.Value(), parser.OnError)
+		export := tdb.NewTdb()
+		export.AddTable(table)
+		db = &export
+	}
+	outFile := createOutfile(outfile, parser.OnError)
+	defer outFile.Close()
+	if err := db.WriteSQL(outFile, opts); err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write outfile %q: %s",
+			outfile, err))
+	}
+}
+
+func exportConfig(format string, args []string) {
+	parser := clip.NewParserUser("tdb export "+format, "")
+	parser.LongDesc = "Converts a Tdb file's config tables (e.g., " +
+		"config_int and config_str) to a ." + format + " file."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .tdb file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given ." + format + " file."
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, "."+format)) {
+		parser.OnError(fmt.Errorf("error #12: can only write .%s files",
+			format))
+	}
+	config := tdb.NewConfig(readTdb(infile, parser.OnError))
+	outFile := createOutfile(outfile, parser.OnError)
+	defer outFile.Close()
+	var err error
+	if format == "ini" {
+		err = config.WriteINI(outFile)
+	} else {
+		err = config.WriteTOML(outFile)
+	}
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write outfile %q: %s",
+			outfile, err))
+	}
+}
+
+// getTable returns the given table or the first table if tableName is "".
+func getTable(db *tdb.Tdb, tableName string,
+	onError func(error)) *tdb.Table {
+	if tableName == "" {
+		if len(db.TableNames) == 0 {
+			onError(errors.New("error #13: there are no tables"))
+		}
+		tableName = db.TableNames[0]
+	}
+	table, ok := db.Tables[tableName]
+	if !ok {
+		onError(fmt.Errorf("error #13: no table called %q", tableName))
+	}
+	return table
+}
+
+func onFormatError(subcommand, format string) {
+	formats := "csv|ini|json|sql|toml"
+	if subcommand == "import" {
+		formats = "csv|ini|json|sqlite|toml"
+	}
+	fmt.Fprintf(os.Stderr, "error #9: usage: tdb %s %s ... (use tdb %s "+
+		"FORMAT -h for help); unsupported format %q\n", subcommand,
+		formats, subcommand, format)
+	os.Exit(2)
+}
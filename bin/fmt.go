@@ -0,0 +1,64 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"strings"
+)
+
+func runFmt(args []string) {
+	parser := clip.NewParserUser("tdb fmt", "")
+	parser.LongDesc = "Formats a Tdb file in the standard format or in " +
+		"canonical format, which always writes the same data the same " +
+		"way: one record per line (except for multiline strs and long " +
+		"bytes, which are wrapped), and reals always with a decimal " +
+		"point. For minimal git diffs of .tdb files, run: git config " +
+		"diff.tdb.textconv \"tdb fmt --canonical\" and add the line: " +
+		"*.tdb diff=tdb to .gitattributes."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .tdb file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .tdb file."
+	canonicalOpt := parser.Flag("canonical", "Use canonical format.")
+	sortTablesOpt := parser.Flag("sorttables", "Write the tables in name "+
+		"order (implies --canonical).")
+	sortTablesOpt.SetShortName('T')
+	sortRecordsOpt := parser.Flag("sortrecords", "Write each table's "+
+		"records in order of their first field (implies --canonical).")
+	sortRecordsOpt.SetShortName('R')
+	decimalsOpt := parser.IntInRange("decimals", "How many decimal digits "+
+		"to use if not canonical. Range 1-19 or 0 (few as possible; the "+
+		"default).", 0, 19, 0)
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".tdb")) {
+		parser.OnError(errors.New("error #2: can only write Tdb format"))
+	}
+	db := readTdb(infile, parser.OnError)
+	opts := tdb.CanonicalOptions{SortTables: sortTablesOpt.Value(),
+		SortRecords: sortRecordsOpt.Value()}
+	if !(canonicalOpt.Value() || opts.SortTables || opts.SortRecords) {
+		writeTdb(db, outfile, decimalsOpt.Value(), parser.OnError)
+		return
+	}
+	outFile := createOutfile(outfile, parser.OnError)
+	defer outFile.Close()
+	if err := db.WriteCanonical(outFile, opts); err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write outfile %q: %s",
+			outfile, err))
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runImport(args []string) {
+	format := ""
+	if len(args) > 0 {
+		format = args[0]
+		args = args[1:]
+	}
+	switch format {
+	case "csv":
+		importCSV(args)
+	case "json":
+		importJSON(args)
+	case "sqlite":
+		importSQLite(args)
+	case "ini", "toml":
+		importConfig(format, args)
+	default:
+		onFormatError("import", format)
+	}
+}
+
+func importCSV(args []string) {
+	parser := clip.NewParserUser("tdb import csv", "")
+	parser.LongDesc = "Converts CSV to Tdb. Field types are inferred " +
+		"from the data unless given using --types."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .csv (or .tsv) file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .tdb file."
+	tableOpt := parser.Str("table", "The table's name (default: FILE1's "+
+		"basename).", "")
+	noHeaderOpt := parser.Flag("noheader", "The CSV has no header row "+
+		"so name the fields Field1, Field2, etc.")
+	emptyIsNullOpt := parser.Flag("nulls", "Treat empty cells as "+
+		"nulls (and make their fields nullable).")
+	emptyIsNullOpt.SetShortName('N')
+	typesOpt := parser.Strs("types", "Field types given as "+
+		"FIELD:TYPENAME, e.g., price:real date:date? (if this is the "+
+		"last option, follow its values with --).")
+	typesOpt.SetShortName('T')
+	commaOpt := parser.Str("comma", "The field separator (default: , or "+
+		"tab for .tsv files).", ",")
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".tdb")) {
+		parser.OnError(fmt.Errorf("error #2: can only write Tdb format"))
+	}
+	opts := tdb.CSVOptions{TableName: tableOpt.Value(),
+		NoHeader: noHeaderOpt.Value(), EmptyIsNull: emptyIsNullOpt.Value(),
+		Types: make(map[string]string)}
+	if opts.TableName == "" {
+		opts.TableName = strings.TrimSuffix(filepath.Base(infile),
+			filepath.Ext(infile))
+	}
+	if strings.HasSuffix(infile, ".tsv") && !commaOpt.Given() {
+		opts.Comma = '\t'
+	} else if comma := []rune(commaOpt.Value()); len(comma) == 1 {
+		opts.Comma = comma[0]
+	} else {
+		parser.OnError(fmt.Errorf("error #10: invalid separator %q",
+			commaOpt.Value()))
+	}
+	for _, spec := range typesOpt.Value() {
+		name, typeName, ok := strings.Cut(spec, ":")
+		if !ok {
+			parser.OnError(fmt.Errorf(
+				"error #10: expected FIELD:TYPENAME, got %q", spec))
+		}
+		opts.Types[name] = typeName
+	}
+	inFile, err := os.Open(infile)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #3: failed to open infile %q: %s",
+			infile, err))
+	}
+	defer inFile.Close()
+	table, err := tdb.FromCSV(inFile, opts)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #11: failed to import %q: %s",
+			infile, err))
+	}
+	db := tdb.NewTdb()
+	db.AddTable(table)
+	writeTdb(&db, outfile, 0, parser.OnError)
+}
+
+func importJSON(args []string) {
+	parser := clip.NewParserUser("tdb import json", "")
+	parser.LongDesc = "Converts JSON (an array of objects, or NDJSON) to " +
+		"a Tdb table. Each object is a record keyed by fieldname. Field " +
+		"types are inferred from the data."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .json (or .ndjson) file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .tdb file."
+	tableOpt := parser.Str("table", "The table's name (default: FILE1's "+
+		"basename).", "")
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".tdb")) {
+		parser.OnError(fmt.Errorf("error #2: can only write Tdb format"))
+	}
+	tableName := tableOpt.Value()
+	if tableName == "" {
+		tableName = strings.TrimSuffix(filepath.Base(infile),
+			filepath.Ext(infile))
+	}
+	inFile, err := os.Open(infile)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #3: failed to open infile %q: %s",
+			infile, err))
+	}
+	defer inFile.Close()
+	table, err := tdb.FromJSON(inFile, tableName)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #11: failed to import %q: %s",
+			infile, err))
+	}
+	db := tdb.NewTdb()
+	db.AddTable(table)
+	writeTdb(&db, outfile, 0, parser.OnError)
+}
+
+func importSQLite(args []string) {
+	parser := clip.NewParserUser("tdb import sqlite", "")
+	parser.LongDesc = "Converts an SQLite database's tables to Tdb " +
+		"tables. Field types are taken from the declared column types."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be an SQLite 3 database file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .tdb file."
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".tdb")) {
+		parser.OnError(fmt.Errorf("error #2: can only write Tdb format"))
+	}
+	raw, err := os.ReadFile(infile)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #4: failed to read infile %q: %s",
+			infile, err))
+	}
+	db, err := tdb.ParseSQLite(raw)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #11: failed to import %q: %s",
+			infile, err))
+	}
+	writeTdb(db, outfile, 0, parser.OnError)
+}
+
+func importConfig(format string, args []string) {
+	parser := clip.NewParserUser("tdb import "+format, "")
+	parser.LongDesc = "Converts a ." + format + " file to Tdb config " +
+		"tables (e.g., config_int and config_str) with a group field if " +
+		"any keys are grouped."
+	parser.PositionalCount = clip.OneOrTwoPositionals
+	parser.PositionalHelp = "FILE1 must be a ." + format + " file. " +
+		"If FILE2 is - or not given, output is to stdout; " +
+		"otherwise to the given .tdb file."
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	outfile := "-"
+	if len(parser.Positionals) == 2 {
+		outfile = parser.Positionals[1]
+	}
+	if !(outfile == "-" || strings.HasSuffix(outfile, ".tdb")) {
+		parser.OnError(fmt.Errorf("error #2: can only write Tdb format"))
+	}
+	inFile, err := os.Open(infile)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #3: failed to open infile %q: %s",
+			infile, err))
+	}
+	defer inFile.Close()
+	var config *tdb.Config
+	if format == "ini" {
+		config, err = tdb.ConfigFromINI(inFile)
+	} else {
+		config, err = tdb.ConfigFromTOML(inFile)
+	}
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #11: failed to import %q: %s",
+			infile, err))
+	}
+	writeTdb(config.Db, outfile, 0, parser.OnError)
+}
@@ -0,0 +1,82 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runInfer(args []string) {
+	parser := clip.NewParserUser("tdb infer", "")
+	parser.LongDesc = "Infers field types from untyped data and outputs " +
+		"the corresponding Tdb table definition to stdout."
+	parser.PositionalCount = clip.OnePositional
+	parser.PositionalHelp = "FILE must be a .csv or .tsv file."
+	tableOpt := parser.Str("table", "The table's name (default: FILE's "+
+		"basename).", "")
+	noHeaderOpt := parser.Flag("noheader", "The data has no header row "+
+		"so name the fields Field1, Field2, etc.")
+	confidenceOpt := parser.RealInRange("confidence", "The minimum "+
+		"proportion of a field's values that must fit its type (default: "+
+		"1, i.e., all).", 0.5, 1, 1)
+	verboseOpt := parser.Flag("verbose", "Also report each field's "+
+		"type and confidence to stderr.")
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	tableName := tableOpt.Value()
+	if tableName == "" {
+		tableName = strings.TrimSuffix(filepath.Base(infile),
+			filepath.Ext(infile))
+	}
+	inFile, err := os.Open(infile)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #3: failed to open infile %q: %s",
+			infile, err))
+	}
+	defer inFile.Close()
+	reader := csv.NewReader(inFile)
+	if strings.HasSuffix(infile, ".tsv") {
+		reader.Comma = '\t'
+	} else if !strings.HasSuffix(infile, ".csv") {
+		parser.OnError(fmt.Errorf(
+			"error #14: can only infer from .csv or .tsv files"))
+	}
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) == 0 {
+		parser.OnError(fmt.Errorf("error #4: failed to read infile %q: %v",
+			infile, err))
+	}
+	names := rows[0]
+	if noHeaderOpt.Value() {
+		names = make([]string, 0, len(rows[0]))
+		for i := range rows[0] {
+			names = append(names, fmt.Sprintf("Field%d", i+1))
+		}
+	} else {
+		rows = rows[1:]
+	}
+	table, inferences := tdb.InferTable(tableName, names, rows,
+		confidenceOpt.Value())
+	if verboseOpt.Value() {
+		for i, inference := range inferences {
+			fmt.Fprintf(os.Stderr, "%s %s %.0f%%", table.Fields[i].Name,
+				inference.TypeName(), inference.Confidence*100)
+			if inference.Invalid > 0 {
+				fmt.Fprintf(os.Stderr, " (%d invalid)", inference.Invalid)
+			}
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	db := tdb.NewTdb()
+	db.AddTable(table)
+	writeTdb(&db, "-", 0, parser.OnError)
+}
@@ -0,0 +1,46 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+)
+
+func runMergeDriver(args []string) {
+	parser := clip.NewParserUser("tdb merge-driver", "")
+	parser.LongDesc = "Does a three-way merge of Tdb files for git, " +
+		"matching records by their table's first field unless key fields " +
+		"are given. The result is written to OURS, with any conflicts " +
+		"listed in a tdb_conflicts table (so that the file still " +
+		"parses). The exit status is 0 if the merge is clean and 1 if " +
+		"there are conflicts. To use it, run: git config merge.tdb.driver " +
+		"\"tdb merge-driver %O %A %B\" and add the line: *.tdb merge=tdb " +
+		"to .gitattributes."
+	parser.PositionalCount = clip.ThreePositionals
+	parser.PositionalHelp = "BASE OURS THEIRS: the common ancestor, ours " +
+		"(which is overwritten with the result), and theirs (git's %O " +
+		"%A %B)."
+	keysOpt := parser.Strs("keys", "Key fields to match records by, "+
+		"given as TABLE:FIELD[,FIELD...], e.g., emp:empno dept:deptno (if "+
+		"this is the last option, follow its values with --).")
+	keysOpt.SetShortName('K')
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	keys := getKeys(keysOpt.Value(), parser.OnError)
+	base := readTdb(parser.Positionals[0], parser.OnError)
+	ours := readTdb(parser.Positionals[1], parser.OnError)
+	theirs := readTdb(parser.Positionals[2], parser.OnError)
+	db, conflicts, err := tdb.MergeKeys(base, ours, theirs, keys)
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #16: failed to merge: %s", err))
+	}
+	writeTdb(db, parser.Positionals[1], 0, parser.OnError)
+	if len(conflicts) > 0 {
+		os.Exit(1)
+	}
+}
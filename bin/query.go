@@ -0,0 +1,61 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+	"strings"
+)
+
+func runQuery(args []string) {
+	parser := clip.NewParserUser("tdb query", "")
+	parser.LongDesc = "Runs an SQL SELECT query against a Tdb file and " +
+		"outputs the result table to stdout in Tdb format, as CSV, or " +
+		"as a GitHub Markdown table, an HTML table, or a box-drawn text " +
+		"grid."
+	parser.PositionalCount = clip.TwoPositionals
+	parser.PositionalHelp = "FILE1 must be a .tdb file and FILE2 an SQL " +
+		"SELECT query (normally quoted), e.g., \"SELECT ename, dname " +
+		"FROM emp JOIN dept USING (deptno) WHERE sal > 2000\"."
+	formatOpt := parser.Choice("format", "The output format.",
+		[]string{"tdb", "csv", "md", "html", "grid"}, "tdb")
+	decimalsOpt := parser.IntInRange("decimals", "How many decimal digits "+
+		"to use for Tdb output. Range 1-19 or 0 (few as possible; the "+
+		"default).", 0, 19, 0)
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	db := readTdb(infile, parser.OnError)
+	table, err := tdb.Query(db, parser.Positionals[1])
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #8: failed to run query: %s",
+			err))
+	}
+	switch formatOpt.Value() {
+	case "tdb":
+		result := tdb.NewTdb()
+		result.AddTable(table)
+		writeTdb(&result, "-", decimalsOpt.Value(), parser.OnError)
+		return
+	case "csv":
+		err = table.WriteCSV(os.Stdout)
+	case "md":
+		err = table.Render(os.Stdout, tdb.Markdown)
+	case "html":
+		err = table.Render(os.Stdout, tdb.HTML)
+	default:
+		err = table.Render(os.Stdout, tdb.Grid)
+	}
+	if err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write: %s", err))
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mark-summerfield/clip"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"os"
+	"strings"
+)
+
+func runShow(args []string) {
+	parser := clip.NewParserUser("tdb show", "")
+	parser.LongDesc = "Outputs a table in a Tdb file as a GitHub Markdown " +
+		"table, an HTML table, or a box-drawn text grid."
+	parser.PositionalCount = clip.OnePositional
+	parser.PositionalHelp = "FILE must be a .tdb file."
+	tableOpt := parser.Str("table", "The table to show (default: the "+
+		"first table).", "")
+	formatOpt := parser.Choice("format", "The output format.",
+		[]string{"md", "html", "grid"}, "grid")
+	widthOpt := parser.IntInRange("width", "The maximum width of a "+
+		"column: longer values are truncated (md) or wrapped (grid). Use "+
+		"0 for no maximum.", 0, 10000, tdb.DefaultRenderWidth)
+	if err := parser.ParseArgs(args); err != nil {
+		fmt.Println(err)
+	}
+	infile := parser.Positionals[0]
+	if !strings.HasSuffix(infile, ".tdb") {
+		parser.OnError(errors.New("error #1: can only read .tdb files"))
+	}
+	db := readTdb(infile, parser.OnError)
+	table := getTable(db, tableOpt.Value(), parser.OnError)
+	format := tdb.Grid
+	switch formatOpt.Value() {
+	case "md":
+		format = tdb.Markdown
+	case "html":
+		format = tdb.HTML
+	}
+	if err := table.RenderWidth(os.Stdout, format,
+		widthOpt.Value()); err != nil {
+		parser.OnError(fmt.Errorf("error #7: failed to write: %s", err))
+	}
+}
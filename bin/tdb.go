@@ -14,45 +14,52 @@ import (
 )
 
 func main() {
-	config, onError := getConfig()
-	inFile, err := os.Open(config.infile)
-	if err != nil {
-		onError(fmt.Errorf("error #3: failed to open infile %q: %s",
-			config.infile, err))
-	}
-	defer inFile.Close()
-	raw, err := io.ReadAll(inFile)
-	if err != nil {
-		onError(fmt.Errorf("error #4: failed to read infile %q: %s",
-			config.infile, err))
-	}
-	db, err := tdb.Parse(raw)
-	if err != nil {
-		onError(fmt.Errorf("error #5: failed to parse infile %q: %s",
-			config.infile, err))
-	}
-	var outFile *os.File
-	if config.outfile == "-" {
-		outFile = os.Stdout
-	} else {
-		outFile, err = os.OpenFile(config.outfile, os.O_CREATE|
-			os.O_WRONLY, 0755)
-		if err != nil {
-			onError(fmt.Errorf("error #6: failed to open outfile %q: %s",
-				config.outfile, err))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "query":
+			runQuery(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "infer":
+			runInfer(os.Args[2:])
+			return
+		case "show":
+			runShow(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "merge-driver":
+			runMergeDriver(os.Args[2:])
+			return
+		case "fmt":
+			runFmt(os.Args[2:])
+			return
 		}
-		defer outFile.Close()
-	}
-	err = db.WriteDecimals(outFile, config.decimals)
-	if err != nil {
-		onError(fmt.Errorf("error #7: failed to write outfile %q: %s",
-			config.outfile, err))
 	}
+	config, onError := getConfig()
+	db := readTdb(config.infile, onError)
+	writeTdb(db, config.outfile, config.decimals, onError)
 }
 
 func getConfig() (config, func(error)) {
 	parser := clip.NewParser()
-	parser.LongDesc = "Converts Tdb input to Tdb in the standard format."
+	parser.LongDesc = "Converts Tdb input to Tdb in the standard format. " +
+		"Or use one of the subcommands: query (run an SQL SELECT " +
+		"query), import csv|ini|json|sqlite|toml (convert CSV, INI, " +
+		"JSON, an SQLite database, or TOML to Tdb), export " +
+		"csv|ini|json|sql|toml (convert a Tdb table to CSV, or Tdb to " +
+		"INI, JSON, SQL, or TOML), infer (output a table " +
+		"definition for CSV data), show (output a table as Markdown, " +
+		"HTML, or a text grid), diff (output the differences " +
+		"between two Tdb files), merge-driver (a three-way merge " +
+		"for git), or fmt (format Tdb, e.g., canonically); use tdb " +
+		"SUBCOMMAND -h for a subcommand's help."
 	parser.PositionalCount = clip.OneOrTwoPositionals
 	parser.PositionalHelp = "FILE1 must be a .tdb file. " +
 		"If FILE2 is - or not given, output is to stdout; " +
@@ -83,3 +90,49 @@ type config struct {
 	infile   string
 	outfile  string
 }
+
+func readTdb(filename string, onError func(error)) *tdb.Tdb {
+	inFile, err := os.Open(filename)
+	if err != nil {
+		onError(fmt.Errorf("error #3: failed to open infile %q: %s",
+			filename, err))
+	}
+	defer inFile.Close()
+	raw, err := io.ReadAll(inFile)
+	if err != nil {
+		onError(fmt.Errorf("error #4: failed to read infile %q: %s",
+			filename, err))
+	}
+	db, err := tdb.Parse(raw)
+	if err != nil {
+		onError(fmt.Errorf("error #5: failed to parse infile %q: %s",
+			filename, err))
+	}
+	return db
+}
+
+func writeTdb(db *tdb.Tdb, filename string, decimals int,
+	onError func(error)) {
+	outFile := createOutfile(filename, onError)
+	defer outFile.Close()
+	err := db.WriteDecimals(outFile, decimals)
+	if err != nil {
+		onError(fmt.Errorf("error #7: failed to write outfile %q: %s",
+			filename, err))
+	}
+}
+
+// createOutfile returns the given file opened for writing, or stdout if
+// filename is "-".
+func createOutfile(filename string, onError func(error)) *os.File {
+	if filename == "-" {
+		return os.Stdout
+	}
+	outFile, err := os.OpenFile(filename,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		onError(fmt.Errorf("error #6: failed to open outfile %q: %s",
+			filename, err))
+	}
+	return outFile
+}
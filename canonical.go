@@ -0,0 +1,89 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"encoding/hex"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CanonicalBytesWidth is how many bytes [Tdb.WriteCanonical] writes per
+// line.
+const CanonicalBytesWidth = 32
+
+// CanonicalOptions control the ordering used by [Tdb.WriteCanonical].
+type CanonicalOptions struct {
+	SortTables  bool // Write the tables in name order
+	SortRecords bool // Write each table's records in key order
+}
+
+// WriteCanonical writes the Tdb to out in canonical Tdb format, i.e.,
+// whoever wrote or edited the Tdb, the same data is always written the same
+// way, so that (for example) git diffs of .tdb files are minimal and
+// reproducible. (The tdb fmt --canonical command uses this, e.g., as a git
+// textconv.)
+//
+// Each record is written on one line, except that strs' newlines are kept
+// as is and bytes longer than [CanonicalBytesWidth] are wrapped (with
+// [CanonicalBytesWidth] bytes per line). Reals are written in the fewest
+// digits that read back as the same value, but always with a decimal point
+// (e.g., 800.0). Other values are written as by [Tdb.Write].
+//
+// If opts.SortTables is true the tables are written in order of their
+// names; if opts.SortRecords is true each table's records are written in
+// order of their first value (which is treated as the table's primary key),
+// then their second value, and so on. The Tdb itself is not changed.
+func (me *Tdb) WriteCanonical(out io.Writer, opts CanonicalOptions) error {
+	db := *me
+	if opts.SortTables {
+		db.TableNames = slices.Clone(me.TableNames)
+		slices.Sort(db.TableNames)
+	}
+	if opts.SortRecords {
+		db.Tables = make(map[string]*Table, len(me.Tables))
+		for tableName, table := range me.Tables {
+			sorted := *table
+			sorted.Records = slices.Clone(table.Records)
+			slices.SortStableFunc(sorted.Records, compareRecords)
+			db.Tables[tableName] = &sorted
+		}
+	}
+	return db.write(out, writeCanonicalValue)
+}
+
+func writeCanonicalValue(out io.Writer, value any,
+	fieldMeta *MetaFieldType) error {
+	switch v := value.(type) {
+	case float64:
+		if fieldMeta.Kind == RealField {
+			text := strconv.FormatFloat(v, 'f', -1, 64)
+			if !math.IsInf(v, 0) && !math.IsNaN(v) &&
+				!strings.ContainsRune(text, '.') {
+				text += ".0"
+			}
+			_, err := out.Write([]byte(text))
+			return err
+		}
+	case []byte:
+		if fieldMeta.Kind == BytesField && len(v) > CanonicalBytesWidth {
+			var text strings.Builder
+			text.WriteByte('(')
+			for len(v) > 0 {
+				n := min(len(v), CanonicalBytesWidth)
+				text.WriteString(hex.EncodeToString(v[:n]))
+				if v = v[n:]; len(v) > 0 {
+					text.WriteByte('\n')
+				}
+			}
+			text.WriteByte(')')
+			_, err := out.Write([]byte(text.String()))
+			return err
+		}
+	}
+	return writeValue(out, value, fieldMeta, -1)
+}
@@ -0,0 +1,146 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"math"
+	"slices"
+)
+
+// EqualOptions control how [Tdb.Equal] and [Table.Equal] compare.
+type EqualOptions struct {
+	IgnoreTableOrder  bool    // Tdbs may hold their tables in any order
+	IgnoreRecordOrder bool    // Tables may hold their records in any order
+	Tolerance         float64 // Reals are equal if they differ by at most this
+}
+
+// Clone returns a deep copy of the Tdb. The copy's tables have no indexes.
+func (me *Tdb) Clone() *Tdb {
+	db := NewTdb()
+	for _, tableName := range me.TableNames {
+		db.AddTable(me.Tables[tableName].Clone())
+	}
+	return &db
+}
+
+// Clone returns a deep copy of the table without any indexes.
+func (me *Table) Clone() *Table {
+	table := NewTable()
+	table.Name = me.Name
+	for _, field := range me.Fields {
+		table.Fields = append(table.Fields, &MetaFieldType{field.Name,
+			field.Kind, field.AllowNull})
+	}
+	table.Records = slices.Grow(table.Records, len(me.Records))
+	for _, record := range me.Records {
+		copied := slices.Clone(record)
+		for column, value := range copied {
+			if b, ok := value.([]byte); ok {
+				copied[column] = slices.Clone(b)
+			}
+		}
+		table.Records = append(table.Records, copied)
+	}
+	return &table
+}
+
+// Equal returns true if the Tdb has the same tables as other (see
+// [Table.Equal]), in the same order unless opts.IgnoreTableOrder is true.
+func (me *Tdb) Equal(other *Tdb, opts EqualOptions) bool {
+	if len(me.TableNames) != len(other.TableNames) {
+		return false
+	}
+	for i, tableName := range me.TableNames {
+		if !opts.IgnoreTableOrder && other.TableNames[i] != tableName {
+			return false
+		}
+		table, ok := other.Tables[tableName]
+		if !ok || !me.Tables[tableName].Equal(table, opts) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal returns true if the table has the same name, fields, and records as
+// other, with the records in the same order unless opts.IgnoreRecordOrder
+// is true. Values are compared by meaning rather than by representation:
+// for example, empty bytes are equal whether nil or not, and dates and
+// datetimes are equal if they are the same instant whatever their time
+// zones. NaN reals are equal to each other, and other reals are equal if
+// they differ by at most opts.Tolerance.
+//
+// If opts.IgnoreRecordOrder is true, each table's records are sorted (by
+// their non-real values first) and then compared row by row. So with a
+// non-zero opts.Tolerance, records whose non-real values are all the same
+// are matched in order of their reals, and tables that are equal within the
+// tolerance may compare unequal, e.g., records {1.00, 2.0} and {1.08, 1.0}
+// vs {1.09, 2.0} and {1.01, 1.0} at a tolerance of 0.1.
+func (me *Table) Equal(other *Table, opts EqualOptions) bool {
+	if me.Name != other.Name || len(me.Fields) != len(other.Fields) ||
+		len(me.Records) != len(other.Records) {
+		return false
+	}
+	for column, field := range me.Fields {
+		if *field != *other.Fields[column] {
+			return false
+		}
+	}
+	records, others := me.Records, other.Records
+	if opts.IgnoreRecordOrder {
+		// Sort by the reals last so that reals that differ within the
+		// tolerance affect the order as little as possible.
+		columns := make([]int, 0, len(me.Fields))
+		for _, reals := range []bool{false, true} {
+			for column, field := range me.Fields {
+				if (field.Kind == RealField) == reals {
+					columns = append(columns, column)
+				}
+			}
+		}
+		compare := func(a, b Record) int {
+			for _, column := range columns {
+				if c := compareValues(a[column], b[column]); c != 0 {
+					return c
+				}
+			}
+			return 0
+		}
+		records = slices.Clone(records)
+		slices.SortFunc(records, compare)
+		others = slices.Clone(others)
+		slices.SortFunc(others, compare)
+	}
+	for row, record := range records {
+		for column, value := range record {
+			if !valuesEqual(value, others[row][column], opts.Tolerance) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compareRecords orders records by their values, first to last.
+func compareRecords(a, b Record) int {
+	for column, value := range a {
+		if c := compareValues(value, b[column]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// valuesEqual returns true if a and b are equal (see [Table.Equal]).
+func valuesEqual(a, b any, tolerance float64) bool {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			if math.IsNaN(x) || math.IsNaN(y) {
+				return math.IsNaN(x) && math.IsNaN(y)
+			}
+			return x == y || math.Abs(x-y) <= tolerance
+		}
+	}
+	return compareValues(a, b) == 0
+}
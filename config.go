@@ -0,0 +1,377 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Config is a key–value configuration held in a Tdb using the config
+// tables described in the README: config_int and config_str (and also
+// config_bool and config_real), each with a key str field, a value field of
+// the table's kind, and (if any of its keys are grouped) a group str? field
+// that is null for ungrouped keys. Any other tables are left alone, so a
+// Config's Tdb may hold other data too.
+//
+// A group of "" means ungrouped (equivalent to an .ini file's "General"
+// group). Each group's keys are unique across all the config tables.
+type Config struct {
+	Db       *Tdb
+	Filename string // The .tdb file that Save writes to
+}
+
+// configKinds are the kinds of value a Config can hold in the order their
+// tables are created and written.
+var configKinds = []FieldKind{BoolField, IntField, RealField, StrField}
+
+// NewConfig returns a Config for the given Tdb (or for a new empty Tdb if
+// db is nil) that has no filename.
+func NewConfig(db *Tdb) *Config {
+	if db == nil {
+		empty := NewTdb()
+		db = &empty
+	}
+	return &Config{db, ""}
+}
+
+// ReadConfig returns a Config read from the given .tdb file. If the file
+// doesn't exist the Config is empty (and Save will create the file).
+func ReadConfig(filename string) (*Config, error) {
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		config := NewConfig(nil)
+		config.Filename = filename
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	db, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{db, filename}, nil
+}
+
+// Save writes the Config's Tdb to its Filename. The file is written
+// atomically: the Tdb is written to a temporary file that is then renamed
+// over the original, so a failed save leaves the original unchanged.
+func (me *Config) Save() error {
+	if me.Filename == "" {
+		return fmt.Errorf("e%d#can't save a config with no filename",
+			e185)
+	}
+	return writeFileAtomically(me.Filename, false, me.Db.Write)
+}
+
+// Get returns the value of the given group's key and true, or nil and
+// false if there's no such key.
+func (me *Config) Get(group, key string) (any, bool) {
+	for _, kind := range configKinds {
+		table, columns := me.table(kind)
+		if table == nil {
+			continue
+		}
+		if row := columns.find(table, group, key); row > -1 {
+			return table.Records[row][columns.value], true
+		}
+	}
+	return nil, false
+}
+
+// GetBool returns the given group's key's bool value and true, or false and
+// false if there's no such key or its value isn't a bool.
+func (me *Config) GetBool(group, key string) (bool, bool) {
+	value, _ := me.Get(group, key)
+	b, ok := value.(bool)
+	return b, ok
+}
+
+// GetInt returns the given group's key's int value and true, or 0 and
+// false if there's no such key or its value isn't an int.
+func (me *Config) GetInt(group, key string) (int, bool) {
+	value, _ := me.Get(group, key)
+	i, ok := value.(int)
+	return i, ok
+}
+
+// GetReal returns the given group's key's real value and true, or 0 and
+// false if there's no such key or its value isn't a real.
+func (me *Config) GetReal(group, key string) (float64, bool) {
+	value, _ := me.Get(group, key)
+	r, ok := value.(float64)
+	return r, ok
+}
+
+// GetStr returns the given group's key's str value and true, or "" and
+// false if there's no such key or its value isn't a str.
+func (me *Config) GetStr(group, key string) (string, bool) {
+	value, _ := me.Get(group, key)
+	s, ok := value.(string)
+	return s, ok
+}
+
+// Set sets the given group's key to the given value, which must be a
+// bool, int, float64 (but not NaN or infinite), or string. If the key has a
+// value of a different kind it is moved to the table for the new kind. The
+// table (or its group field) is created if necessary.
+func (me *Config) Set(group, key string, value any) error {
+	var kind FieldKind
+	switch v := value.(type) {
+	case bool:
+		kind = BoolField
+	case int:
+		kind = IntField
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("e%d#%s:can't set a config value to %v "+
+				"(reals must be finite)", e184, key, v)
+		}
+		kind = RealField
+	case string:
+		kind = StrField
+	default:
+		return fmt.Errorf("e%d#%s:can't set a config value to %v (%T)",
+			e184, key, value, value)
+	}
+	table, columns := me.table(kind)
+	if table == nil {
+		var err error
+		if table, columns, err = me.addTable(kind); err != nil {
+			return err
+		}
+	}
+	if row := columns.find(table, group, key); row > -1 {
+		return table.UpdateValue(row, "value", value)
+	}
+	me.Delete(group, key)
+	if group != "" && columns.group == -1 {
+		if err := table.AddColumn("group", "str?", nil); err != nil {
+			return err
+		}
+		_, columns = me.table(kind)
+	}
+	record := newRecord(len(table.Fields))
+	record[columns.key] = key
+	record[columns.value] = value
+	if group != "" {
+		record[columns.group] = group
+	}
+	return table.AppendRecord(record)
+}
+
+// Delete deletes the given group's key and returns true, or returns false
+// if there's no such key.
+func (me *Config) Delete(group, key string) bool {
+	deleted := false
+	for _, kind := range configKinds {
+		table, columns := me.table(kind)
+		if table == nil {
+			continue
+		}
+		if table.DeleteRecords(func(record Record) bool {
+			return columns.matches(record, group, key)
+		}) > 0 {
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// Groups returns the config's groups in order of first appearance in the
+// config tables, with "" first if there are any ungrouped keys.
+func (me *Config) Groups() []string {
+	groups := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range me.entries() {
+		if !seen[entry.group] {
+			seen[entry.group] = true
+			groups = append(groups, entry.group)
+		}
+	}
+	if seen[""] && groups[0] != "" {
+		for i := len(groups) - 1; i > 0; i-- { // move "" to the front
+			if groups[i] == "" {
+				copy(groups[1:], groups[:i])
+				groups[0] = ""
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// Keys returns the given group's keys in table order.
+func (me *Config) Keys(group string) []string {
+	keys := make([]string, 0)
+	for _, entry := range me.entries() {
+		if entry.group == group {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+// configEntry is a config key and its value.
+type configEntry struct {
+	group string
+	key   string
+	value any
+	kind  FieldKind
+}
+
+// entries returns all the config's entries in table order.
+func (me *Config) entries() []configEntry {
+	entries := make([]configEntry, 0)
+	for _, kind := range configKinds {
+		table, columns := me.table(kind)
+		if table == nil {
+			continue
+		}
+		for _, record := range table.Records {
+			entry := configEntry{key: record[columns.key].(string),
+				value: record[columns.value], kind: kind}
+			if columns.group > -1 && record[columns.group] != nil {
+				entry.group = record[columns.group].(string)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// groupedEntries returns the config's entries grouped in the order given
+// by Groups.
+func (me *Config) groupedEntries() []configEntry {
+	entries := me.entries()
+	grouped := make([]configEntry, 0, len(entries))
+	for _, group := range me.Groups() {
+		for _, entry := range entries {
+			if entry.group == group {
+				grouped = append(grouped, entry)
+			}
+		}
+	}
+	return grouped
+}
+
+// newConfigFromEntries returns a new Config with a config table for each
+// kind of value in the given entries, laid out as in the README: with a
+// leading group field if any of the entries are grouped. If a group's key
+// appears more than once, the last entry is used.
+func newConfigFromEntries(entries []configEntry) *Config {
+	config := NewConfig(nil)
+	grouped := false
+	index := make(map[[2]string]int)
+	unique := make([]configEntry, 0, len(entries))
+	for _, entry := range entries {
+		grouped = grouped || entry.group != ""
+		if i, ok := index[[2]string{entry.group, entry.key}]; ok {
+			unique[i] = entry
+		} else {
+			index[[2]string{entry.group, entry.key}] = len(unique)
+			unique = append(unique, entry)
+		}
+	}
+	for _, kind := range configKinds {
+		table := NewTable()
+		table.Name = "config_" + kind.String()
+		if grouped {
+			table.Fields = append(table.Fields, &MetaFieldType{"group",
+				StrField, true})
+		}
+		table.Fields = append(table.Fields, &MetaFieldType{"key", StrField,
+			false}, &MetaFieldType{"value", kind, false})
+		for _, entry := range unique {
+			if entry.kind != kind {
+				continue
+			}
+			record := Record{entry.key, entry.value}
+			if grouped {
+				var group any
+				if entry.group != "" {
+					group = entry.group
+				}
+				record = Record{group, entry.key, entry.value}
+			}
+			table.Records = append(table.Records, record)
+		}
+		if len(table.Records) > 0 {
+			config.Db.AddTable(&table)
+		}
+	}
+	return config
+}
+
+// configColumns are the columns of a config table's fields.
+type configColumns struct {
+	group int // -1 if the table has no group field
+	key   int
+	value int
+}
+
+// find returns the row of the given group's key in the table or -1.
+func (me configColumns) find(table *Table, group, key string) int {
+	for row, record := range table.Records {
+		if me.matches(record, group, key) {
+			return row
+		}
+	}
+	return -1
+}
+
+func (me configColumns) matches(record Record, group, key string) bool {
+	if record[me.key] != key {
+		return false
+	}
+	if me.group == -1 || record[me.group] == nil {
+		return group == ""
+	}
+	return record[me.group] == group
+}
+
+// table returns the config table for the given kind and its columns, or
+// nil if there is no such table or it doesn't have the config fields.
+func (me *Config) table(kind FieldKind) (*Table, configColumns) {
+	columns := configColumns{-1, -1, -1}
+	table, ok := me.Db.Tables["config_"+kind.String()]
+	if !ok {
+		return nil, columns
+	}
+	for column, field := range table.Fields {
+		switch {
+		case field.Name == "group" && field.Kind == StrField:
+			columns.group = column
+		case field.Name == "key" && field.Kind == StrField &&
+			!field.AllowNull:
+			columns.key = column
+		case field.Name == "value" && field.Kind == kind:
+			columns.value = column
+		}
+	}
+	if columns.key == -1 || columns.value == -1 {
+		return nil, columns
+	}
+	return table, columns
+}
+
+// addTable adds a new config table for the given kind.
+func (me *Config) addTable(kind FieldKind) (*Table, configColumns,
+	error) {
+	name := "config_" + kind.String()
+	if _, ok := me.Db.Tables[name]; ok {
+		return nil, configColumns{}, fmt.Errorf("e%d#%s:isn't a config "+
+			"table: it needs key str and value %s fields", e184, name,
+			kind)
+	}
+	table := NewTable()
+	table.Name = name
+	table.Fields = append(table.Fields, &MetaFieldType{"key", StrField,
+		false}, &MetaFieldType{"value", kind, false})
+	me.Db.AddTable(&table)
+	return &table, configColumns{-1, 0, 1}, nil
+}
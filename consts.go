@@ -11,10 +11,11 @@ import (
 
 // These are really constants.
 var (
-	byteSliceType = reflect.TypeOf([]byte(nil))
-	dateTimeType  = reflect.TypeOf(time.Time{})
-	reservedWords gset.Set[string]
-	emptyBytes    = []byte{}
+	byteSliceType    = reflect.TypeOf([]byte(nil))
+	dateTimeType     = reflect.TypeOf(time.Time{})
+	reservedWords    gset.Set[string]
+	builtinConstants gset.Set[string]
+	emptyBytes       = []byte{}
 )
 
 const (
@@ -76,9 +77,59 @@ const (
 	e144
 	e145
 	e146
+	e147
+	e148
+	e149
+	e150
+	e151
+	e152
+	e153
+	e154
+	e155
+	e156
+	e157
+	e158
+	e159
+	e160
+	e161
+	e162
+	e163
+	e164
+	e165
+	e166
+	e167
+	e168
+	e169
+	e170
+	e171
+	e172
+	e173
+	e174
+	e175
+	e176
+	e177
+	e178
+	e179
+	e180
+	e181
+	e182
+	e183
+	e184
+	e185
+	e186
+	e187
+	e188
+	e189
+	e190
+	e191
+	e192
+	e193
+	e194
+	e195
 )
 
 func init() {
 	reservedWords = gset.New("bool", "bytes", "date", "datetime", "int",
 		"real", "str")
+	builtinConstants = gset.New("f", "F", "n", "N", "t", "T", "y", "Y")
 }
@@ -0,0 +1,166 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSVOptions are the options for [FromCSV]. The zero value is usable: the
+// first row is a header, the table is called Table, every field's type is
+// inferred, and empty cells are empty strings.
+type CSVOptions struct {
+	TableName   string            // The table's name (default "Table")
+	NoHeader    bool              // If true fields are named Field1, ...
+	Types       map[string]string // Key is fieldname; value typename
+	EmptyIsNull bool              // If true empty cells are null
+	Comma       rune              // The separator (default ',')
+}
+
+// FromCSV reads CSV data from r and returns it as a [Table].
+//
+// Field names are taken from the header row (unless opts.NoHeader is true)
+// and made into valid Tdb identifiers if necessary (e.g., "Unit Price"
+// becomes Unit_Price).
+//
+// Each field's type is inferred from its values using [InferKind] (with
+// every value having to fit). Use opts.Types to set a field's typename
+// (e.g., "int" or "date?") instead; its keys may be the header names or the
+// corresponding Tdb field names.
+//
+// Bytes are expected to be in hex (as written by [Table.WriteCSV]).
+//
+// If opts.EmptyIsNull is true, empty (or whitespace-only) cells are null
+// and any field with such a cell is nullable; otherwise empty cells are
+// empty strings (so any field with such a cell is a str).
+func FromCSV(r io.Reader, opts CSVOptions) (*Table, error) {
+	reader := csv.NewReader(r)
+	if opts.Comma != 0 {
+		reader.Comma = opts.Comma
+	}
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("e%d#failed to read CSV: %s", e173, err)
+	}
+	tableName := opts.TableName
+	if tableName == "" {
+		tableName = "Table"
+	}
+	var names []string
+	if len(rows) > 0 && !opts.NoHeader {
+		names = rows[0]
+		rows = rows[1:]
+	} else if len(rows) > 0 {
+		for i := range rows[0] {
+			names = append(names, fmt.Sprintf("Field%d", i+1))
+		}
+	}
+	table, _ := InferTable(tableName, names, rows, 1)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("e%d#%s:no CSV fields", e173, table.Name)
+	}
+	if err = csvApplyTypes(table, names, opts); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		record := newRecord(len(table.Fields))
+		for column, s := range row {
+			field := table.Fields[column]
+			if opts.EmptyIsNull && strings.TrimSpace(s) == "" {
+				if !field.AllowNull {
+					return nil, fmt.Errorf("e%d#%s.%s:row %d: null not "+
+						"allowed", e174, table.Name, field.Name, i+1)
+				}
+				continue // record[column] is already nil
+			}
+			var value any
+			var err error
+			if field.Kind == BytesField {
+				value, err = hex.DecodeString(strings.TrimSpace(s))
+			} else {
+				value, err = parseValue(s, field.Kind)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("e%d#%s.%s:row %d: %s", e174,
+					table.Name, field.Name, i+1, err)
+			}
+			record[column] = value
+		}
+		table.Records = append(table.Records, record)
+	}
+	return table, nil
+}
+
+// csvApplyTypes sets the kinds of the fields given in opts.Types (whose
+// keys may be the given names or the corresponding field names), and makes
+// any other fields that have blanks strs if blanks aren't nulls.
+func csvApplyTypes(table *Table, names []string, opts CSVOptions) error {
+	columns := make(map[string]int, len(names)*2)
+	for column, name := range names {
+		columns[name] = column
+		columns[table.Fields[column].Name] = column
+	}
+	typed := make(map[int]bool, len(opts.Types))
+	for name, typeName := range opts.Types {
+		column, ok := columns[name]
+		if !ok {
+			return fmt.Errorf("e%d#%s:no field called %q", e152,
+				table.Name, name)
+		}
+		field := table.Fields[column]
+		if field.Kind, field.AllowNull, ok = parseTypeName(
+			typeName); !ok {
+			return fmt.Errorf("e%d#%s.%s:invalid typename %q", e131,
+				table.Name, name, typeName)
+		}
+		typed[column] = true
+	}
+	if !opts.EmptyIsNull {
+		for column, field := range table.Fields {
+			if field.AllowNull && !typed[column] {
+				field.Kind, field.AllowNull = StrField, false
+			}
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes the table's records as CSV with a header row of field
+// names. Nulls are written as empty cells, bools as T or F, bytes as hex,
+// and dates and datetimes in ISO8601 format.
+func (me *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	row := make([]string, len(me.Fields))
+	for column, field := range me.Fields {
+		row[column] = field.Name
+	}
+	if err := writer.Write(row); err != nil {
+		return err
+	}
+	for _, record := range me.Records {
+		for column, value := range record {
+			switch v := value.(type) {
+			case nil:
+				row[column] = ""
+			case []byte:
+				row[column] = hex.EncodeToString(v)
+			default:
+				s, err := formatValue(value, me.Fields[column].Kind)
+				if err != nil {
+					return err
+				}
+				row[column] = s
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
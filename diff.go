@@ -0,0 +1,378 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TdbDiff holds the differences between two Tdbs, a and b, as returned by
+// [Diff] or [DiffKeys].
+type TdbDiff struct {
+	AddedTables   []string     // Tables in b but not in a (in b's order)
+	RemovedTables []string     // Tables in a but not in b (in a's order)
+	Tables        []*TableDiff // Tables in both that differ (in b's order)
+}
+
+// TableDiff holds the differences between a table in two Tdbs, a and b.
+type TableDiff struct {
+	Name          string
+	AddedFields   []*MetaFieldType // Fields in b's table but not in a's
+	RemovedFields []*MetaFieldType // Fields in a's table but not in b's
+	RetypedFields []FieldChange    // Fields whose kind or nullability differ
+	Inserted      []Record         // b's records with no match in a
+	Deleted       []Record         // a's records with no match in b
+	Modified      []RecordChange   // Matched records that differ
+	a             *Table
+	b             *Table
+	keys          []int // b's key columns
+}
+
+// FieldChange is a field whose type differs between a and b.
+type FieldChange struct {
+	From *MetaFieldType
+	To   *MetaFieldType
+}
+
+// RecordChange is a record whose values differ between a and b. Only the
+// fields in both a and b are compared.
+type RecordChange struct {
+	Row    int   // The record's row in b
+	Key    []any // The record's key values (nil if matched by position)
+	Values []ValueChange
+}
+
+// ValueChange is a field's value in a (From) and in b (To).
+type ValueChange struct {
+	Field string
+	From  any
+	To    any
+}
+
+// Diff returns the differences between a and b, matching the records of
+// each table in both by position (see also [DiffKeys]).
+func Diff(a, b *Tdb) *TdbDiff {
+	diff, _ := DiffKeys(a, b, nil) // can't fail without keys
+	return diff
+}
+
+// DiffKeys returns the differences between a and b, matching the records
+// of each table in both by the values of the key fields given for the
+// table in keys (which maps table names to field names), or by position
+// for tables that have no keys. It is an error if a table's key fields
+// aren't in both a's and b's table. If several records have the same key
+// they are matched in order.
+func DiffKeys(a, b *Tdb, keys map[string][]string) (*TdbDiff, error) {
+	diff := &TdbDiff{}
+	for _, tableName := range b.TableNames {
+		if _, ok := a.Tables[tableName]; !ok {
+			diff.AddedTables = append(diff.AddedTables, tableName)
+		}
+	}
+	for _, tableName := range a.TableNames {
+		if _, ok := b.Tables[tableName]; !ok {
+			diff.RemovedTables = append(diff.RemovedTables, tableName)
+		}
+	}
+	for _, tableName := range b.TableNames {
+		if table, ok := a.Tables[tableName]; ok {
+			tableDiff, err := diffTables(table, b.Tables[tableName],
+				keys[tableName])
+			if err != nil {
+				return nil, err
+			}
+			if !tableDiff.Empty() {
+				diff.Tables = append(diff.Tables, tableDiff)
+			}
+		}
+	}
+	return diff, nil
+}
+
+// Empty returns true if there are no differences.
+func (me *TdbDiff) Empty() bool {
+	return len(me.AddedTables) == 0 && len(me.RemovedTables) == 0 &&
+		len(me.Tables) == 0
+}
+
+// Empty returns true if there are no differences.
+func (me *TableDiff) Empty() bool {
+	return len(me.AddedFields) == 0 && len(me.RemovedFields) == 0 &&
+		len(me.RetypedFields) == 0 && len(me.Inserted) == 0 &&
+		len(me.Deleted) == 0 && len(me.Modified) == 0
+}
+
+func diffTables(a, b *Table, keys []string) (*TableDiff, error) {
+	diff := &TableDiff{Name: b.Name, a: a, b: b}
+	type common struct{ a, b int }
+	commons := make([]common, 0, len(b.Fields))
+	for column, field := range b.Fields {
+		aColumn := a.FieldIndex(field.Name)
+		if aColumn == -1 {
+			diff.AddedFields = append(diff.AddedFields, field)
+			continue
+		}
+		commons = append(commons, common{aColumn, column})
+		if *a.Fields[aColumn] != *field {
+			diff.RetypedFields = append(diff.RetypedFields,
+				FieldChange{a.Fields[aColumn], field})
+		}
+	}
+	for _, field := range a.Fields {
+		if b.FieldIndex(field.Name) == -1 {
+			diff.RemovedFields = append(diff.RemovedFields, field)
+		}
+	}
+	aKeys := make([]int, 0, len(keys))
+	bKeys := make([]int, 0, len(keys))
+	for _, key := range keys {
+		aColumn, bColumn := a.FieldIndex(key), b.FieldIndex(key)
+		if aColumn == -1 || bColumn == -1 {
+			return nil, fmt.Errorf("e%d#%s.%s:key field isn't in both "+
+				"tables", e193, b.Name, key)
+		}
+		aKeys = append(aKeys, aColumn)
+		bKeys = append(bKeys, bColumn)
+	}
+	diff.keys = bKeys
+	matched := make([]bool, len(a.Records))
+	compare := func(aRow, bRow int) {
+		aRecord, bRecord := a.Records[aRow], b.Records[bRow]
+		change := RecordChange{Row: bRow}
+		for _, column := range commons {
+			if !valuesEqual(aRecord[column.a], bRecord[column.b], 0) {
+				change.Values = append(change.Values, ValueChange{
+					b.Fields[column.b].Name, aRecord[column.a],
+					bRecord[column.b]})
+			}
+		}
+		if len(change.Values) > 0 {
+			for _, column := range bKeys {
+				change.Key = append(change.Key, bRecord[column])
+			}
+			diff.Modified = append(diff.Modified, change)
+		}
+		matched[aRow] = true
+	}
+	if len(keys) == 0 {
+		for row, record := range b.Records {
+			if row < len(a.Records) {
+				compare(row, row)
+			} else {
+				diff.Inserted = append(diff.Inserted, record)
+			}
+		}
+	} else {
+		aRows := make(map[string][]int)
+		for row, record := range a.Records {
+			key := record.keyFor(aKeys)
+			aRows[key] = append(aRows[key], row)
+		}
+		for row, record := range b.Records {
+			key := record.keyFor(bKeys)
+			if rows := aRows[key]; len(rows) > 0 {
+				compare(rows[0], row)
+				aRows[key] = rows[1:]
+			} else {
+				diff.Inserted = append(diff.Inserted, record)
+			}
+		}
+	}
+	for row, record := range a.Records {
+		if !matched[row] {
+			diff.Deleted = append(diff.Deleted, record)
+		}
+	}
+	return diff, nil
+}
+
+// Write writes the differences to out as text, one per line, with + for
+// additions and insertions, - for removals and deletions, and ~ for
+// changes, and with values in Tdb format. Added and removed tables come
+// first (e.g., "+ table bonus"), then the changes to each table, e.g.:
+//
+//	~ table emp
+//	  + field bonus real?
+//	  ~ field mgr int? -> int
+//	  - 7369 <SMITH> <CLERK> 7902 1980-12-17 800 ? 20
+//	  ~ 7499: job <SALESMAN> -> <MANAGER>, sal 1600 -> 1700
+//
+// Modified records are identified by their key values or, if matched by
+// position, by their row in b (e.g., "~ row 3: ...").
+func (me *TdbDiff) Write(out io.Writer) error {
+	writer := bufio.NewWriter(out)
+	for _, tableName := range me.AddedTables {
+		writer.WriteString("+ table " + tableName + "\n")
+	}
+	for _, tableName := range me.RemovedTables {
+		writer.WriteString("- table " + tableName + "\n")
+	}
+	for _, table := range me.Tables {
+		writer.WriteString("~ table " + table.Name + "\n")
+		for _, field := range table.AddedFields {
+			writer.WriteString("  + field " + fieldText(field) + "\n")
+		}
+		for _, field := range table.RemovedFields {
+			writer.WriteString("  - field " + fieldText(field) + "\n")
+		}
+		for _, change := range table.RetypedFields {
+			writer.WriteString("  ~ field " + fieldText(change.From) +
+				" -> " + typeText(change.To) + "\n")
+		}
+		for _, record := range table.Deleted {
+			writer.WriteString("  - " + recordText(table.a.Fields, record) +
+				"\n")
+		}
+		for _, record := range table.Inserted {
+			writer.WriteString("  + " + recordText(table.b.Fields, record) +
+				"\n")
+		}
+		for _, change := range table.Modified {
+			writer.WriteString("  ~ " + table.keyText(change) + ":")
+			for i, value := range change.Values {
+				if i > 0 {
+					writer.WriteByte(',')
+				}
+				from, to := table.valueTexts(value)
+				writer.WriteString(" " + value.Field + " " + from + " -> " +
+					to)
+			}
+			writer.WriteByte('\n')
+		}
+	}
+	return writer.Flush()
+}
+
+// Tdb returns the differences as a Tdb with a diff_tables table of added
+// and removed tables, a diff_fields table of added, removed, and retyped
+// fields (with their type names), a table_inserted and a table_deleted
+// table (with the table's fields in b and a respectively) for each table
+// with inserted or deleted records, and a diff_values table of changed
+// values (in Tdb format) with the key values of each changed record (or
+// null if matched by position) and its row in b. Only nonempty tables are
+// included.
+func (me *TdbDiff) Tdb() *Tdb {
+	db := NewTdb()
+	tables := diffTable("diff_tables", "table str change str")
+	for _, tableName := range me.AddedTables {
+		tables.Records = append(tables.Records, Record{tableName, "added"})
+	}
+	for _, tableName := range me.RemovedTables {
+		tables.Records = append(tables.Records, Record{tableName,
+			"removed"})
+	}
+	fields := diffTable("diff_fields",
+		"table str field str from str? to str?")
+	values := diffTable("diff_values",
+		"table str key str? row int field str from str to str")
+	changes := make([]*Table, 0)
+	for _, table := range me.Tables {
+		for _, field := range table.AddedFields {
+			fields.Records = append(fields.Records, Record{table.Name,
+				field.Name, nil, typeText(field)})
+		}
+		for _, field := range table.RemovedFields {
+			fields.Records = append(fields.Records, Record{table.Name,
+				field.Name, typeText(field), nil})
+		}
+		for _, change := range table.RetypedFields {
+			fields.Records = append(fields.Records, Record{table.Name,
+				change.To.Name, typeText(change.From), typeText(change.To)})
+		}
+		for _, change := range table.Modified {
+			var key any
+			if change.Key != nil {
+				key = table.keyText(change)
+			}
+			for _, value := range change.Values {
+				from, to := table.valueTexts(value)
+				values.Records = append(values.Records, Record{table.Name,
+					key, change.Row, value.Field, from, to})
+			}
+		}
+		for _, records := range []struct {
+			suffix  string
+			fields  []*MetaFieldType
+			records []Record
+		}{{"_inserted", table.b.Fields, table.Inserted},
+			{"_deleted", table.a.Fields, table.Deleted}} {
+			if len(records.records) > 0 {
+				changed := NewTable()
+				changed.Name = table.Name + records.suffix
+				changed.Fields = records.fields
+				changed.Records = records.records
+				changes = append(changes, &changed)
+			}
+		}
+	}
+	for _, table := range append([]*Table{tables, fields}, changes...) {
+		if len(table.Records) > 0 {
+			db.AddTable(table)
+		}
+	}
+	if len(values.Records) > 0 {
+		db.AddTable(values)
+	}
+	return &db
+}
+
+func diffTable(name, fields string) *Table {
+	table := NewTable()
+	table.Name = name
+	parts := strings.Fields(fields)
+	for i := 0; i < len(parts); i += 2 {
+		table.AddField(parts[i], parts[i+1])
+	}
+	return &table
+}
+
+// keyText returns the change's key values in Tdb format or its row.
+func (me *TableDiff) keyText(change RecordChange) string {
+	if change.Key == nil {
+		return "row " + strconv.Itoa(change.Row)
+	}
+	texts := make([]string, 0, len(change.Key))
+	for i, value := range change.Key {
+		texts = append(texts, valueText(me.b.Fields[me.keys[i]], value))
+	}
+	return strings.Join(texts, " ")
+}
+
+// valueTexts returns the changed value's old and new values in Tdb format.
+func (me *TableDiff) valueTexts(value ValueChange) (string, string) {
+	return valueText(me.a.Fields[me.a.FieldIndex(value.Field)], value.From),
+		valueText(me.b.Fields[me.b.FieldIndex(value.Field)], value.To)
+}
+
+func recordText(fields []*MetaFieldType, record Record) string {
+	texts := make([]string, 0, len(record))
+	for column, value := range record {
+		texts = append(texts, valueText(fields[column], value))
+	}
+	return strings.Join(texts, " ")
+}
+
+// valueText returns the value in Tdb format.
+func valueText(field *MetaFieldType, value any) string {
+	var text strings.Builder
+	nullable := *field
+	nullable.AllowNull = true // a changed value may be null
+	writeValue(&text, value, &nullable, -1)
+	return text.String()
+}
+
+func fieldText(field *MetaFieldType) string {
+	return field.Name + " " + typeText(field)
+}
+
+func typeText(field *MetaFieldType) string {
+	if field.AllowNull {
+		return field.Kind.String() + "?"
+	}
+	return field.Kind.String()
+}
@@ -13,6 +13,53 @@ if the Tdb file format is known, then it is best to use [Marshal] and
 [Unmarshal] since these use the appropriate concrete types (`bool`, `int`,
 `string`, and so on).
 
+For working with a single table of known format, use [TableOf], a generic
+typed table whose records are structs, and which can be converted to and
+from a [Table].
+
+Tables can be queried in memory using [Table.Where], [Table.Select],
+[Table.OrderBy], [Table.GroupBy], [Table.Join], and related methods, or by
+using SQL SELECT statements with the [Query] function. Tables can also be
+changed using SQL INSERT, UPDATE, and DELETE statements with the [Exec]
+function. For database/sql support, import the tdbsql subpackage which
+provides a "tdb" driver.
+
+For fast lookups, create hash or ordered indexes using [Table.CreateIndex]
+or [Table.CreateOrderedIndex].
+
+To convert CSV to Tdb use [FromCSV] (which can infer field types), and to
+convert a table to CSV use [Table.WriteCSV]. To infer the field types of
+other untyped data, use [InferKind] or [InferTable].
+
+To convert Tdb to JSON use [Tdb.WriteJSON] (or json.Marshal), or for
+NDJSON (one object per record) [Table.WriteNDJSON]; to convert JSON to Tdb
+use [FromJSON]. See JSON mapping below.
+
+To convert Tdb to an SQL script for SQLite or Postgres use [Tdb.WriteSQL],
+and to read the tables in an SQLite database file use [ParseSQLite].
+
+To output a table for a report use [Table.Render], which can write GitHub
+Markdown, HTML, or a box-drawn text grid.
+
+To use a Tdb file for an application's settings use a [Config], which can
+also be converted to and from .ini and TOML files. For a persistent
+key–value store of typed values use a [KV].
+
+To read a .tdb file, change it, and save it safely use a [Store] (see
+[Open]), which saves atomically, can keep a backup, and locks the file
+against other processes that use a Store. A Store can also journal
+record-level changes so that small updates don't rewrite the whole file.
+To share a Tdb between goroutines use a [SyncTdb].
+
+To copy a Tdb use [Tdb.Clone], and to compare Tdbs by meaning (optionally
+ignoring table and record order and with a tolerance for reals) use
+[Tdb.Equal]. To find the record-level differences between two Tdbs use
+[Diff] (or [DiffKeys] to match records by key fields), and to do a
+three-way merge of the changes two Tdbs made to a common ancestor use
+[Merge] (the tdb merge-driver command uses it for git).
+
+For stable output (e.g., for minimal git diffs) use [Tdb.WriteCanonical].
+
 To use the [Marshal] and [Unmarshal] functions you must provide a populated
 (for Marshal) or unpopulated (for Unmarshal) struct. This outer struct
 represents a text database. The outer struct must contain one or more public
@@ -33,6 +80,22 @@ Perhaps best of all, a single Tdb file may contain one—or more—tables.
 See README.md at https://github.com/mark-summerfield/tdb-go for more about
 the Tdb format.
 
+# JSON mapping
+
+A Tdb is written as an array of table objects, and a table as an object
+with "name", "fields", and "rows" keys. The "fields" value is an array of
+objects each with "name" and "type" keys (e.g., {"name": "sal", "type":
+"real?"}), and the "rows" value is an array of arrays, one per record.
+NDJSON records are objects keyed by fieldname.
+
+Values are mapped as follows: bool to true or false; bytes to a base64
+string (or hex if [JSONOptions].HexBytes is true); date to a "yyyy-mm-dd"
+string; datetime to a "yyyy-mm-ddThh:mm:ss" string; int and real to
+numbers; str to a string; and null to null. (Reals that are NaN or
+infinite can't be written.) When reading JSON, kinds are inferred from the
+values, so dates and datetimes must be in these ISO 8601 forms to be
+recognized (see [FromJSON]).
+
 # Using the tdb package
 
 Import using:
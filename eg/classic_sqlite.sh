@@ -0,0 +1,33 @@
+#!/bin/sh
+# Makes eg/classic.sqlite (used by TestSQLite in tdb4_test.go) from
+# eg/classic.tdb. Needs go and sqlite3. Run from the repo's root:
+#   sh eg/classic_sqlite.sh [OUTFILE]
+set -e
+outfile=${1:-eg/classic.sqlite}
+rm -f "$outfile"
+go run ./bin export sql eg/classic.tdb | sqlite3 "$outfile"
+sqlite3 "$outfile" <<'SQL'
+CREATE TABLE [misc items] (
+    id INTEGER PRIMARY KEY, -- rowid alias
+    "Unit Price" NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    flag BOOLEAN,
+    stamp TIMESTAMP,
+    pic BLOB,
+    note VARCHAR(20) COLLATE NOCASE,
+    anything,
+    total AS ("Unit Price" * 2) /* virtual, so not stored */
+);
+INSERT INTO [misc items] (id, "Unit Price", flag, stamp, pic, note, anything)
+VALUES (1, 2.5, 1, '2022-01-02 10:11:12', x'00FF', 'hello', 7),
+    (2, 3, 0, NULL, NULL, 'it''s', 'text'),
+    (5, 4.25, NULL, '2022-03-04T05:06:07', x'', NULL, NULL);
+ALTER TABLE [misc items] ADD COLUMN extra TEXT;
+UPDATE [misc items] SET extra = 'added' WHERE id = 5;
+CREATE TABLE numbers (n INTEGER NOT NULL, square INT NOT NULL, big TEXT);
+WITH RECURSIVE counter(n) AS (
+    SELECT 1 UNION ALL SELECT n + 1 FROM counter WHERE n < 1000)
+INSERT INTO numbers SELECT n, n * n, CASE n WHEN 500 THEN
+    replace(hex(zeroblob(10000)), '00', 'x') END FROM counter;
+CREATE INDEX numbers_square ON numbers (square);
+CREATE VIEW v AS SELECT * FROM dept;
+SQL
@@ -1,15 +1,15 @@
 module github.com/mark-summerfield/tdb-go
 
-go 1.19
+go 1.23
 
 require (
 	github.com/mark-summerfield/clip v0.7.0
 	github.com/mark-summerfield/gset v0.8.0
+	golang.org/x/sys v0.2.0
 )
 
 require (
 	github.com/kopoli/go-terminal-size v0.0.0-20170219200355-5c97524c8b54 // indirect
 	github.com/mark-summerfield/gong v0.7.0 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
-	golang.org/x/sys v0.2.0 // indirect
 )
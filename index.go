@@ -0,0 +1,286 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Index is a secondary index over one or more of a table's fields. Every
+// index supports fast equality lookups using [Index.Lookup]; ordered
+// indexes also support range queries using [Index.Range].
+//
+// A table's indexes are kept up to date by the table's mutation and schema
+// methods (e.g., [Table.AppendRecord], [Table.UpdateValue],
+// [Table.DeleteRecords], [Table.DropColumn]). If a table's Records are
+// changed directly, call [Table.Reindex] afterwards. Indexes are not safe
+// for concurrent use while the table is being changed. A copy of a table
+// (e.g., made by assigning *table) has no indexes.
+type Index struct {
+	table   *Table
+	columns []int
+	ordered bool
+	rows    map[string][]int // key is Record.keyFor(columns); rows ascend
+	sorted  []int            // ordered indexes only: rows in key order
+}
+
+// CreateIndex creates and returns a hash index over the given fields.
+//
+// See also [Table.CreateOrderedIndex].
+func (me *Table) CreateIndex(fieldNames ...string) (*Index, error) {
+	return me.createIndex(fieldNames, false)
+}
+
+// CreateOrderedIndex creates and returns an ordered index over the given
+// fields. An ordered index supports both [Index.Lookup] and [Index.Range].
+func (me *Table) CreateOrderedIndex(fieldNames ...string) (*Index, error) {
+	return me.createIndex(fieldNames, true)
+}
+
+func (me *Table) createIndex(fieldNames []string, ordered bool) (*Index,
+	error) {
+	if len(fieldNames) == 0 {
+		return nil, fmt.Errorf("e%d#%s:an index needs at least one field",
+			e170, me.Name)
+	}
+	columns, err := me.fieldIndexes(fieldNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range me.ownIndexes() {
+		if index.ordered == ordered && slices.Equal(index.columns, columns) {
+			return nil, fmt.Errorf("e%d#%s:duplicate index on %s", e171,
+				me.Name, strings.Join(fieldNames, ", "))
+		}
+	}
+	index := &Index{table: me, columns: columns, ordered: ordered}
+	index.build()
+	me.indexes = append(me.indexes, index)
+	return index, nil
+}
+
+// DropIndex removes the given index from the table and returns true; or
+// returns false if the index doesn't belong to the table.
+func (me *Table) DropIndex(index *Index) bool {
+	i := slices.Index(me.ownIndexes(), index)
+	if i == -1 {
+		return false
+	}
+	me.indexes = slices.Delete(me.indexes, i, i+1)
+	index.detach()
+	return true
+}
+
+// Reindex rebuilds all the table's indexes. This is only needed after
+// changing the table's Records directly rather than by using the table's
+// methods.
+func (me *Table) Reindex() {
+	for _, index := range me.ownIndexes() {
+		index.build()
+	}
+}
+
+// Fields returns the names of the index's fields, or nil if the index has
+// been dropped.
+func (me *Index) Fields() []string {
+	if me.table == nil {
+		return nil
+	}
+	fieldNames := make([]string, 0, len(me.columns))
+	for _, column := range me.columns {
+		fieldNames = append(fieldNames, me.table.Fields[column].Name)
+	}
+	return fieldNames
+}
+
+// Ordered returns true if the index supports [Index.Range].
+func (me *Index) Ordered() bool {
+	return me.ordered
+}
+
+// Lookup returns the records whose indexed fields equal the given key
+// values (in row order). There must be one key value per indexed field; use
+// nil to match nulls. The records are the table's own, not copies. Returns
+// nil if there are no matches or if the index has been dropped.
+//
+// See also [Index.Rows].
+func (me *Index) Lookup(key ...any) []Record {
+	return me.records(me.Rows(key...))
+}
+
+// Rows returns the row numbers of the records that [Index.Lookup] would
+// return, e.g., for use with [Table.UpdateValue].
+func (me *Index) Rows(key ...any) []int {
+	if len(key) != len(me.columns) {
+		return nil
+	}
+	return slices.Clone(me.rows[recordKey(key)])
+}
+
+// Range returns the records whose indexed fields are between the low and
+// high keys inclusive (in key order, and then in row order). Either key
+// may have fewer values than the index has fields, in which case only that
+// many fields are compared; a nil or empty key means no bound. Returns an
+// error if the index isn't ordered. The records are the table's own, not
+// copies.
+func (me *Index) Range(low, high []any) ([]Record, error) {
+	if !me.ordered {
+		return nil, fmt.Errorf("e%d#Range requires an ordered index", e172)
+	}
+	if len(low) > len(me.columns) || len(high) > len(me.columns) {
+		return nil, fmt.Errorf("e%d#range key has more values than the "+
+			"index has fields", e172)
+	}
+	start, _ := slices.BinarySearchFunc(me.sorted, low,
+		func(row int, key []any) int {
+			if me.compareKey(row, key) < 0 {
+				return -1
+			}
+			return 1
+		})
+	end := len(me.sorted)
+	if len(high) > 0 {
+		end, _ = slices.BinarySearchFunc(me.sorted, high,
+			func(row int, key []any) int {
+				if me.compareKey(row, key) <= 0 {
+					return -1
+				}
+				return 1
+			})
+	}
+	if end < start {
+		end = start
+	}
+	return me.records(me.sorted[start:end]), nil
+}
+
+func (me *Index) records(rows []int) []Record {
+	if len(rows) == 0 {
+		return nil
+	}
+	records := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, me.table.Records[row])
+	}
+	return records
+}
+
+// detach empties a dropped index so that it matches nothing.
+func (me *Index) detach() {
+	me.table = nil
+	me.rows = nil
+	me.sorted = nil
+}
+
+func (me *Index) build() {
+	me.rows = make(map[string][]int)
+	for row, record := range me.table.Records {
+		key := record.keyFor(me.columns)
+		me.rows[key] = append(me.rows[key], row)
+	}
+	if me.ordered {
+		me.sorted = make([]int, len(me.table.Records))
+		for row := range me.sorted {
+			me.sorted[row] = row
+		}
+		slices.SortStableFunc(me.sorted, me.compareRows)
+	}
+}
+
+// add must be called after the given row's record is appended or updated.
+func (me *Index) add(row int) {
+	key := me.table.Records[row].keyFor(me.columns)
+	rows := me.rows[key]
+	i, _ := slices.BinarySearch(rows, row)
+	me.rows[key] = slices.Insert(rows, i, row)
+	if me.ordered {
+		i, _ = slices.BinarySearchFunc(me.sorted, row, me.compareRows)
+		me.sorted = slices.Insert(me.sorted, i, row)
+	}
+}
+
+// remove must be called before the given row's record is updated.
+func (me *Index) remove(row int) {
+	key := me.table.Records[row].keyFor(me.columns)
+	rows := me.rows[key]
+	if i, found := slices.BinarySearch(rows, row); found {
+		rows = slices.Delete(rows, i, i+1)
+	}
+	if len(rows) == 0 {
+		delete(me.rows, key)
+	} else {
+		me.rows[key] = rows
+	}
+	if me.ordered {
+		if i, found := slices.BinarySearchFunc(me.sorted, row,
+			me.compareRows); found {
+			me.sorted = slices.Delete(me.sorted, i, i+1)
+		}
+	}
+}
+
+// compareRows orders rows by their indexed values and then by row number.
+func (me *Index) compareRows(a, b int) int {
+	recordA := me.table.Records[a]
+	recordB := me.table.Records[b]
+	for _, column := range me.columns {
+		if c := compareValues(recordA[column], recordB[column]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a, b)
+}
+
+// compareKey compares the given row's indexed values with the given
+// (possibly partial) key.
+func (me *Index) compareKey(row int, key []any) int {
+	record := me.table.Records[row]
+	for i, value := range key {
+		if c := compareValues(record[me.columns[i]], value); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// ownIndexes returns the table's indexes. A copy of a table (e.g., made by
+// assigning *table) doesn't share the original's indexes, since they index
+// the original's records; instead it starts with none.
+func (me *Table) ownIndexes() []*Index {
+	if len(me.indexes) > 0 && me.indexes[0].table != me {
+		me.indexes = nil
+	}
+	return me.indexes
+}
+
+// indexesOn returns the table's indexes that include the given column.
+func (me *Table) indexesOn(column int) []*Index {
+	var indexes []*Index
+	for _, index := range me.ownIndexes() {
+		if slices.Contains(index.columns, column) {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// dropColumnFromIndexes drops the indexes that include the given column and
+// adjusts the others' columns to account for the column's removal.
+func (me *Table) dropColumnFromIndexes(column int) {
+	me.indexes = slices.DeleteFunc(me.ownIndexes(), func(index *Index) bool {
+		if slices.Contains(index.columns, column) {
+			index.detach()
+			return true
+		}
+		for i, c := range index.columns {
+			if c > column {
+				index.columns[i]--
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"strings"
+	"time"
+)
+
+// Inference is the result of inferring a field's kind from a column of
+// untyped values using [InferKind].
+type Inference struct {
+	Kind       FieldKind
+	AllowNull  bool    // true if any of the values are blank
+	Confidence float64 // the proportion (0.0-1.0) of values that fit Kind
+	Invalid    int     // how many non-blank values don't fit Kind
+}
+
+// TypeName returns the inferred kind as a Tdb typename, e.g., "int" or
+// "date?".
+func (me Inference) TypeName() string {
+	if me.AllowNull {
+		return me.Kind.String() + "?"
+	}
+	return me.Kind.String()
+}
+
+// InferKind returns the narrowest kind that fits the given values, trying
+// each of bool, int, real, date, datetime, and str in turn.
+//
+// Values are trimmed of leading and trailing whitespace. Blank values are
+// treated as nulls: they are ignored except that they make the result
+// nullable. Bools must be one of true, false, yes, no, t, f, y, or n
+// (case-insensitively); ints and reals with leading zeros (e.g., zip codes)
+// are not considered to be numbers; dates must be yyyy-mm-dd; and datetimes
+// must be yyyy-mm-ddThh:mm:ss (or with a space instead of the T), or
+// yyyy-mm-dd (so that dates and datetimes may be mixed).
+//
+// A kind fits if at least minConfidence of the non-blank values are valid
+// for it. If minConfidence is 0 (or more than 1), it is taken to be 1, that
+// is, every value must be valid. A lower minConfidence (e.g., 0.95) can be
+// used to see what kind messy data should probably be; the result's Invalid
+// count says how many values would have to be fixed first. (Str fits any
+// values.) If there are no non-blank values the result is a str with a
+// Confidence of 0.
+func InferKind(values []string, minConfidence float64) Inference {
+	if minConfidence <= 0 || minConfidence > 1 {
+		minConfidence = 1
+	}
+	kinds := []FieldKind{BoolField, IntField, RealField, DateField,
+		DateTimeField}
+	valid := make([]int, len(kinds))
+	count := 0
+	inference := Inference{Kind: StrField}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			inference.AllowNull = true
+			continue
+		}
+		count++
+		for i, kind := range kinds {
+			if inferable(value, kind) {
+				valid[i]++
+			}
+		}
+	}
+	if count == 0 {
+		return inference
+	}
+	inference.Confidence = 1
+	for i, kind := range kinds {
+		if confidence := float64(valid[i]) / float64(count); confidence >=
+			minConfidence {
+			inference.Kind = kind
+			inference.Confidence = confidence
+			inference.Invalid = count - valid[i]
+			break
+		}
+	}
+	return inference
+}
+
+// InferTable returns an empty table called tableName with a field for
+// each of the given names and each field's kind inferred from the
+// corresponding column of rows using [InferKind] (see that function for
+// minConfidence). The table and field names are made into valid Tdb
+// identifiers if necessary (e.g., "Unit Price" becomes Unit_Price). Missing
+// values (if a row is shorter than names) are treated as blanks. The
+// inferences (one per field) are also returned, e.g., to report their
+// confidence.
+func InferTable(tableName string, names []string, rows [][]string,
+	minConfidence float64) (*Table, []Inference) {
+	table := NewTable()
+	table.Name = makeIdentifier(tableName, make(map[string]bool))
+	inferences := make([]Inference, 0, len(names))
+	used := make(map[string]bool)
+	values := make([]string, len(rows))
+	for column, name := range names {
+		for i, row := range rows {
+			values[i] = ""
+			if column < len(row) {
+				values[i] = row[column]
+			}
+		}
+		inference := InferKind(values, minConfidence)
+		inferences = append(inferences, inference)
+		table.Fields = append(table.Fields, &MetaFieldType{
+			makeIdentifier(name, used), inference.Kind,
+			inference.AllowNull})
+	}
+	return &table, inferences
+}
+
+// inferable returns true if the given (trimmed) value is a plausible value
+// of the given kind.
+func inferable(value string, kind FieldKind) bool {
+	switch kind {
+	case BoolField:
+		switch strings.ToLower(value) {
+		case "t", "f", "y", "n", "true", "false", "yes", "no":
+			return true
+		}
+		return false
+	case IntField, RealField:
+		digits := strings.TrimLeft(value, "+-")
+		if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+			return false // e.g., a zip code or phone number
+		}
+	case DateField:
+		_, err := time.Parse(DateFormat, value)
+		return err == nil
+	}
+	_, err := parseValue(value, kind)
+	return err == nil
+}
@@ -0,0 +1,91 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ConfigFromINI reads an .ini file from r and returns it as a [Config]
+// (with no filename) using the mapping shown in the README: ints go in the
+// config_int table and all other values in the config_str table, with a
+// group field if any keys are in a [group] (keys before the first [group]
+// or in a [General] group are ungrouped).
+//
+// Lines starting with ; or # are comments. Keys and values are separated by
+// = (or by : if there's no =) and have leading and trailing whitespace
+// removed. If a key appears more than once in a group, the last value is
+// used.
+func ConfigFromINI(r io.Reader) (*Config, error) {
+	entries := make([]configEntry, 0)
+	scanner := bufio.NewScanner(r)
+	group := ""
+	for lino := 1; scanner.Scan(); lino++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			group = strings.TrimSpace(line[1 : len(line)-1])
+			if group == "General" {
+				group = ""
+			}
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i == -1 {
+			i = strings.IndexByte(line, ':')
+		}
+		if i < 1 {
+			return nil, fmt.Errorf("e%d#%d:expected key=value or [group], "+
+				"got %q", e181, lino, line)
+		}
+		key := strings.TrimSpace(line[:i])
+		text := strings.TrimSpace(line[i+1:])
+		entry := configEntry{group, key, text, StrField}
+		if inferable(text, IntField) {
+			entry.value, _ = strconv.Atoi(text)
+			entry.kind = IntField
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("e%d#failed to read INI: %s", e181, err)
+	}
+	return newConfigFromEntries(entries), nil
+}
+
+// WriteINI writes the Config to out in .ini format with the ungrouped keys
+// first. Bools are written as true or false. Strs that contain newlines
+// can't be written.
+func (me *Config) WriteINI(out io.Writer) error {
+	writer := bufio.NewWriter(out)
+	group := ""
+	for i, entry := range me.groupedEntries() {
+		if entry.group != group {
+			if i > 0 {
+				writer.WriteByte('\n')
+			}
+			group = entry.group
+			writer.WriteString("[" + group + "]\n")
+		}
+		var text string
+		switch value := entry.value.(type) {
+		case bool:
+			text = strconv.FormatBool(value)
+		default:
+			text, _ = formatValue(value, entry.kind)
+		}
+		if strings.ContainsAny(text, "\r\n") {
+			return fmt.Errorf("e%d#%s:can't write a multiline value as INI",
+				e182, entry.key)
+		}
+		writer.WriteString(entry.key + "=" + text + "\n")
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,159 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import "fmt"
+
+// JoinKind specifies the kind of join performed by [Table.Join] and
+// [Table.JoinOn].
+type JoinKind uint8
+
+const (
+	InnerJoin JoinKind = iota // only records that match
+	LeftJoin                  // all of this table's records
+	FullJoin                  // all of both tables' records
+)
+
+func (me JoinKind) String() string {
+	switch me {
+	case InnerJoin:
+		return "inner"
+	case LeftJoin:
+		return "left"
+	case FullJoin:
+		return "full"
+	}
+	panic("invalid JoinKind")
+}
+
+// Join returns a new table that joins this table with the other table
+// where the values of the given fields (which both tables must have) are
+// equal. This is like SQL's JOIN ... USING (fields).
+//
+// See [Table.JoinOn] for details of the result table.
+func (me *Table) Join(other *Table, kind JoinKind,
+	fieldNames ...string) (*Table, error) {
+	return me.JoinOn(other, kind, fieldNames, fieldNames)
+}
+
+// JoinOn returns a new table that joins this table with the other table
+// where the values of this table's fieldNames are equal to the values of
+// the other table's otherFieldNames. This is like SQL's
+// JOIN ... ON a1 = b1 AND a2 = b2 ...
+//
+// The result table is named tablename_othertablename and has all of this
+// table's fields followed by all of the other table's fields, each named
+// tablename_fieldname (e.g., Invoices_CID), adjusted if necessary to be a
+// valid and unique identifier. Nulls never match. For a [LeftJoin] the
+// other table's fields are nullable, and for a [FullJoin] every field is
+// nullable.
+//
+// The records are in the order of this table's records, with matching
+// records in the other table's order, followed (for a FullJoin) by the
+// other table's unmatched records.
+func (me *Table) JoinOn(other *Table, kind JoinKind, fieldNames,
+	otherFieldNames []string) (*Table, error) {
+	columns, otherColumns, err := me.joinColumns(other, fieldNames,
+		otherFieldNames)
+	if err != nil {
+		return nil, err
+	}
+	result := me.makeJoinTable(other, kind)
+	index := make(map[string][]int) // value is other's matching rows
+	for row, record := range other.Records {
+		if !record.hasNullIn(otherColumns) {
+			key := record.keyFor(otherColumns)
+			index[key] = append(index[key], row)
+		}
+	}
+	nulls := make(Record, len(me.Fields)+len(other.Fields))
+	matched := make([]bool, len(other.Records))
+	for _, record := range me.Records {
+		var rows []int
+		if !record.hasNullIn(columns) {
+			rows = index[record.keyFor(columns)]
+		}
+		for _, row := range rows {
+			matched[row] = true
+			joined := make(Record, 0, len(result.Fields))
+			joined = append(joined, record...)
+			joined = append(joined, other.Records[row]...)
+			result.Records = append(result.Records, joined)
+		}
+		if len(rows) == 0 && kind != InnerJoin {
+			joined := make(Record, 0, len(result.Fields))
+			joined = append(joined, record...)
+			joined = append(joined, nulls[len(me.Fields):]...)
+			result.Records = append(result.Records, joined)
+		}
+	}
+	if kind == FullJoin {
+		for row, record := range other.Records {
+			if !matched[row] {
+				joined := make(Record, 0, len(result.Fields))
+				joined = append(joined, nulls[:len(me.Fields)]...)
+				joined = append(joined, record...)
+				result.Records = append(result.Records, joined)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (me *Table) joinColumns(other *Table, fieldNames,
+	otherFieldNames []string) ([]int, []int, error) {
+	if len(fieldNames) == 0 || len(fieldNames) != len(otherFieldNames) {
+		return nil, nil, fmt.Errorf("e%d#%s:%s:join needs the same "+
+			"number (≥1) of fields for each table", e164, me.Name,
+			other.Name)
+	}
+	columns, err := me.fieldIndexes(fieldNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	otherColumns, err := other.fieldIndexes(otherFieldNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	numeric := IntField | RealField
+	for i, column := range columns {
+		kind := me.Fields[column].Kind
+		otherKind := other.Fields[otherColumns[i]].Kind
+		timeKinds := DateField | DateTimeField
+		if kind != otherKind && !(kind&numeric != 0 &&
+			otherKind&numeric != 0) && !(kind&timeKinds != 0 &&
+			otherKind&timeKinds != 0) {
+			return nil, nil, fmt.Errorf("e%d#%s.%s:%s.%s:can't join a "+
+				"%s field with a %s field", e165, me.Name, fieldNames[i],
+				other.Name, otherFieldNames[i], kind, otherKind)
+		}
+	}
+	return columns, otherColumns, nil
+}
+
+func (me *Table) makeJoinTable(other *Table, kind JoinKind) *Table {
+	result := &Table{MetaTableType{makeIdentifier(
+		me.Name+"_"+other.Name, make(map[string]bool)),
+		make([]*MetaFieldType, 0, len(me.Fields)+len(other.Fields))},
+		make([]Record, 0), nil}
+	used := make(map[string]bool)
+	for i, table := range []*Table{me, other} {
+		nullable := kind == FullJoin || (kind == LeftJoin && i == 1)
+		for _, field := range table.Fields {
+			result.Fields = append(result.Fields, &MetaFieldType{
+				makeIdentifier(table.Name+"_"+field.Name, used), field.Kind,
+				field.AllowNull || nullable})
+		}
+	}
+	return result
+}
+
+func (me Record) hasNullIn(columns []int) bool {
+	for _, column := range columns {
+		if me[column] == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+)
+
+// Insert adds the record to the end of the named table and appends the
+// insert to the journal. The record's first value must not match the
+// first value of any of the table's records.
+func (me *Store) Insert(tableName string, record Record) error {
+	table, err := me.journalTable(tableName, record)
+	if err != nil {
+		return err
+	}
+	if findByKey(table, record[0]) > -1 {
+		return fmt.Errorf("e%d#%s:already has a record with key %v", e191,
+			tableName, record[0])
+	}
+	return me.journal("insert", table, record)
+}
+
+// Update replaces the record in the named table whose first value matches
+// the given record's first value, and appends the update to the journal.
+func (me *Store) Update(tableName string, record Record) error {
+	table, err := me.journalTable(tableName, record)
+	if err != nil {
+		return err
+	}
+	if findByKey(table, record[0]) == -1 {
+		return fmt.Errorf("e%d#%s:has no record with key %v", e192,
+			tableName, record[0])
+	}
+	return me.journal("update", table, record)
+}
+
+// Delete deletes the record in the named table whose first value is key,
+// appends the delete to the journal, and returns true, or returns false if
+// there's no such record.
+func (me *Store) Delete(tableName string, key any) (bool, error) {
+	table, err := me.journalTable(tableName, nil)
+	if err != nil {
+		return false, err
+	}
+	if findByKey(table, key) == -1 {
+		return false, nil
+	}
+	return true, me.journal("delete", table, Record{key})
+}
+
+// Compact writes the journal's changes into the .tdb file and deletes the
+// journal. (It is the same as [Store.Save].)
+func (me *Store) Compact() error {
+	return me.Save()
+}
+
+func (me *Store) journalName() string {
+	return me.Filename + ".journal"
+}
+
+// journalTable returns the named table providing the store is open and
+// the record (if any) is valid for the table.
+func (me *Store) journalTable(tableName string, record Record) (*Table,
+	error) {
+	if me.lock == nil {
+		return nil, fmt.Errorf("e%d#%s:can't change a closed store", e189,
+			me.Filename)
+	}
+	table, ok := me.Db.Tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("e%d#%s:no such table", e190, tableName)
+	}
+	if record != nil {
+		if err := table.checkRecord(record); err != nil {
+			return nil, err
+		}
+		for column, value := range record {
+			if r, ok := value.(float64); ok && (math.IsNaN(r) ||
+				math.IsInf(r, 0)) {
+				return nil, fmt.Errorf("e%d#%s.%s:can't journal %v (reals "+
+					"must be finite)", e190, tableName,
+					table.Fields[column].Name, r)
+			}
+		}
+	}
+	return table, nil
+}
+
+// journal appends the change to the journal (and syncs it) and then
+// applies it to the table.
+func (me *Store) journal(op string, table *Table, record Record) error {
+	entry := NewTable()
+	entry.Name = table.Name
+	entry.Fields = append(entry.Fields, &MetaFieldType{"op", StrField,
+		false})
+	entry.Fields = append(entry.Fields, table.Fields[:len(record)]...)
+	entry.Records = append(entry.Records, append(Record{op}, record...))
+	db := NewTdb()
+	db.AddTable(&entry)
+	var body bytes.Buffer
+	if err := db.Write(&body); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%d %08x\n", body.Len(), crc32.ChecksumIEEE(
+		body.Bytes()))
+	out.Write(body.Bytes())
+	file, err := os.OpenFile(me.journalName(),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(out.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return applyEntry(table, op, record)
+}
+
+// replay applies the journal's changes (if there's a journal) to the
+// Store's Tdb. Inserts and updates replace any record with the same key
+// (or are appended), and deletes of missing records are ignored, so
+// replaying a journal whose changes were already saved is harmless.
+func (me *Store) replay() error {
+	raw, err := os.ReadFile(me.journalName())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for offset := 0; offset < len(raw); {
+		entry, size, err := readJournalEntry(raw[offset:])
+		if err != nil {
+			if size == -1 { // the last entry was only partly written
+				return os.Truncate(me.journalName(), int64(offset))
+			}
+			return fmt.Errorf("e%d#%d:%s", e190,
+				bytes.Count(raw[:offset], []byte("\n"))+1, err)
+		}
+		if err = me.replayEntry(entry); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+// readJournalEntry returns the journal entry at the start of data and its
+// size. Each entry is a header line holding the size and CRC-32 of the
+// entry's Tdb text, followed by the text. If the entry is incomplete (so
+// must be the last one) it returns a size of -1 and an error.
+func readJournalEntry(data []byte) (*Table, int, error) {
+	torn := errors.New("incomplete journal entry")
+	end := bytes.IndexByte(data, '\n')
+	if end == -1 {
+		return nil, -1, torn
+	}
+	var size int
+	var crc uint32
+	if n, err := fmt.Sscanf(string(data[:end]), "%d %x", &size,
+		&crc); err != nil || n != 2 || size < 0 {
+		return nil, 0, errors.New("invalid journal entry header")
+	}
+	start := end + 1
+	if size > len(data)-start { // start+size could overflow
+		return nil, -1, torn
+	}
+	body := data[start : start+size]
+	if crc32.ChecksumIEEE(body) != crc {
+		if start+size == len(data) {
+			return nil, -1, torn
+		}
+		return nil, 0, errors.New("journal entry checksum mismatch")
+	}
+	lino := 1
+	var entry *Table
+	var err error
+	if len(body) == 0 || body[0] != '[' {
+		err = errors.New("expected a journal entry")
+	} else if body, entry, err = readMeta(body[1:], &lino); err == nil {
+		if body, err = readRecords(body, entry, &lino); err == nil &&
+			len(bytes.TrimSpace(body)) > 0 {
+			err = errors.New("journal entry has trailing text")
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry, start + size, nil
+}
+
+func (me *Store) replayEntry(entry *Table) error {
+	table, ok := me.Db.Tables[entry.Name]
+	if !ok {
+		return fmt.Errorf("e%d#%s:journal entry for missing table", e190,
+			entry.Name)
+	}
+	if len(entry.Records) != 1 || len(entry.Fields) < 2 ||
+		entry.Fields[0].Name != "op" || entry.Fields[0].Kind != StrField {
+		return fmt.Errorf("e%d#%s:invalid journal entry", e190, entry.Name)
+	}
+	op := entry.Records[0][0]
+	record := entry.Records[0][1:]
+	fields := entry.Fields[1:]
+	if op == "delete" {
+		fields = fields[:1]
+	} else if op != "insert" && op != "update" {
+		return fmt.Errorf("e%d#%s:invalid journal op %v", e190, entry.Name,
+			op)
+	}
+	if (op == "delete" && len(entry.Fields) != 2) ||
+		(op != "delete" && len(fields) != len(table.Fields)) {
+		return fmt.Errorf("e%d#%s:journal entry has the wrong fields", e190,
+			entry.Name)
+	}
+	for column, field := range fields {
+		if *field != *table.Fields[column] {
+			return fmt.Errorf("e%d#%s.%s:journal entry field doesn't "+
+				"match the table's", e190, entry.Name, field.Name)
+		}
+	}
+	return applyEntry(table, op.(string), record)
+}
+
+// applyEntry applies an insert, update, or delete to the table. Inserts and
+// updates replace the record with the same key or are appended.
+func applyEntry(table *Table, op string, record Record) error {
+	if op == "delete" {
+		table.DeleteRecords(func(old Record) bool {
+			return compareValues(old[0], record[0]) == 0
+		})
+		return nil
+	}
+	row := findByKey(table, record[0])
+	if row == -1 {
+		return table.AppendRecord(record)
+	}
+	for column, field := range table.Fields {
+		if err := table.UpdateValue(row, field.Name,
+			record[column]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findByKey returns the row of the first record whose first value is key or
+// -1.
+func findByKey(table *Table, key any) int {
+	for row, record := range table.Records {
+		if compareValues(record[0], key) == 0 {
+			return row
+		}
+	}
+	return -1
+}
@@ -0,0 +1,329 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// JSONOptions are the options for writing JSON.
+type JSONOptions struct {
+	HexBytes bool // If true write bytes as hex rather than base64
+}
+
+// MarshalJSON returns the Tdb as JSON using the default [JSONOptions]
+// (see [Tdb.WriteJSON]).
+func (me *Tdb) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	err := me.WriteJSON(&out, JSONOptions{})
+	return out.Bytes(), err
+}
+
+// WriteJSON writes the Tdb to out as a JSON array with one object per
+// table (see the package documentation for the JSON mapping).
+func (me *Tdb) WriteJSON(out io.Writer, opts JSONOptions) error {
+	writer := bufio.NewWriter(out)
+	writer.WriteString("[\n")
+	for i, tableName := range me.TableNames {
+		if i > 0 {
+			writer.WriteString(",\n")
+		}
+		if err := me.Tables[tableName].writeJSON(writer, opts); err != nil {
+			return err
+		}
+	}
+	writer.WriteString("\n]\n")
+	return writer.Flush()
+}
+
+// MarshalJSON returns the table as JSON using the default [JSONOptions]
+// (see [Table.WriteJSON]).
+func (me *Table) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	err := me.WriteJSON(&out, JSONOptions{})
+	return out.Bytes(), err
+}
+
+// WriteJSON writes the table to out as a JSON object with its name, fields,
+// and rows (see the package documentation for the JSON mapping).
+func (me *Table) WriteJSON(out io.Writer, opts JSONOptions) error {
+	writer := bufio.NewWriter(out)
+	if err := me.writeJSON(writer, opts); err != nil {
+		return err
+	}
+	writer.WriteByte('\n')
+	return writer.Flush()
+}
+
+func (me *Table) writeJSON(writer *bufio.Writer, opts JSONOptions) error {
+	writer.WriteString(`{"name": `)
+	writer.Write(jsonString(me.Name))
+	writer.WriteString(`, "fields": [`)
+	for i, field := range me.Fields {
+		if i > 0 {
+			writer.WriteString(", ")
+		}
+		typeName := field.Kind.String()
+		if field.AllowNull {
+			typeName += "?"
+		}
+		writer.WriteString(`{"name": `)
+		writer.Write(jsonString(field.Name))
+		writer.WriteString(`, "type": `)
+		writer.Write(jsonString(typeName))
+		writer.WriteByte('}')
+	}
+	writer.WriteString(`], "rows": [`)
+	for i, record := range me.Records {
+		if i > 0 {
+			writer.WriteByte(',')
+		}
+		writer.WriteString("\n[")
+		for column, value := range record {
+			if column > 0 {
+				writer.WriteString(", ")
+			}
+			raw, err := jsonValue(value, me.Fields[column], opts)
+			if err != nil {
+				return fmt.Errorf("e%d#%s.%s:row %d: %s", e175, me.Name,
+					me.Fields[column].Name, i, err)
+			}
+			writer.Write(raw)
+		}
+		writer.WriteByte(']')
+	}
+	if len(me.Records) > 0 {
+		writer.WriteByte('\n')
+	}
+	writer.WriteString("]}")
+	return nil
+}
+
+// WriteNDJSON writes the table's records to out as newline-delimited JSON,
+// i.e., one JSON object keyed by fieldname per line (see the package
+// documentation for the JSON mapping).
+func (me *Table) WriteNDJSON(out io.Writer, opts JSONOptions) error {
+	writer := bufio.NewWriter(out)
+	for i, record := range me.Records {
+		writer.WriteByte('{')
+		for column, value := range record {
+			field := me.Fields[column]
+			if column > 0 {
+				writer.WriteString(", ")
+			}
+			writer.Write(jsonString(field.Name))
+			writer.WriteString(": ")
+			raw, err := jsonValue(value, field, opts)
+			if err != nil {
+				return fmt.Errorf("e%d#%s.%s:row %d: %s", e175, me.Name,
+					field.Name, i, err)
+			}
+			writer.Write(raw)
+		}
+		writer.WriteString("}\n")
+	}
+	return writer.Flush()
+}
+
+// jsonValue returns the given value as JSON. The error has no error code.
+func jsonValue(value any, field *MetaFieldType, opts JSONOptions) ([]byte,
+	error) {
+	switch v := value.(type) {
+	case nil:
+		return []byte("null"), nil
+	case []byte:
+		if opts.HexBytes {
+			return jsonString(hex.EncodeToString(v)), nil
+		}
+		return jsonString(base64.StdEncoding.EncodeToString(v)), nil
+	case time.Time:
+		if field.Kind == DateField {
+			return jsonString(v.Format(DateFormat)), nil
+		}
+		return jsonString(v.Format(DateTimeFormat)), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("can't write %g as JSON", v)
+		}
+	case string:
+		return jsonString(v), nil
+	}
+	return json.Marshal(value)
+}
+
+// jsonString returns s as a JSON string (without escaping &, <, or >).
+func jsonString(s string) []byte {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s) // can't fail for a string
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+}
+
+// FromJSON reads JSON from r and returns it as a [Table] called tableName
+// (or Table if tableName is ""). The JSON must be an array of objects or a
+// sequence of objects (e.g., NDJSON, one object per line). Each object is
+// a record, and the fields are the objects' keys in order of first
+// appearance.
+//
+// Each field's kind is inferred using [InferKind] from its values (with
+// JSON true and false inferred as bools, and numbers as ints or reals), so,
+// for example, a field whose values are all "yyyy-mm-dd" strings becomes a
+// date. A field is nullable if any of its values are null or any of the
+// objects lack its key. Empty strings are empty strs in str fields and
+// nulls in other fields. Strings are never inferred as bytes. Nested
+// arrays and objects become strs holding their JSON text.
+func FromJSON(r io.Reader, tableName string) (*Table, error) {
+	objects, names, err := readJSONObjects(r)
+	if err != nil {
+		return nil, err
+	}
+	if tableName == "" {
+		tableName = "Table"
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("e%d#%s:no JSON objects with keys", e176,
+			tableName)
+	}
+	rows := make([][]string, 0, len(objects))
+	nulls := make([]bool, len(names))
+	for _, object := range objects {
+		row := make([]string, len(names))
+		for column, name := range names {
+			cell, ok := object[name]
+			if !ok || cell.null {
+				nulls[column] = true
+			} else {
+				row[column] = cell.text
+			}
+		}
+		rows = append(rows, row)
+	}
+	table, _ := InferTable(tableName, names, rows, 1)
+	for column, field := range table.Fields {
+		if field.Kind == StrField { // "" is an empty str not a null
+			field.AllowNull = nulls[column]
+		}
+	}
+	for i, object := range objects {
+		record := newRecord(len(names))
+		for column, name := range names {
+			field := table.Fields[column]
+			cell, ok := object[name]
+			if !ok || cell.null || (cell.text == "" &&
+				field.Kind != StrField) {
+				continue // record[column] is already nil
+			}
+			value, err := parseValue(cell.text, field.Kind)
+			if err != nil { // can't happen: the kind was inferred
+				return nil, fmt.Errorf("e%d#%s.%s:record %d: %s", e176,
+					table.Name, field.Name, i+1, err)
+			}
+			record[column] = value
+		}
+		table.Records = append(table.Records, record)
+	}
+	return table, nil
+}
+
+// jsonCell is a JSON value as text (e.g., for inference).
+type jsonCell struct {
+	text string
+	null bool
+}
+
+// readJSONObjects returns each object (an array of objects or a sequence
+// of objects) and the objects' keys in order of first appearance.
+func readJSONObjects(r io.Reader) ([]map[string]jsonCell, []string,
+	error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	objects := make([]map[string]jsonCell, 0)
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	inArray := false
+	for first := true; ; first = false {
+		token, err := decoder.Token()
+		if err == io.EOF && !inArray {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("e%d#invalid JSON: %s", e176, err)
+		}
+		if delim, ok := token.(json.Delim); ok && delim == '[' && first {
+			inArray = true
+			continue
+		}
+		if delim, ok := token.(json.Delim); ok && delim == ']' && inArray {
+			if _, err = decoder.Token(); err != io.EOF {
+				return nil, nil, fmt.Errorf("e%d#invalid JSON: expected "+
+					"end of data after ]", e176)
+			}
+			break
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '{' {
+			return nil, nil, fmt.Errorf("e%d#invalid JSON: expected an "+
+				"object, got %v", e176, token)
+		}
+		object := make(map[string]jsonCell)
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, nil, fmt.Errorf("e%d#invalid JSON: %s", e176,
+					err)
+			}
+			name := token.(string) // object keys are always strings
+			var value any
+			if err = decoder.Decode(&value); err != nil {
+				return nil, nil, fmt.Errorf("e%d#invalid JSON: %s", e176,
+					err)
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			object[name], err = jsonCellFor(value)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if _, err = decoder.Token(); err != nil { // }
+			return nil, nil, fmt.Errorf("e%d#invalid JSON: %s", e176, err)
+		}
+		objects = append(objects, object)
+	}
+	return objects, names, nil
+}
+
+func jsonCellFor(value any) (jsonCell, error) {
+	switch v := value.(type) {
+	case nil:
+		return jsonCell{null: true}, nil
+	case bool:
+		if v {
+			return jsonCell{text: "true"}, nil
+		}
+		return jsonCell{text: "false"}, nil
+	case json.Number:
+		return jsonCell{text: v.String()}, nil
+	case string:
+		return jsonCell{text: v}, nil
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return jsonCell{}, fmt.Errorf("e%d#invalid JSON: %s", e176, err)
+	}
+	return jsonCell{text: strings.TrimSpace(out.String())}, nil
+}
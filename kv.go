@@ -0,0 +1,315 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// KV is a persistent key–value store held in a .tdb file's kv table, which
+// has a key str field and a nullable <kind>_value field for each kind of
+// value. Each record holds one key's value in the field for the value's
+// kind, e.g.:
+//
+//	[kv key str bool_value bool? bytes_value bytes? date_value date?
+//	  datetime_value datetime? int_value int? real_value real?
+//	  str_value str?
+//	%
+//	<age> ? ? ? ? 42 ? ?
+//	<name> ? ? ? ? ? ? <Alice>
+//	]
+//
+// The records are kept in key order so that the file is easy to read and
+// edit by hand. (The file may have other tables too; they are left alone.)
+//
+// Every Put or Delete writes the whole file atomically (see [Config.Save]),
+// and if the file has been changed on disk (i.e., its modification time or
+// size has changed) since it was last read or written, it is reread before
+// any KV method uses it. A KV is safe for concurrent use by multiple
+// goroutines.
+type KV struct {
+	mutex    sync.Mutex
+	filename string
+	db       *Tdb
+	table    *Table
+	modTime  time.Time
+	size     int64
+}
+
+// kvKinds are the kinds of value a KV can hold in field order.
+var kvKinds = []FieldKind{BoolField, BytesField, DateField, DateTimeField,
+	IntField, RealField, StrField}
+
+// OpenKV returns a KV for the given .tdb file. If the file doesn't exist
+// the KV is empty, and the file is created by the first Put.
+func OpenKV(filename string) (*KV, error) {
+	kv := &KV{filename: filename}
+	if err := kv.load(); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// Get returns the given key's value and true, or nil and false if there's
+// no such key. The value is a bool, []byte, time.Time, int, float64, or
+// string.
+func (me *KV) Get(key string) (any, bool, error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if err := me.reloadIfChanged(); err != nil {
+		return nil, false, err
+	}
+	if row := me.find(key); row > -1 {
+		return me.value(me.table.Records[row]), true, nil
+	}
+	return nil, false, nil
+}
+
+// Put sets the given key to the given value (a bool, []byte, time.Time,
+// int, float64 (but not NaN or infinite), or string) and writes the file. A
+// time.Time is stored as a datetime. If the write fails the KV is left
+// unchanged.
+func (me *KV) Put(key string, value any) error {
+	kind := DateTimeField
+	switch v := value.(type) {
+	case bool:
+		kind = BoolField
+	case []byte:
+		kind = BytesField
+	case time.Time:
+	case int:
+		kind = IntField
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("e%d#%s:can't put %v (reals must be finite)",
+				e187, key, v)
+		}
+		kind = RealField
+	case string:
+		kind = StrField
+	default:
+		return fmt.Errorf("e%d#%s:can't put %v (%T)", e187, key, value,
+			value)
+	}
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if err := me.reloadIfChanged(); err != nil {
+		return err
+	}
+	column := me.table.FieldIndex(kvFieldName(kind))
+	if column == -1 {
+		if err := me.table.AddColumn(kvFieldName(kind), kind.String()+"?",
+			nil); err != nil {
+			return err
+		}
+		column = len(me.table.Fields) - 1
+	}
+	record := newRecord(len(me.table.Fields))
+	record[0] = key
+	record[column] = value
+	if row := me.find(key); row > -1 {
+		me.table.Records[row] = record
+	} else {
+		row, _ = slices.BinarySearchFunc(me.table.Records, key,
+			func(record Record, key string) int {
+				return compareValues(record[0], key)
+			})
+		me.table.Records = slices.Insert(me.table.Records, row, record)
+	}
+	if !me.hasTable() {
+		me.db.AddTable(me.table)
+	}
+	return me.save()
+}
+
+// Delete deletes the given key and writes the file, and returns true, or
+// returns false if there's no such key. If the write fails the KV is left
+// unchanged.
+func (me *KV) Delete(key string) (bool, error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if err := me.reloadIfChanged(); err != nil {
+		return false, err
+	}
+	row := me.find(key)
+	if row == -1 {
+		return false, nil
+	}
+	me.table.Records = slices.Delete(me.table.Records, row, row+1)
+	return true, me.save()
+}
+
+// Keys returns the keys in order.
+func (me *KV) Keys() ([]string, error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if err := me.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(me.table.Records))
+	for _, record := range me.table.Records {
+		keys = append(keys, record[0].(string))
+	}
+	return keys, nil
+}
+
+// All returns an iterator over the keys and values in key order, e.g.,
+//
+//	for key, value := range kv.All() { ... }
+//
+// It iterates over a snapshot, so the KV may be changed while iterating.
+// If the file has changed on disk and can't be reread, the iterator yields
+// nothing.
+func (me *KV) All() iter.Seq2[string, any] {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	records := make([]Record, 0)
+	if me.reloadIfChanged() == nil {
+		records = slices.Clone(me.table.Records)
+	}
+	return func(yield func(string, any) bool) {
+		for _, record := range records {
+			if !yield(record[0].(string), me.value(record)) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns how many keys there are.
+func (me *KV) Len() (int, error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if err := me.reloadIfChanged(); err != nil {
+		return 0, err
+	}
+	return len(me.table.Records), nil
+}
+
+// find returns the row of the given key or -1.
+func (me *KV) find(key string) int {
+	for row, record := range me.table.Records {
+		if record[0] == key {
+			return row
+		}
+	}
+	return -1
+}
+
+// value returns the record's value (its first non-null value after the
+// key).
+func (me *KV) value(record Record) any {
+	for _, value := range record[1:] {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+func (me *KV) hasTable() bool {
+	_, ok := me.db.Tables[me.table.Name]
+	return ok
+}
+
+// save writes the file or, if that fails, rereads it so that the KV
+// matches it.
+func (me *KV) save() error {
+	err := writeFileAtomically(me.filename, false, me.db.Write)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(me.filename); err == nil {
+			me.modTime, me.size = info.ModTime(), info.Size()
+			return nil
+		}
+	}
+	if loadErr := me.load(); loadErr != nil {
+		err = errors.Join(err, loadErr)
+	}
+	return err
+}
+
+// reloadIfChanged rereads the file if its modification time or size has
+// changed since it was last read or written.
+func (me *KV) reloadIfChanged() error {
+	info, err := os.Stat(me.filename)
+	if err == nil && info.ModTime().Equal(me.modTime) &&
+		info.Size() == me.size {
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) && me.size == -1 {
+		return nil // still doesn't exist
+	}
+	return me.load()
+}
+
+// load reads the file (or makes an empty KV if it doesn't exist).
+func (me *KV) load() error {
+	raw, err := os.ReadFile(me.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		db := NewTdb()
+		me.db, me.table = &db, newKVTable()
+		me.modTime, me.size = time.Time{}, -1
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(me.filename)
+	if err != nil {
+		return err
+	}
+	db, err := Parse(raw)
+	if err != nil {
+		return err
+	}
+	table, ok := db.Tables["kv"]
+	if !ok {
+		table = newKVTable()
+	} else if err = checkKVTable(table); err != nil {
+		return err
+	}
+	me.db, me.table = db, table
+	me.modTime, me.size = info.ModTime(), info.Size()
+	return nil
+}
+
+func newKVTable() *Table {
+	table := NewTable()
+	table.Name = "kv"
+	table.Fields = append(table.Fields, &MetaFieldType{"key", StrField,
+		false})
+	for _, kind := range kvKinds {
+		table.Fields = append(table.Fields, &MetaFieldType{kvFieldName(kind),
+			kind, true})
+	}
+	return &table
+}
+
+func kvFieldName(kind FieldKind) string { return kind.String() + "_value" }
+
+// checkKVTable returns an error if the table's first field isn't key str or
+// if any other field isn't a nullable <kind>_value field.
+func checkKVTable(table *Table) error {
+	if table.Fields[0].Name != "key" || table.Fields[0].Kind != StrField ||
+		table.Fields[0].AllowNull {
+		return fmt.Errorf("e%d#%s:the first field must be key str", e186,
+			table.Name)
+	}
+	for _, field := range table.Fields[1:] {
+		if field.Name != kvFieldName(field.Kind) || !field.AllowNull {
+			return fmt.Errorf("e%d#%s.%s:expected a field called %s of "+
+				"type %s?", e186, table.Name, field.Name,
+				kvFieldName(field.Kind), field.Kind)
+		}
+	}
+	return nil
+}
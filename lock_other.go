@@ -0,0 +1,16 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package tdb
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile fails: locking isn't supported on this platform.
+func lockFile(file *os.File) error { return errors.ErrUnsupported }
+
+func unlockFile(file *os.File) error { return errors.ErrUnsupported }
@@ -7,7 +7,6 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
-	"github.com/mark-summerfield/gset"
 	"reflect"
 	"strconv"
 	"strings"
@@ -51,13 +50,10 @@ func MarshalDecimals(db any, decimals int) ([]byte, error) {
 	}
 	if dbVal.Kind() == reflect.Struct {
 		dp := sanitizedDecimals(decimals)
-		dbType := dbVal.Type()
+		plan := dbPlanFor(dbVal.Type())
 		for i := 0; i < dbVal.NumField(); i++ {
 			field := dbVal.Field(i)
-			tableName := dbType.Field(i).Tag.Get("tdb")
-			if tableName == "" {
-				tableName = dbVal.Type().Field(i).Name
-			}
+			tableName := plan.tableNames[i]
 			if field.Kind() == reflect.Slice {
 				if field.Len() > 0 {
 					if err := marshalTable(&out, field, tableName,
@@ -83,15 +79,18 @@ func MarshalDecimals(db any, decimals int) ([]byte, error) {
 func marshalTable(out *bytes.Buffer, field reflect.Value, tableName string,
 	dp int) error {
 	if field.Len() > 0 {
-		dateIndexes, fieldNameForIndex, err := marshalMetaData(
-			out, tableName, field.Index(0).Interface())
-		if err != nil {
+		first := field.Index(0)
+		if first.Kind() != reflect.Struct {
+			return fmt.Errorf("e%d#%s:unrecognized field type %s", e104,
+				tableName, first.Type())
+		}
+		plan := recordPlanFor(first.Type())
+		if err := marshalMetaData(out, tableName, plan); err != nil {
 			return err
 		}
 		for i := 0; i < field.Len(); i++ {
-			record := field.Index(i).Interface()
-			if err := marshalRecord(out, record, dateIndexes, tableName,
-				fieldNameForIndex, dp); err != nil {
+			if err := marshalRecord(out, field.Index(i), plan, tableName,
+				dp); err != nil {
 				return err
 			}
 		}
@@ -101,99 +100,35 @@ func marshalTable(out *bytes.Buffer, field reflect.Value, tableName string,
 }
 
 func marshalMetaData(out *bytes.Buffer, tableName string,
-	table any) (gset.Set[int], map[int]string, error) {
-	dateIndexes := gset.New[int]()
-	fieldNameForIndex := make(map[int]string)
-	tableVal := reflect.ValueOf(table)
-	tableType := reflect.TypeOf(table)
+	plan *recordPlan) error {
 	out.WriteByte('[')
 	out.WriteString(tableName)
-	for i := 0; i < tableVal.NumField(); i++ {
-		field := tableVal.Field(i)
-		fieldName := tableVal.Type().Field(i).Name
-		tag := tableType.Field(i).Tag.Get("tdb")
-		var typeName string
-		if tag != "" {
-			fieldName, typeName = readTag(fieldName, tag)
-		}
-		fieldNameForIndex[i] = fieldName
-		isDate, err := marshalTableMetaData(out, field, typeName, tableName,
-			fieldName)
-		if err != nil {
-			return dateIndexes, fieldNameForIndex, err
-		}
-		if isDate {
-			dateIndexes.Add(i)
-		}
-	}
-	out.WriteString("\n%\n")
-	return dateIndexes, fieldNameForIndex, nil
-}
-
-func marshalTableMetaData(out *bytes.Buffer, field reflect.Value, typeName,
-	tableName, fieldName string) (bool, error) {
-	isDate := false
-	out.WriteByte(' ')
-	out.WriteString(fieldName)
-	out.WriteByte(' ')
-	nullable := false
-	kind := field.Kind()
-	if kind == reflect.Ptr {
-		kind = field.Type().Elem().Kind()
-		nullable = true
-	}
-	switch kind {
-	case reflect.Bool:
-		out.WriteString("bool")
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
-		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
-		reflect.Uint32, reflect.Uint64:
-		out.WriteString("int")
-	case reflect.Float32, reflect.Float64:
-		out.WriteString("real")
-	case reflect.String:
-		out.WriteString("str")
-	case reflect.Slice:
-		if field.Kind() == reflect.Ptr &&
-			field.Elem().Type() == byteSliceType {
-			out.WriteString("bytes")
-			nullable = true
-		} else {
-			x := field.Interface()
-			if reflect.TypeOf(x) == byteSliceType {
-				out.WriteString("bytes")
-			} else {
-				return isDate, fmt.Errorf(
-					"e%d#%s.%s:unrecognized field slice type %T", e103,
-					tableName, fieldName, field)
+	for _, field := range plan.fields {
+		if !field.ok {
+			if field.isSlice {
+				return fmt.Errorf(
+					"e%d#%s.%s:unrecognized field slice type", e103,
+					tableName, field.name)
 			}
+			return fmt.Errorf("e%d#%s.%s:unrecognized field type", e104,
+				tableName, field.name)
 		}
-	default:
-		x := field.Interface()
-		if reflect.TypeOf(x) == dateTimeType {
-			if typeName == "date" {
-				isDate = true
-				out.WriteString(typeName)
-			} else {
-				out.WriteString("datetime")
-			}
-		} else {
-			return isDate, fmt.Errorf(
-				"e%d#%s.%s:unrecognized field type %T", e104, tableName,
-				fieldName, x)
+		out.WriteByte(' ')
+		out.WriteString(field.name)
+		out.WriteByte(' ')
+		out.WriteString(field.kind.String())
+		if field.nullable {
+			out.WriteByte('?')
 		}
 	}
-	if nullable {
-		out.WriteByte('?')
-	}
-	return isDate, nil
+	out.WriteString("\n%\n")
+	return nil
 }
 
-func marshalRecord(out *bytes.Buffer, record any, dateIndexes gset.Set[int],
-	tableName string, fieldNameForIndex map[int]string, dp int) error {
-	recVal := reflect.ValueOf(record)
+func marshalRecord(out *bytes.Buffer, recVal reflect.Value,
+	plan *recordPlan, tableName string, dp int) error {
 	sep := ""
-	for i := 0; i < recVal.NumField(); i++ {
+	for i, fieldPlan := range plan.fields {
 		out.WriteString(sep)
 		sep = " "
 		field := recVal.Field(i)
@@ -227,12 +162,12 @@ func marshalRecord(out *bytes.Buffer, record any, dateIndexes gset.Set[int],
 			if field.IsNil() {
 				out.WriteByte('?')
 			} else if err := marshalSliceField(out, field, tableName,
-				fieldNameForIndex[i]); err != nil {
+				fieldPlan.name); err != nil {
 				return err
 			}
 		default:
 			if err := marshalDateTimeField(out, field, tableName,
-				fieldNameForIndex[i], dateIndexes.Contains(i)); err != nil {
+				fieldPlan.name, fieldPlan.isDate); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,373 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConflictsTableName is the name of the table that [Merge] adds to its
+// result if there are any conflicts.
+const ConflictsTableName = "tdb_conflicts"
+
+// MergeConflict is a change made by both ours and theirs that [Merge]
+// couldn't reconcile. Base, Ours, and Theirs are in Tdb format (a table's
+// fields, a record's values, or a field's value), or "" if absent.
+type MergeConflict struct {
+	Table  string
+	Key    string // The record's key values ("" for a table conflict)
+	Field  string // "" for a table or whole record conflict
+	Base   string
+	Ours   string
+	Theirs string
+}
+
+// Merge does a three-way merge of the changes made to base by ours and by
+// theirs, matching each table's records by their first field (see also
+// [MergeKeys]), and returns the merged Tdb and any conflicts.
+//
+// Changes made by only one side are applied, and changes made the same way
+// by both sides are applied once. Records changed by both sides are merged
+// field by field, so only a field that both sides changed differently is a
+// conflict. Other conflicts are a record (or table) that both sides added
+// differently, or that one side deleted and the other changed, and a table
+// whose fields both sides changed differently (or whose records can't be
+// converted to the other side's fields). Tables' fields are compared by
+// name: a table's records are converted to the fields of the side that
+// changed them.
+//
+// For each conflict the merged Tdb has ours' version, except that a record
+// or table that one side deleted and the other changed is kept with the
+// change. If there are any conflicts, the merged Tdb has a tdb_conflicts
+// table (see [ConflictsTableName]) as its last table with a record for
+// each conflict, whose fields are table str, key str?, field str?, and
+// base, ours, and theirs str? (with absent values as nulls). The merged
+// Tdb is a valid Tdb either way, so conflicts can be resolved by editing
+// it and deleting the tdb_conflicts table. Any tdb_conflicts tables in
+// base, ours, or theirs are ignored.
+//
+// It is an error if a key field is missing or if a table has two records
+// with the same key.
+func Merge(base, ours, theirs *Tdb) (*Tdb, []MergeConflict, error) {
+	return MergeKeys(base, ours, theirs, nil)
+}
+
+// MergeKeys is the same as [Merge] except that the records of each table
+// in keys (which maps table names to field names) are matched by the given
+// key fields rather than by their first field.
+func MergeKeys(base, ours, theirs *Tdb, keys map[string][]string) (*Tdb,
+	[]MergeConflict, error) {
+	db := NewTdb()
+	conflicts := make([]MergeConflict, 0)
+	tableNames := slices.Clone(ours.TableNames)
+	for _, tableName := range theirs.TableNames {
+		if _, ok := ours.Tables[tableName]; !ok {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	for _, tableName := range tableNames {
+		if tableName == ConflictsTableName {
+			continue
+		}
+		merger := &tableMerger{base: base.Tables[tableName],
+			ours: ours.Tables[tableName], theirs: theirs.Tables[tableName],
+			keys: keys[tableName]}
+		table, err := merger.merge(tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if table != nil {
+			db.AddTable(table)
+		}
+		conflicts = append(conflicts, merger.conflicts...)
+	}
+	if len(conflicts) > 0 {
+		db.AddTable(conflictsTable(conflicts))
+	}
+	return &db, conflicts, nil
+}
+
+type tableMerger struct {
+	base      *Table // nil if absent
+	ours      *Table // nil if absent
+	theirs    *Table // nil if absent
+	keys      []string
+	fields    []*MetaFieldType // the merged table's fields
+	columns   []int            // the key columns in fields
+	conflicts []MergeConflict
+}
+
+func (me *tableMerger) merge(tableName string) (*Table, error) {
+	switch {
+	case me.ours == nil && me.theirs == nil:
+		return nil, nil // deleted by both
+	case me.ours == nil || me.theirs == nil:
+		table := me.ours
+		if table == nil {
+			table = me.theirs
+		}
+		if me.base == nil || !table.Equal(me.base, EqualOptions{}) {
+			if me.base != nil { // deleted by one side, changed by the other
+				me.addTableConflict()
+			}
+			return table.Clone(), nil
+		}
+		return nil, nil // deleted by one side
+	}
+	base := me.base
+	if base == nil { // added by both
+		if !sameFields(me.ours.Fields, me.theirs.Fields) {
+			me.addTableConflict()
+			return me.ours.Clone(), nil
+		}
+		empty := NewTable()
+		empty.Name = tableName
+		empty.Fields = me.ours.Fields
+		base = &empty
+	}
+	switch {
+	case sameFields(me.ours.Fields, me.theirs.Fields),
+		sameFields(me.theirs.Fields, base.Fields):
+		me.fields = me.ours.Fields
+	case sameFields(me.ours.Fields, base.Fields):
+		me.fields = me.theirs.Fields
+	default:
+		me.addTableConflict()
+		return me.ours.Clone(), nil
+	}
+	baseRecords, err1 := conform(base, me.fields)
+	ourRecords, err2 := conform(me.ours, me.fields)
+	theirRecords, err3 := conform(me.theirs, me.fields)
+	if err1 != nil || err2 != nil || err3 != nil {
+		me.addTableConflict()
+		return me.ours.Clone(), nil
+	}
+	if err := me.setKeyColumns(tableName); err != nil {
+		return nil, err
+	}
+	return me.mergeRecords(tableName, baseRecords, ourRecords,
+		theirRecords)
+}
+
+func (me *tableMerger) setKeyColumns(tableName string) error {
+	keys := me.keys
+	if len(keys) == 0 {
+		keys = []string{me.fields[0].Name}
+	}
+	for _, key := range keys {
+		column := slices.IndexFunc(me.fields, func(
+			field *MetaFieldType) bool {
+			return field.Name == key
+		})
+		if column == -1 {
+			return fmt.Errorf("e%d#%s.%s:no such key field", e194,
+				tableName, key)
+		}
+		me.columns = append(me.columns, column)
+	}
+	return nil
+}
+
+func (me *tableMerger) mergeRecords(tableName string, baseRecords,
+	ourRecords, theirRecords []Record) (*Table, error) {
+	baseKeys, err1 := me.byKey(tableName, baseRecords)
+	ourKeys, err2 := me.byKey(tableName, ourRecords)
+	theirKeys, err3 := me.byKey(tableName, theirRecords)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, err
+	}
+	table := NewTable()
+	table.Name = tableName
+	table.Fields = slices.Clone(me.fields)
+	for _, record := range ourRecords {
+		key := record.keyFor(me.columns)
+		if merged := me.mergeRecord(tableName, baseKeys[key], record,
+			theirKeys[key]); merged != nil {
+			table.Records = append(table.Records, merged)
+		}
+	}
+	for _, record := range theirRecords {
+		key := record.keyFor(me.columns)
+		if _, ok := ourKeys[key]; ok {
+			continue
+		}
+		if merged := me.mergeRecord(tableName, baseKeys[key], nil,
+			record); merged != nil {
+			table.Records = append(table.Records, merged)
+		}
+	}
+	return &table, nil
+}
+
+// mergeRecord returns the merged record or nil if it is deleted. Any of
+// base, ours, and theirs may be nil if absent (but not both ours and
+// theirs).
+func (me *tableMerger) mergeRecord(tableName string, base, ours,
+	theirs Record) Record {
+	switch {
+	case ours != nil && theirs != nil:
+		if recordsEqual(ours, theirs) {
+			return ours
+		}
+		if base == nil { // added by both
+			me.addRecordConflict(tableName, base, ours, theirs)
+			return ours
+		}
+		merged := slices.Clone(ours)
+		for column := range merged {
+			b, o, t := base[column], ours[column], theirs[column]
+			switch {
+			case valuesEqual(o, t, 0), valuesEqual(t, b, 0):
+			case valuesEqual(o, b, 0):
+				merged[column] = t
+			default:
+				me.conflicts = append(me.conflicts, MergeConflict{
+					tableName, me.keyText(ours), me.fields[column].Name,
+					valueText(me.fields[column], b),
+					valueText(me.fields[column], o),
+					valueText(me.fields[column], t)})
+			}
+		}
+		return merged
+	case base == nil: // added by one side
+		if ours != nil {
+			return ours
+		}
+		return theirs
+	}
+	changed := ours
+	if changed == nil {
+		changed = theirs
+	}
+	if recordsEqual(changed, base) {
+		return nil // deleted by one side
+	}
+	me.addRecordConflict(tableName, base, ours, theirs)
+	return changed // deleted by one side, changed by the other
+}
+
+// byKey returns the records keyed by their key values.
+func (me *tableMerger) byKey(tableName string,
+	records []Record) (map[string]Record, error) {
+	keyed := make(map[string]Record, len(records))
+	for _, record := range records {
+		key := record.keyFor(me.columns)
+		if _, ok := keyed[key]; ok {
+			return nil, fmt.Errorf("e%d#%s:duplicate key %s", e195,
+				tableName, me.keyText(record))
+		}
+		keyed[key] = record
+	}
+	return keyed, nil
+}
+
+func (me *tableMerger) keyText(record Record) string {
+	texts := make([]string, 0, len(me.columns))
+	for _, column := range me.columns {
+		texts = append(texts, valueText(me.fields[column], record[column]))
+	}
+	return strings.Join(texts, " ")
+}
+
+func (me *tableMerger) addRecordConflict(tableName string, base, ours,
+	theirs Record) {
+	key := ours
+	if key == nil {
+		key = theirs
+	}
+	texts := make([]string, 0, 3)
+	for _, record := range []Record{base, ours, theirs} {
+		text := ""
+		if record != nil {
+			text = recordText(me.fields, record)
+		}
+		texts = append(texts, text)
+	}
+	me.conflicts = append(me.conflicts, MergeConflict{tableName,
+		me.keyText(key), "", texts[0], texts[1], texts[2]})
+}
+
+func (me *tableMerger) addTableConflict() {
+	texts := make([]string, 0, 3)
+	tableName := ""
+	for _, table := range []*Table{me.base, me.ours, me.theirs} {
+		text := ""
+		if table != nil {
+			tableName = table.Name
+			fields := make([]string, 0, len(table.Fields))
+			for _, field := range table.Fields {
+				fields = append(fields, fieldText(field))
+			}
+			text = strings.Join(fields, " ")
+		}
+		texts = append(texts, text)
+	}
+	me.conflicts = append(me.conflicts, MergeConflict{tableName, "", "",
+		texts[0], texts[1], texts[2]})
+}
+
+// conform returns the table's records converted to the given fields
+// (matched by name), or an error if a record can't be converted, e.g.,
+// because a non-nullable field is missing.
+func conform(table *Table, fields []*MetaFieldType) ([]Record, error) {
+	if sameFields(table.Fields, fields) {
+		return table.Records, nil
+	}
+	columns := make([]int, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, table.FieldIndex(field.Name))
+	}
+	records := make([]Record, 0, len(table.Records))
+	for _, record := range table.Records {
+		conformed := newRecord(len(fields))
+		for i, field := range fields {
+			if columns[i] > -1 {
+				value, err := convertValue(record[columns[i]],
+					table.Fields[columns[i]].Kind, field.Kind)
+				if err != nil {
+					return nil, err
+				}
+				conformed[i] = value
+			}
+			if err := checkValue(field, conformed[i]); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, conformed)
+	}
+	return records, nil
+}
+
+func sameFields(a, b []*MetaFieldType) bool {
+	return slices.EqualFunc(a, b, func(x, y *MetaFieldType) bool {
+		return *x == *y
+	})
+}
+
+func recordsEqual(a, b Record) bool {
+	return slices.EqualFunc(a, b, func(x, y any) bool {
+		return valuesEqual(x, y, 0)
+	})
+}
+
+func conflictsTable(conflicts []MergeConflict) *Table {
+	table := diffTable(ConflictsTableName, "table str key str? field str? "+
+		"base str? ours str? theirs str?")
+	orNull := func(s string) any {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	for _, conflict := range conflicts {
+		table.Records = append(table.Records, Record{conflict.Table,
+			orNull(conflict.Key), orNull(conflict.Field),
+			orNull(conflict.Base), orNull(conflict.Ours),
+			orNull(conflict.Theirs)})
+	}
+	return table
+}
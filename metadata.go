@@ -38,13 +38,19 @@ func (me MetaTableType) Len() int {
 	return len(me.Fields)
 }
 
-func (me *MetaTableType) AddField(fieldName, typeName string) bool {
-	AllowNull := false
-	if strings.HasSuffix(typeName, "?") {
-		typeName = strings.TrimSuffix(typeName, "?")
-		AllowNull = true
+// clone returns a deep copy (so that the copy's fields may be changed
+// without affecting the original).
+func (me MetaTableType) clone() MetaTableType {
+	fields := make([]*MetaFieldType, 0, len(me.Fields))
+	for _, field := range me.Fields {
+		metaField := *field
+		fields = append(fields, &metaField)
 	}
-	kind, ok := newFieldKind(typeName)
+	return MetaTableType{me.Name, fields}
+}
+
+func (me *MetaTableType) AddField(fieldName, typeName string) bool {
+	kind, AllowNull, ok := parseTypeName(typeName)
 	if ok {
 		metaField := MetaFieldType{fieldName, kind, AllowNull}
 		me.Fields = append(me.Fields, &metaField)
@@ -74,6 +80,18 @@ const (
 	StrField
 )
 
+// parseTypeName returns the kind and nullability for a typename such as
+// "int" or "str?", and whether the typename is valid.
+func parseTypeName(typeName string) (FieldKind, bool, bool) {
+	allowNull := false
+	if strings.HasSuffix(typeName, "?") {
+		typeName = strings.TrimSuffix(typeName, "?")
+		allowNull = true
+	}
+	kind, ok := newFieldKind(typeName)
+	return kind, allowNull, ok
+}
+
 func newFieldKind(typename string) (FieldKind, bool) {
 	switch typename {
 	case "bool":
@@ -0,0 +1,102 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import "fmt"
+
+// AppendRecord adds the given record to the end of the table's records
+// providing it is valid, i.e., it has the right number of values, and each
+// value has the Go type that Tdb uses for its field's kind (e.g., int not
+// int32, float64 not float32) or is nil for a nullable field.
+//
+// See also [Table.InsertRecord].
+func (me *Table) AppendRecord(record Record) error {
+	if err := me.checkRecord(record); err != nil {
+		return err
+	}
+	me.Records = append(me.Records, record)
+	for _, index := range me.ownIndexes() {
+		index.add(len(me.Records) - 1)
+	}
+	return nil
+}
+
+// InsertRecord inserts the given record at the given row providing it is
+// valid (see [Table.AppendRecord]). If row == the number of records, the
+// record is appended. Since later records' rows change, any indexes are
+// rebuilt.
+func (me *Table) InsertRecord(row int, record Record) error {
+	if row < 0 || row > len(me.Records) {
+		return fmt.Errorf("e%d#%s:row %d out of range (0-%d)", e151,
+			me.Name, row, len(me.Records))
+	}
+	if err := me.checkRecord(record); err != nil {
+		return err
+	}
+	me.Records = append(me.Records, nil)
+	copy(me.Records[row+1:], me.Records[row:])
+	me.Records[row] = record
+	me.Reindex()
+	return nil
+}
+
+// UpdateValue sets the value of the given field in the given row providing
+// the value is valid for the field (see [Table.AppendRecord]).
+func (me *Table) UpdateValue(row int, fieldName string, value any) error {
+	if row < 0 || row >= len(me.Records) {
+		return fmt.Errorf("e%d#%s:row %d out of range (0-%d)", e151,
+			me.Name, row, len(me.Records)-1)
+	}
+	column := me.FieldIndex(fieldName)
+	if column == -1 {
+		return fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			fieldName)
+	}
+	if err := checkValue(me.Fields[column], value); err != nil {
+		return fmt.Errorf("e%d#%s.%s:%s", e156, me.Name, fieldName, err)
+	}
+	indexes := me.indexesOn(column)
+	for _, index := range indexes {
+		index.remove(row)
+	}
+	me.Records[row][column] = value
+	for _, index := range indexes {
+		index.add(row)
+	}
+	return nil
+}
+
+// DeleteRecords deletes every record for which pred returns true and
+// returns how many were deleted.
+func (me *Table) DeleteRecords(pred func(Record) bool) int {
+	records := me.Records[:0]
+	for _, record := range me.Records {
+		if !pred(record) {
+			records = append(records, record)
+		}
+	}
+	deleted := len(me.Records) - len(records)
+	for i := len(records); i < len(me.Records); i++ {
+		me.Records[i] = nil // allow garbage collection
+	}
+	me.Records = records
+	if deleted > 0 {
+		me.Reindex()
+	}
+	return deleted
+}
+
+func (me *Table) checkRecord(record Record) error {
+	if len(record) != len(me.Fields) {
+		return fmt.Errorf("e%d#%s:expected %d values, got %d", e155,
+			me.Name, len(me.Fields), len(record))
+	}
+	for column, field := range me.Fields {
+		if err := checkValue(field, record[column]); err != nil {
+			return fmt.Errorf("e%d#%s.%s:%s", e156, me.Name, field.Name,
+				err)
+		}
+	}
+	return nil
+}
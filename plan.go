@@ -0,0 +1,206 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// These caches hold the compiled plans used by [Marshal] and [Unmarshal]
+// so that the reflection setup is done once per type rather than once per
+// call (or per record). They're safe for concurrent use.
+var (
+	dbPlans     sync.Map // key is outer struct reflect.Type; value *dbPlan
+	recordPlans sync.Map // key is record struct reflect.Type; value *recordPlan
+)
+
+// dbPlan describes an outer (database) struct.
+type dbPlan struct {
+	tableNames []string       // Tdb tablename for each struct field
+	indexes    map[string]int // key is Tdb tablename or Go fieldname
+}
+
+// recordPlan describes an inner (record) struct.
+type recordPlan struct {
+	fields []*fieldPlan
+}
+
+// fieldPlan describes one field of a record struct.
+type fieldPlan struct {
+	name     string    // Tdb fieldname (the Go fieldname unless tagged)
+	goName   string    // Go fieldname
+	kind     FieldKind // valid only if ok
+	ok       bool      // false if the Go type has no Tdb equivalent
+	isSlice  bool      // true for unsupported (non-[]byte) slices
+	isDate   bool      // true for time.Time fields tagged as date
+	nullable bool      // true for pointer fields
+	exported bool
+	set      func(field reflect.Value, value any) // value must be non-nil
+	get      func(field reflect.Value) any        // returns nil for nil
+}
+
+func dbPlanFor(dbType reflect.Type) *dbPlan {
+	if plan, ok := dbPlans.Load(dbType); ok {
+		return plan.(*dbPlan)
+	}
+	plan := &dbPlan{make([]string, dbType.NumField()), make(map[string]int)}
+	for i := 0; i < dbType.NumField(); i++ {
+		field := dbType.Field(i)
+		plan.indexes[field.Name] = i
+		tableName := field.Tag.Get("tdb")
+		if tableName == "" {
+			tableName = field.Name
+		} else {
+			plan.indexes[tableName] = i
+		}
+		plan.tableNames[i] = tableName
+	}
+	actual, _ := dbPlans.LoadOrStore(dbType, plan)
+	return actual.(*dbPlan)
+}
+
+func recordPlanFor(recordType reflect.Type) *recordPlan {
+	if plan, ok := recordPlans.Load(recordType); ok {
+		return plan.(*recordPlan)
+	}
+	plan := &recordPlan{make([]*fieldPlan, recordType.NumField())}
+	for i := 0; i < recordType.NumField(); i++ {
+		plan.fields[i] = newFieldPlan(recordType.Field(i))
+	}
+	actual, _ := recordPlans.LoadOrStore(recordType, plan)
+	return actual.(*recordPlan)
+}
+
+func newFieldPlan(field reflect.StructField) *fieldPlan {
+	plan := &fieldPlan{name: field.Name, goName: field.Name,
+		exported: field.IsExported()}
+	var typeName string
+	if tag := field.Tag.Get("tdb"); tag != "" {
+		plan.name, typeName = readTag(field.Name, tag)
+	}
+	goType := field.Type
+	if goType.Kind() == reflect.Ptr {
+		goType = goType.Elem()
+		plan.nullable = true
+	}
+	plan.ok = true
+	switch goType.Kind() {
+	case reflect.Bool:
+		plan.kind = BoolField
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		plan.kind = IntField
+	case reflect.Float32, reflect.Float64:
+		plan.kind = RealField
+	case reflect.String:
+		plan.kind = StrField
+	case reflect.Slice:
+		if goType == byteSliceType {
+			plan.kind = BytesField
+		} else {
+			plan.ok = false
+			plan.isSlice = true
+		}
+	default:
+		if goType == dateTimeType {
+			if typeName == "date" {
+				plan.kind = DateField
+				plan.isDate = true
+			} else {
+				plan.kind = DateTimeField
+			}
+		} else {
+			plan.ok = false
+		}
+	}
+	plan.set = makeSetter(field.Type)
+	plan.get = makeGetter(field.Type)
+	return plan
+}
+
+// makeSetter returns a function that sets a field of the given type to the
+// given Tdb value (which must be one of bool, []byte, int, float64, string,
+// or time.Time). For pointer fields a new value is allocated.
+func makeSetter(fieldType reflect.Type) func(reflect.Value, any) {
+	if fieldType.Kind() == reflect.Ptr {
+		setElem := makeSetter(fieldType.Elem())
+		elemType := fieldType.Elem()
+		return func(field reflect.Value, value any) {
+			ptr := reflect.New(elemType)
+			setElem(ptr.Elem(), value)
+			field.Set(ptr)
+		}
+	}
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return func(field reflect.Value, value any) {
+			field.SetBool(value.(bool))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return func(field reflect.Value, value any) {
+			field.SetInt(int64(value.(int)))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return func(field reflect.Value, value any) {
+			field.SetUint(uint64(value.(int)))
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(field reflect.Value, value any) {
+			field.SetFloat(value.(float64))
+		}
+	case reflect.String:
+		return func(field reflect.Value, value any) {
+			field.SetString(value.(string))
+		}
+	case reflect.Slice:
+		return func(field reflect.Value, value any) {
+			field.SetBytes(value.([]byte))
+		}
+	}
+	return func(field reflect.Value, value any) {
+		field.Set(reflect.ValueOf(value.(time.Time)))
+	}
+}
+
+// makeGetter returns a function that returns a field of the given type as a
+// Tdb value (i.e., as one of bool, []byte, int, float64, string, or
+// time.Time), or nil for nil pointers and nil byte slices.
+func makeGetter(fieldType reflect.Type) func(reflect.Value) any {
+	if fieldType.Kind() == reflect.Ptr {
+		getElem := makeGetter(fieldType.Elem())
+		return func(field reflect.Value) any {
+			if field.IsNil() {
+				return nil
+			}
+			return getElem(field.Elem())
+		}
+	}
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return func(field reflect.Value) any { return field.Bool() }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return func(field reflect.Value) any { return int(field.Int()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return func(field reflect.Value) any { return int(field.Uint()) }
+	case reflect.Float32, reflect.Float64:
+		return func(field reflect.Value) any { return field.Float() }
+	case reflect.String:
+		return func(field reflect.Value) any { return field.String() }
+	case reflect.Slice:
+		return func(field reflect.Value) any {
+			if field.IsNil() {
+				return nil
+			}
+			return field.Bytes()
+		}
+	}
+	return func(field reflect.Value) any { return field.Interface() }
+}
@@ -0,0 +1,257 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where returns a new table with the same name and fields as this one and
+// with copies of those records for which pred returns true.
+func (me *Table) Where(pred func(Record) bool) *Table {
+	table := me.emptyCopy()
+	for _, record := range me.Records {
+		if pred(record) {
+			table.Records = append(table.Records, record.clone())
+		}
+	}
+	return table
+}
+
+// Select returns a new table with the same name as this one but with only
+// the given fields (in the given order) and their values.
+func (me *Table) Select(fieldNames ...string) (*Table, error) {
+	columns, err := me.fieldIndexes(fieldNames)
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{MetaTableType{me.Name,
+		make([]*MetaFieldType, 0, len(columns))},
+		make([]Record, 0, len(me.Records)), nil}
+	for _, column := range columns {
+		field := *me.Fields[column]
+		table.Fields = append(table.Fields, &field)
+	}
+	for _, record := range me.Records {
+		newRecord := make(Record, 0, len(columns))
+		for _, column := range columns {
+			newRecord = append(newRecord, record[column])
+		}
+		table.Records = append(table.Records, newRecord)
+	}
+	return table, nil
+}
+
+// OrderBy returns a new table with the same name and fields as this one and
+// with copies of its records sorted by the given field, in ascending order
+// or in descending order if desc is true. Nulls sort first (last if desc).
+//
+// The sort is stable, so to sort by several fields, call OrderBy for each
+// field, starting with the least significant, e.g.,
+// table.OrderBy("ename", false) then OrderBy("deptno", false) to order by
+// department and within that by name.
+func (me *Table) OrderBy(fieldName string, desc bool) (*Table, error) {
+	column := me.FieldIndex(fieldName)
+	if column == -1 {
+		return nil, fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			fieldName)
+	}
+	table := me.Limit(len(me.Records))
+	slices.SortStableFunc(table.Records, func(a, b Record) int {
+		if desc {
+			return compareValues(b[column], a[column])
+		}
+		return compareValues(a[column], b[column])
+	})
+	return table, nil
+}
+
+// Limit returns a new table with the same name and fields as this one and
+// with copies of (up to) its first n records.
+func (me *Table) Limit(n int) *Table {
+	n = max(0, min(n, len(me.Records)))
+	table := me.emptyCopy()
+	for _, record := range me.Records[:n] {
+		table.Records = append(table.Records, record.clone())
+	}
+	return table
+}
+
+// Distinct returns a new table with the same name and fields as this one
+// and with copies of its records omitting duplicates (only the first of
+// any identical records is kept).
+func (me *Table) Distinct() *Table {
+	table := me.emptyCopy()
+	seen := make(map[string]bool, len(me.Records))
+	for _, record := range me.Records {
+		key := recordKey(record)
+		if !seen[key] {
+			seen[key] = true
+			table.Records = append(table.Records, record.clone())
+		}
+	}
+	return table
+}
+
+// emptyCopy returns a new table with the same name and fields as this one
+// but with no records.
+func (me *Table) emptyCopy() *Table {
+	return &Table{me.MetaTableType.clone(), make([]Record, 0), nil}
+}
+
+// fieldIndexes returns the index of each of the given fields or an error
+// if any doesn't exist.
+func (me *Table) fieldIndexes(fieldNames []string) ([]int, error) {
+	columns := make([]int, 0, len(fieldNames))
+	for _, fieldName := range fieldNames {
+		column := me.FieldIndex(fieldName)
+		if column == -1 {
+			return nil, fmt.Errorf("e%d#%s:no field called %q", e152,
+				me.Name, fieldName)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func (me Record) clone() Record {
+	return slices.Clone(me)
+}
+
+// compareValues returns -1, 0, or 1 depending on whether a is less than,
+// equal to, or greater than b. Nil is less than any other value; ints and
+// reals are compared numerically; values of different (non-numeric) types
+// are ordered by type.
+func compareValues(a, b any) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0
+			case !x:
+				return -1
+			}
+			return 1
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y)
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	case int:
+		switch y := b.(type) {
+		case int:
+			return cmpOrdered(x, y)
+		case float64:
+			return cmpOrdered(float64(x), y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int:
+			return cmpOrdered(x, float64(y))
+		case float64:
+			return cmpOrdered(x, y)
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	}
+	return cmpOrdered(typeOrder(a), typeOrder(b))
+}
+
+func cmpOrdered[T int | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func typeOrder(value any) int {
+	switch value.(type) {
+	case bool:
+		return 1
+	case int, float64:
+		return 2
+	case string:
+		return 3
+	case time.Time:
+		return 4
+	case []byte:
+		return 5
+	}
+	return 6
+}
+
+// recordKey returns a string that uniquely identifies the given record's
+// values (e.g., for use as a map key).
+func recordKey(record Record) string {
+	var key strings.Builder
+	for _, value := range record {
+		writeValueKey(&key, value)
+	}
+	return key.String()
+}
+
+// writeValueKey writes a string that uniquely identifies the given value
+// to the given builder. Ints and reals with the same numeric value have
+// the same key.
+func writeValueKey(key *strings.Builder, value any) {
+	switch v := value.(type) {
+	case nil:
+		key.WriteString("?")
+	case bool:
+		if v {
+			key.WriteString("T")
+		} else {
+			key.WriteString("F")
+		}
+	case []byte:
+		key.WriteString("(")
+		key.WriteString(hex.EncodeToString(v))
+	case time.Time:
+		key.WriteString("@")
+		key.WriteString(strconv.FormatInt(v.Unix(), 10))
+	case int:
+		key.WriteString("#")
+		key.WriteString(strconv.Itoa(v))
+	case float64:
+		key.WriteString("#")
+		if i := int(v); float64(i) == v {
+			key.WriteString(strconv.Itoa(i))
+		} else {
+			key.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	case string:
+		key.WriteString("<")
+		key.WriteString(strconv.Itoa(len(v)))
+		key.WriteString(":")
+		key.WriteString(v)
+	default:
+		key.WriteString(fmt.Sprintf("!%T:%v", v, v))
+	}
+	key.WriteByte(0)
+}
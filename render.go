@@ -0,0 +1,342 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"html"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RenderFormat is the format used by [Table.Render].
+type RenderFormat uint8
+
+const (
+	Markdown RenderFormat = iota // a GitHub Markdown table
+	HTML                         // an HTML <table>
+	Grid                         // a box-drawn text grid
+)
+
+// DefaultRenderWidth is the default maximum display width of a column for
+// [Table.Render].
+const DefaultRenderWidth = 40
+
+// Render writes the table's field names and records to w in the given
+// format with a maximum column width of [DefaultRenderWidth] (see
+// [Table.RenderWidth]).
+func (me *Table) Render(w io.Writer, format RenderFormat) error {
+	return me.RenderWidth(w, format, DefaultRenderWidth)
+}
+
+// RenderWidth writes the table's field names and records to w in the given
+// format with each column at most width display columns wide (or any width
+// if width is 0). Widths are measured in display columns, so, for example,
+// CJK characters count as two and combining marks as none.
+//
+// Nulls are rendered as empty cells, bools as T or F, bytes as hex, and
+// dates and datetimes in ISO 8601 format. Int and real columns are
+// right-aligned.
+//
+// Markdown cells are single lines, so longer values are truncated (with an
+// ellipsis) and a multiline str's newlines are rendered as <br> (with
+// Markdown and HTML special characters escaped). HTML
+// content is escaped and never truncated (the browser wraps it), with
+// newlines rendered as <br>. Grid cells are wrapped: a multiline str keeps
+// its line breaks and longer lines are word-wrapped; if any record needs
+// more than one line, the records are separated by rules.
+func (me *Table) RenderWidth(w io.Writer, format RenderFormat,
+	width int) error {
+	writer := bufio.NewWriter(w)
+	rows := make([][]string, 0, len(me.Records)+1)
+	header := make([]string, 0, len(me.Fields))
+	for _, field := range me.Fields {
+		header = append(header, field.Name)
+	}
+	rows = append(rows, header)
+	for _, record := range me.Records {
+		row := make([]string, 0, len(record))
+		for column, value := range record {
+			row = append(row, renderValue(value, me.Fields[column].Kind))
+		}
+		rows = append(rows, row)
+	}
+	right := make([]bool, len(me.Fields))
+	for column, field := range me.Fields {
+		right[column] = field.Kind == IntField || field.Kind == RealField
+	}
+	switch format {
+	case HTML:
+		renderHTML(writer, rows, right)
+	case Grid:
+		renderGrid(writer, rows, right, width)
+	default:
+		renderMarkdown(writer, rows, right, width)
+	}
+	return writer.Flush()
+}
+
+// renderValue returns the given value as display text.
+func renderValue(value any, kind FieldKind) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return hex.EncodeToString(v)
+	}
+	s, _ := formatValue(value, kind) // only fails for non-Tdb values
+	return s
+}
+
+func renderMarkdown(writer *bufio.Writer, rows [][]string, right []bool,
+	width int) {
+	for _, row := range rows {
+		for column, cell := range row {
+			cell = html.EscapeString(truncateWidth(cell, width))
+			cell = strings.ReplaceAll(strings.ReplaceAll(cell, "\r\n",
+				"\n"), "\n", "<br>")
+			row[column] = strings.ReplaceAll(cell, "|", `\|`)
+		}
+	}
+	widths := columnWidths(rows, 3) // --- is the minimum
+	for i, row := range rows {
+		renderMarkdownRow(writer, row, widths, right)
+		if i == 0 {
+			rule := make([]string, len(widths))
+			for column, size := range widths {
+				if right[column] {
+					rule[column] = strings.Repeat("-", size-1) + ":"
+				} else {
+					rule[column] = strings.Repeat("-", size)
+				}
+			}
+			renderMarkdownRow(writer, rule, widths, nil)
+		}
+	}
+}
+
+func renderMarkdownRow(writer *bufio.Writer, row []string, widths []int,
+	right []bool) {
+	writer.WriteByte('|')
+	for column, cell := range row {
+		writer.WriteByte(' ')
+		writer.WriteString(pad(cell, widths[column],
+			right != nil && right[column]))
+		writer.WriteString(" |")
+	}
+	writer.WriteByte('\n')
+}
+
+func renderHTML(writer *bufio.Writer, rows [][]string, right []bool) {
+	writer.WriteString("<table>\n<thead>\n")
+	for i, row := range rows {
+		if i == 1 {
+			writer.WriteString("</thead>\n<tbody>\n")
+		}
+		writer.WriteString("<tr>")
+		for column, cell := range row {
+			cell = strings.ReplaceAll(strings.ReplaceAll(
+				html.EscapeString(cell), "\r\n", "\n"), "\n", "<br>")
+			switch {
+			case i == 0:
+				writer.WriteString("<th>" + cell + "</th>")
+			case right[column]:
+				writer.WriteString(`<td style="text-align: right">` +
+					cell + "</td>")
+			default:
+				writer.WriteString("<td>" + cell + "</td>")
+			}
+		}
+		writer.WriteString("</tr>\n")
+	}
+	if len(rows) == 1 {
+		writer.WriteString("</thead>\n<tbody>\n")
+	}
+	writer.WriteString("</tbody>\n</table>\n")
+}
+
+func renderGrid(writer *bufio.Writer, rows [][]string, right []bool,
+	width int) {
+	cells := make([][][]string, 0, len(rows)) // row, column, line
+	multiline := false
+	for _, row := range rows {
+		lines := make([][]string, 0, len(row))
+		for _, cell := range row {
+			wrapped := wrapWidth(cell, width)
+			if len(wrapped) > 1 {
+				multiline = true
+			}
+			lines = append(lines, wrapped)
+		}
+		cells = append(cells, lines)
+	}
+	widths := make([]int, len(right))
+	for _, row := range cells {
+		for column, lines := range row {
+			for _, line := range lines {
+				widths[column] = max(widths[column], displayWidth(line))
+			}
+		}
+	}
+	renderGridRule(writer, widths, "┌", "┬", "┐")
+	for i, row := range cells {
+		if i == 1 || (i > 1 && multiline) {
+			renderGridRule(writer, widths, "├", "┼", "┤")
+		}
+		height := 1
+		for _, lines := range row {
+			height = max(height, len(lines))
+		}
+		for j := 0; j < height; j++ {
+			writer.WriteString("│")
+			for column, lines := range row {
+				line := ""
+				if j < len(lines) {
+					line = lines[j]
+				}
+				writer.WriteByte(' ')
+				writer.WriteString(pad(line, widths[column],
+					i > 0 && right[column]))
+				writer.WriteString(" │")
+			}
+			writer.WriteByte('\n')
+		}
+	}
+	renderGridRule(writer, widths, "└", "┴", "┘")
+}
+
+func renderGridRule(writer *bufio.Writer, widths []int, left, middle,
+	right string) {
+	writer.WriteString(left)
+	for column, size := range widths {
+		if column > 0 {
+			writer.WriteString(middle)
+		}
+		writer.WriteString(strings.Repeat("─", size+2))
+	}
+	writer.WriteString(right + "\n")
+}
+
+// columnWidths returns the display width of each column (at least
+// minimum).
+func columnWidths(rows [][]string, minimum int) []int {
+	widths := make([]int, len(rows[0]))
+	for column := range widths {
+		widths[column] = minimum
+	}
+	for _, row := range rows {
+		for column, cell := range row {
+			widths[column] = max(widths[column], displayWidth(cell))
+		}
+	}
+	return widths
+}
+
+// pad returns s padded with spaces to the given display width.
+func pad(s string, width int, right bool) string {
+	padding := strings.Repeat(" ", max(0, width-displayWidth(s)))
+	if right {
+		return padding + s
+	}
+	return s + padding
+}
+
+// truncateWidth returns s truncated (with an ellipsis) to at most width
+// display columns, or s unchanged if width is 0.
+func truncateWidth(s string, width int) string {
+	if width <= 0 || displayWidth(s) <= width {
+		return s
+	}
+	var out strings.Builder
+	size := 0
+	for _, c := range s {
+		if size += runeWidth(c); size > width-1 {
+			break
+		}
+		out.WriteRune(c)
+	}
+	return out.String() + "…"
+}
+
+// wrapWidth returns s as lines of at most width display columns (or as is
+// if width is 0), keeping its line breaks and wrapping at spaces where
+// possible. Tabs are expanded to four spaces.
+func wrapWidth(s string, width int) []string {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\t",
+		"    ")
+	lines := make([]string, 0)
+	for _, text := range strings.Split(s, "\n") {
+		if width <= 0 {
+			lines = append(lines, text)
+			continue
+		}
+		line := ""
+		for _, word := range strings.Split(text, " ") {
+			switch {
+			case line == "":
+				line = word
+			case displayWidth(line)+1+displayWidth(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+			for displayWidth(line) > width { // break an over-long word
+				i, size := 0, 0
+				for j, c := range line {
+					if size += runeWidth(c); size > width {
+						i = j
+						break
+					}
+				}
+				if i == 0 { // the first character is wider than width
+					_, i = utf8.DecodeRuneInString(line)
+				}
+				lines = append(lines, line[:i])
+				line = line[i:]
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// displayWidth returns the number of terminal columns needed to display s.
+func displayWidth(s string) int {
+	size := 0
+	for _, c := range s {
+		size += runeWidth(c)
+	}
+	return size
+}
+
+// runeWidth returns the number of terminal columns needed to display c: 0
+// for control characters and combining marks, 2 for East Asian wide and
+// fullwidth characters (and most emoji), and 1 otherwise.
+func runeWidth(c rune) int {
+	switch {
+	case c < 0x20 || (c >= 0x7F && c < 0xA0) || c == 0x200B ||
+		unicode.In(c, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case c >= 0x1100 && (c <= 0x115F || // Hangul Jamo
+		(c >= 0x2E80 && c <= 0x303E) || // CJK radicals .. symbols
+		(c >= 0x3041 && c <= 0x33FF) || // Kana .. CJK compatibility
+		(c >= 0x3400 && c <= 0x4DBF) || // CJK extension A
+		(c >= 0x4E00 && c <= 0x9FFF) || // CJK unified ideographs
+		(c >= 0xA000 && c <= 0xA4CF) || // Yi
+		(c >= 0xAC00 && c <= 0xD7A3) || // Hangul syllables
+		(c >= 0xF900 && c <= 0xFAFF) || // CJK compatibility ideographs
+		(c >= 0xFE30 && c <= 0xFE4F) || // CJK compatibility forms
+		(c >= 0xFF00 && c <= 0xFF60) || // fullwidth forms
+		(c >= 0xFFE0 && c <= 0xFFE6) ||
+		(c >= 0x1F300 && c <= 0x1F64F) || // emoji
+		(c >= 0x1F900 && c <= 0x1F9FF) ||
+		(c >= 0x20000 && c <= 0x3FFFD)): // CJK extensions B ..
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,341 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// RenameTable renames the table called oldName to newName (keeping its
+// position in the [Tdb]'s TableNames).
+func (me *Tdb) RenameTable(oldName, newName string) error {
+	table, ok := me.Tables[oldName]
+	if !ok {
+		return fmt.Errorf("e%d#%s:no such table", e157, oldName)
+	}
+	if !isIdentifier(newName) {
+		return fmt.Errorf("e%d#%s:invalid tablename %q", e158, oldName,
+			newName)
+	}
+	if _, ok := me.Tables[newName]; ok {
+		return fmt.Errorf("e%d#%s:duplicate tablename %q", e159, oldName,
+			newName)
+	}
+	for i, name := range me.TableNames {
+		if name == oldName {
+			me.TableNames[i] = newName
+			break
+		}
+	}
+	delete(me.Tables, oldName)
+	table.Name = newName
+	me.Tables[newName] = table
+	return nil
+}
+
+// DropTable deletes the given table.
+func (me *Tdb) DropTable(tableName string) error {
+	if _, ok := me.Tables[tableName]; !ok {
+		return fmt.Errorf("e%d#%s:no such table", e157, tableName)
+	}
+	for i, name := range me.TableNames {
+		if name == tableName {
+			me.TableNames = append(me.TableNames[:i], me.TableNames[i+1:]...)
+			break
+		}
+	}
+	delete(me.Tables, tableName)
+	return nil
+}
+
+// AddColumn adds a new field with the given name and type (e.g., "int" or
+// "str?") after the existing fields, and sets its value in every existing
+// record to the given default (which may be nil only if the type is
+// nullable).
+func (me *Table) AddColumn(fieldName, typeName string, dflt any) error {
+	if !isIdentifier(fieldName) {
+		return fmt.Errorf("e%d#%s:invalid fieldname %q", e158, me.Name,
+			fieldName)
+	}
+	if me.FieldIndex(fieldName) != -1 {
+		return fmt.Errorf("e%d#%s:duplicate fieldname %q", e159, me.Name,
+			fieldName)
+	}
+	kind, allowNull, ok := parseTypeName(typeName)
+	if !ok {
+		return fmt.Errorf("e%d#%s.%s:invalid typename %q", e131, me.Name,
+			fieldName, typeName)
+	}
+	field := &MetaFieldType{fieldName, kind, allowNull}
+	if err := checkValue(field, dflt); err != nil {
+		return fmt.Errorf("e%d#%s.%s:%s", e156, me.Name, fieldName, err)
+	}
+	me.Fields = append(me.Fields, field)
+	for i, record := range me.Records {
+		me.Records[i] = append(record, dflt)
+	}
+	return nil
+}
+
+// DropColumn deletes the given field (and its value in every record). Any
+// indexes that include the field are dropped.
+func (me *Table) DropColumn(fieldName string) error {
+	column := me.FieldIndex(fieldName)
+	if column == -1 {
+		return fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			fieldName)
+	}
+	if len(me.Fields) == 1 {
+		return fmt.Errorf("e%d#%s:can't drop a table's only field", e160,
+			me.Name)
+	}
+	me.Fields = append(me.Fields[:column], me.Fields[column+1:]...)
+	for i, record := range me.Records {
+		me.Records[i] = append(record[:column], record[column+1:]...)
+	}
+	me.dropColumnFromIndexes(column)
+	return nil
+}
+
+// RenameColumn renames the field called oldName to newName.
+func (me *Table) RenameColumn(oldName, newName string) error {
+	column := me.FieldIndex(oldName)
+	if column == -1 {
+		return fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			oldName)
+	}
+	if !isIdentifier(newName) {
+		return fmt.Errorf("e%d#%s:invalid fieldname %q", e158, me.Name,
+			newName)
+	}
+	if me.FieldIndex(newName) != -1 {
+		return fmt.Errorf("e%d#%s:duplicate fieldname %q", e159, me.Name,
+			newName)
+	}
+	me.Fields[column].Name = newName
+	return nil
+}
+
+// ChangeColumnType changes the given field's type (e.g., to "real" or
+// "date?") and converts the field's value in every record accordingly. If
+// any value can't be converted an error is returned and the table is left
+// unchanged.
+//
+// Conversions to str always succeed (bytes must be valid UTF-8). Ints,
+// reals, and bools convert between each other (reals only to ints if they
+// have no fractional part). Dates and datetimes convert between each other
+// (datetimes are truncated to dates). Strs convert to any kind if they
+// hold a valid value for it (e.g., "2022-12-25" to a date).
+func (me *Table) ChangeColumnType(fieldName, typeName string) error {
+	column := me.FieldIndex(fieldName)
+	if column == -1 {
+		return fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			fieldName)
+	}
+	kind, allowNull, ok := parseTypeName(typeName)
+	if !ok {
+		return fmt.Errorf("e%d#%s.%s:invalid typename %q", e131, me.Name,
+			fieldName, typeName)
+	}
+	field := &MetaFieldType{fieldName, kind, allowNull}
+	oldKind := me.Fields[column].Kind
+	values := make([]any, len(me.Records))
+	for row, record := range me.Records {
+		value, err := convertValue(record[column], oldKind, kind)
+		if err == nil {
+			err = checkValue(field, value)
+		}
+		if err != nil {
+			return fmt.Errorf("e%d#%s.%s:row %d: %s", e161, me.Name,
+				fieldName, row, err)
+		}
+		values[row] = value
+	}
+	me.Fields[column] = field
+	for row, record := range me.Records {
+		record[column] = values[row]
+	}
+	for _, index := range me.indexesOn(column) {
+		index.build()
+	}
+	return nil
+}
+
+// SetNullable makes the given field nullable or not null. A field can only
+// be made not null if none of its values are null.
+func (me *Table) SetNullable(fieldName string, allowNull bool) error {
+	column := me.FieldIndex(fieldName)
+	if column == -1 {
+		return fmt.Errorf("e%d#%s:no field called %q", e152, me.Name,
+			fieldName)
+	}
+	if !allowNull {
+		for row, record := range me.Records {
+			if record[column] == nil {
+				return fmt.Errorf("e%d#%s.%s:row %d is null", e161,
+					me.Name, fieldName, row)
+			}
+		}
+	}
+	me.Fields[column].AllowNull = allowNull
+	return nil
+}
+
+// convertValue returns the given value (of the from kind) converted to the
+// given kind. Nil is returned unchanged. The error has no error code.
+func convertValue(value any, from, kind FieldKind) (any, error) {
+	if value == nil || valueIsKind(value, kind) {
+		if t, ok := value.(time.Time); ok && kind == DateField {
+			return truncateToDate(t), nil
+		}
+		return value, nil
+	}
+	if s, ok := value.(string); ok {
+		return parseValue(s, kind)
+	}
+	switch kind {
+	case StrField:
+		return formatValue(value, from)
+	case IntField:
+		switch v := value.(type) {
+		case bool:
+			if v {
+				return 1, nil
+			}
+			return 0, nil
+		case float64:
+			if i := int(v); float64(i) == v {
+				return i, nil
+			}
+		}
+	case RealField:
+		switch v := value.(type) {
+		case bool:
+			if v {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		case int:
+			return float64(v), nil
+		}
+	case BoolField:
+		switch v := value.(type) {
+		case int:
+			return v != 0, nil
+		case float64:
+			return v != 0, nil
+		}
+	}
+	return nil, fmt.Errorf("can't convert %v (%T) to %s", value, value,
+		kind)
+}
+
+// parseValue returns the given string converted to a value of the given
+// kind. Leading and trailing whitespace is ignored for all kinds except
+// str and bytes. The error has no error code.
+func parseValue(s string, kind FieldKind) (any, error) {
+	t := strings.TrimSpace(s)
+	if kind == DateField || kind == DateTimeField {
+		t = strings.Replace(t, " ", "T", 1) // e.g., 2022-12-25 12:30:00
+	}
+	switch kind {
+	case BoolField:
+		if b, ok := parseBool(t); ok {
+			return b, nil
+		}
+	case BytesField:
+		return []byte(s), nil
+	case DateField:
+		if d, err := time.Parse(DateFormat, t); err == nil {
+			return d, nil
+		}
+		if d, err := time.Parse(DateTimeFormat, t); err == nil {
+			return truncateToDate(d), nil
+		}
+	case DateTimeField:
+		if d, err := time.Parse(DateTimeFormat, t); err == nil {
+			return d, nil
+		}
+		if d, err := time.Parse(DateFormat, t); err == nil {
+			return d, nil
+		}
+	case IntField:
+		if i, err := strconv.Atoi(t); err == nil {
+			return i, nil
+		}
+	case RealField:
+		if r, ok := parseReal(t); ok {
+			return r, nil
+		}
+	case StrField:
+		return s, nil
+	}
+	return nil, fmt.Errorf("can't convert %q to %s", s, kind)
+}
+
+// parseReal returns s as a real if it is a finite decimal number. (Unlike
+// strconv.ParseFloat it rejects NaN, infinities, underscores, and hex,
+// none of which can be written in Tdb format.)
+func parseReal(s string) (float64, bool) {
+	if strings.ContainsAny(s, "_xX") {
+		return 0, false
+	}
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(r) || math.IsInf(r, 0) {
+		return 0, false
+	}
+	return r, true
+}
+
+// parseBool accepts the Tdb bool values (F f N n 0 T t Y y 1) and also
+// (case-insensitively) false, no, true, and yes.
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "f", "n", "0", "false", "no":
+		return false, true
+	case "t", "y", "1", "true", "yes":
+		return true, true
+	}
+	return false, false
+}
+
+// formatValue returns the given value (of the given kind) as a string
+// (using Tdb's formats for bools, dates, and datetimes).
+func formatValue(value any, kind FieldKind) (string, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "T", nil
+		}
+		return "F", nil
+	case []byte:
+		if !utf8.Valid(v) {
+			return "", fmt.Errorf("can't convert bytes %s to str",
+				hex.EncodeToString(v))
+		}
+		return string(v), nil
+	case time.Time:
+		if kind == DateField {
+			return v.Format(DateFormat), nil
+		}
+		return v.Format(DateTimeFormat), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("can't convert %v (%T) to str", value, value)
+}
+
+func truncateToDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,1181 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Query executes the given SQL SELECT statement against the given [Tdb]
+// and returns the result as a new [Table] (which could be added to a Tdb
+// and written using [Tdb.Write]). Any args are the values for the query's
+// ? placeholders (see [Exec]).
+//
+// The supported subset of SQL is:
+//
+//	SELECT [DISTINCT] * | expr [[AS] alias], ...
+//	FROM table [[AS] alias]
+//	[[INNER | LEFT [OUTER] | FULL [OUTER]] JOIN table [[AS] alias]
+//	    (USING (field, ...) | ON a.field = b.field [AND ...])] ...
+//	[WHERE expr]
+//	[GROUP BY expr, ...] [HAVING expr]
+//	[ORDER BY expr [ASC | DESC], ...]
+//	[LIMIT count [OFFSET count]]
+//
+// Expressions may use fields (optionally qualified, e.g., emp.sal),
+// literals (ints, reals, 'strings', TRUE, FALSE, NULL, DATE 'yyyy-mm-dd',
+// DATETIME 'yyyy-mm-ddThh:mm:ss'), arithmetic (+ - * / %), || (string
+// concatenation), comparisons (= <> != < <= > >=), AND, OR, NOT,
+// IS [NOT] NULL, [NOT] LIKE, [NOT] IN (...), [NOT] BETWEEN ... AND ...,
+// the aggregates COUNT(*), COUNT, SUM, AVG, MIN, MAX, and the functions
+// ABS, COALESCE, LENGTH, LOWER, UPPER. Dates and datetimes may be compared
+// with strings, e.g., hiredate > '1981-06-30'. ORDER BY may refer to
+// result fields by name or (1-based) position. In a grouped query (one with
+// a GROUP BY or an aggregate) the SELECT, HAVING, and ORDER BY expressions
+// may only use fields in the GROUP BY expressions or in aggregates. Int
+// literals and arithmetic that would overflow are errors.
+//
+// Nulls follow SQL semantics (e.g., comparisons with null are neither true
+// nor false, and aggregates ignore nulls).
+//
+// The result table is named after the FROM table (or the joined tables)
+// and its fields are named after the selected fields (or their aliases).
+func Query(db *Tdb, query string, args ...any) (*Table, error) {
+	stmt, err := sqlParse(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if stmt, ok := stmt.(*sqlSelect); ok {
+		return stmt.execute(db)
+	}
+	return nil, fmt.Errorf("e%d#Query only supports SELECT; use Exec", e169)
+}
+
+// sqlType is the static type of an expression.
+type sqlType struct {
+	kind     FieldKind
+	nullable bool
+	null     bool // the NULL literal (compatible with any kind)
+}
+
+// sqlScope holds the fields that expressions may refer to.
+type sqlScope struct {
+	columns   []*sqlScopeColumn
+	grouped   bool // true if there's a GROUP BY or an aggregate
+	ungrouped bool // true if there are aggregates but no GROUP BY
+}
+
+type sqlScopeColumn struct {
+	qualifier string         // the table's alias or name
+	name      string         // the fieldname in the qualifier's table
+	field     *MetaFieldType // the field in the (possibly joined) table
+	hidden    bool           // right-hand USING fields (excluded from * etc.)
+}
+
+// sqlRow is the context for evaluating an expression: a record, or for
+// grouped queries a group of records (with record as the group's first
+// record or nil for an empty group).
+type sqlRow struct {
+	record Record
+	group  []Record
+}
+
+func (me *sqlSelect) execute(db *Tdb) (*Table, error) {
+	table, scope, err := me.executeFrom(db)
+	if err != nil {
+		return nil, err
+	}
+	if me.where != nil {
+		if table, err = me.executeWhere(table, scope); err != nil {
+			return nil, err
+		}
+	}
+	scope.grouped = len(me.groupBy) > 0 || me.hasAggregates()
+	scope.ungrouped = scope.grouped && len(me.groupBy) == 0
+	for _, expr := range me.groupBy {
+		if _, err := scope.check(expr, false); err != nil {
+			return nil, err
+		}
+	}
+	items, err := me.selectItems(scope)
+	if err != nil {
+		return nil, err
+	}
+	result, err := me.makeResultTable(table, scope, items)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := me.makeRows(table, scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make(Record, 0, len(items))
+		for i, item := range items {
+			value, err := sqlEval(item.expr, row)
+			if err != nil {
+				return nil, err
+			}
+			record = append(record, coerceToKind(value,
+				result.Fields[i].Kind))
+		}
+		result.Records = append(result.Records, record)
+	}
+	if len(me.orderBy) > 0 {
+		if err = me.executeOrderBy(result, items, scope, rows); err != nil {
+			return nil, err
+		}
+	}
+	if me.distinct {
+		result = result.Distinct()
+	}
+	if me.offset > 0 {
+		result.Records = result.Records[min(me.offset, len(result.Records)):]
+	}
+	if me.limit > -1 {
+		result = result.Limit(me.limit)
+	}
+	return result, nil
+}
+
+func (me *sqlSelect) executeFrom(db *Tdb) (*Table, *sqlScope, error) {
+	table, scope, err := sqlTableAndScope(db, me.from)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, join := range me.joins {
+		right, rightScope, err := sqlTableAndScope(db, join.table)
+		if err != nil {
+			return nil, nil, err
+		}
+		var leftNames, rightNames []string
+		if join.using != nil {
+			for _, name := range join.using {
+				left, err := scope.resolve("", name)
+				if err != nil {
+					return nil, nil, err
+				}
+				right, err := rightScope.resolve("", name)
+				if err != nil {
+					return nil, nil, err
+				}
+				leftNames = append(leftNames, table.Fields[left].Name)
+				rightNames = append(rightNames, rightScope.columns[right].
+					field.Name)
+				rightScope.columns[right].hidden = true
+			}
+		} else if leftNames, rightNames, err = sqlJoinOn(join.on, table,
+			scope, rightScope); err != nil {
+			return nil, nil, err
+		}
+		if table, err = table.JoinOn(right, join.kind, leftNames,
+			rightNames); err != nil {
+			return nil, nil, err
+		}
+		scope.columns = append(scope.columns, rightScope.columns...)
+		for i, column := range scope.columns { // nullability may change
+			column.field = table.Fields[i]
+		}
+	}
+	return table, scope, nil
+}
+
+func sqlTableAndScope(db *Tdb, ref sqlTableRef) (*Table, *sqlScope, error) {
+	table, ok := db.Tables[ref.name]
+	if !ok {
+		for name, t := range db.Tables {
+			if strings.EqualFold(name, ref.name) {
+				table, ok = t, true
+				break
+			}
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("e%d#no table called %q", e157,
+				ref.name)
+		}
+	}
+	qualifier := ref.alias
+	if qualifier == "" {
+		qualifier = ref.name
+	}
+	scope := &sqlScope{columns: make([]*sqlScopeColumn, 0,
+		len(table.Fields))}
+	for _, field := range table.Fields {
+		scope.columns = append(scope.columns, &sqlScopeColumn{qualifier,
+			field.Name, field, false})
+	}
+	return table, scope, nil
+}
+
+// sqlJoinOn returns the left and right fieldnames from an ON expression,
+// which must consist of one or more equalities (joined by AND) between a
+// left field and a right field.
+func sqlJoinOn(on sqlExpr, left *Table, leftScope,
+	rightScope *sqlScope) ([]string, []string, error) {
+	var leftNames, rightNames []string
+	var walk func(expr sqlExpr) error
+	walk = func(expr sqlExpr) error {
+		binary, ok := expr.(*sqlBinary)
+		if ok && binary.op == "AND" {
+			if err := walk(binary.x); err != nil {
+				return err
+			}
+			return walk(binary.y)
+		}
+		if ok && binary.op == "=" {
+			x, xok := binary.x.(*sqlColumn)
+			y, yok := binary.y.(*sqlColumn)
+			if xok && yok {
+				for _, pair := range [][2]*sqlColumn{{x, y}, {y, x}} {
+					l, lerr := leftScope.resolve(pair[0].table, pair[0].name)
+					r, rerr := rightScope.resolve(pair[1].table,
+						pair[1].name)
+					if lerr == nil && rerr == nil {
+						leftNames = append(leftNames, left.Fields[l].Name)
+						rightNames = append(rightNames,
+							rightScope.columns[r].field.Name)
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("e%d#JOIN ON only supports equalities between "+
+			"the joined tables' fields", e168)
+	}
+	err := walk(on)
+	return leftNames, rightNames, err
+}
+
+func (me *sqlSelect) executeWhere(table *Table, scope *sqlScope) (*Table,
+	error) {
+	if err := scope.checkBool(me.where, "WHERE"); err != nil {
+		return nil, err
+	}
+	var err error
+	result := table.Where(func(record Record) bool {
+		if err != nil {
+			return false
+		}
+		var value any
+		value, err = sqlEval(me.where, sqlRow{record: record})
+		return value == true
+	})
+	return result, err
+}
+
+func (me *sqlSelect) hasAggregates() bool {
+	for _, item := range me.items {
+		if sqlHasAggregate(item.expr) {
+			return true
+		}
+	}
+	return me.having != nil
+}
+
+// selectItems returns the SELECT items, with * expanded.
+func (me *sqlSelect) selectItems(scope *sqlScope) ([]sqlItem, error) {
+	if !me.star {
+		return me.items, nil
+	}
+	if scope.grouped {
+		return nil, fmt.Errorf("e%d#can't SELECT * with GROUP BY", e169)
+	}
+	items := make([]sqlItem, 0, len(scope.columns))
+	for _, column := range scope.columns {
+		if !column.hidden {
+			items = append(items, sqlItem{expr: &sqlColumn{
+				table: column.qualifier, name: column.name}})
+		}
+	}
+	return items, nil
+}
+
+func (me *sqlSelect) makeResultTable(table *Table, scope *sqlScope,
+	items []sqlItem) (*Table, error) {
+	name := table.Name
+	if len(me.joins) == 0 {
+		name = me.from.name
+	}
+	result := &Table{MetaTableType{name, make([]*MetaFieldType, 0,
+		len(items))}, make([]Record, 0), nil}
+	counts := make(map[string]int) // count of each unqualified name
+	for _, item := range items {
+		if column, ok := item.expr.(*sqlColumn); ok && item.alias == "" {
+			counts[column.name]++
+		}
+	}
+	used := make(map[string]bool)
+	for _, item := range items {
+		typ, err := scope.check(item.expr, true)
+		if err != nil {
+			return nil, err
+		}
+		if scope.grouped {
+			if err = me.checkGrouped(item.expr, "SELECT"); err != nil {
+				return nil, err
+			}
+		}
+		if typ.null {
+			typ.kind = StrField
+		}
+		name := item.alias
+		if name == "" {
+			name = sqlExprName(item.expr, counts, scope)
+		}
+		result.Fields = append(result.Fields, &MetaFieldType{
+			makeIdentifier(name, used), typ.kind, typ.nullable})
+	}
+	return result, nil
+}
+
+// sqlExprName returns a suitable fieldname for an unaliased SELECT item.
+func sqlExprName(expr sqlExpr, counts map[string]int,
+	scope *sqlScope) string {
+	switch x := expr.(type) {
+	case *sqlColumn:
+		name := scope.columns[x.index].name
+		if counts[x.name] > 1 {
+			return scope.columns[x.index].qualifier + "_" + name
+		}
+		return name
+	case *sqlCall:
+		name := strings.ToLower(x.name)
+		if len(x.args) == 1 {
+			if column, ok := x.args[0].(*sqlColumn); ok {
+				name += "_" + column.name
+			}
+		}
+		return name
+	}
+	return "expr"
+}
+
+// makeRows returns the rows (records or groups) that produce the result
+// records.
+func (me *sqlSelect) makeRows(table *Table, scope *sqlScope) ([]sqlRow,
+	error) {
+	rows := make([]sqlRow, 0, len(table.Records))
+	if !scope.grouped {
+		for _, record := range table.Records {
+			rows = append(rows, sqlRow{record: record})
+		}
+		return rows, nil
+	}
+	if len(me.groupBy) == 0 {
+		rows = append(rows, sqlRow{group: table.Records})
+	} else {
+		groups := make(map[string]int) // value is index into rows
+		for _, record := range table.Records {
+			var key strings.Builder
+			for _, expr := range me.groupBy {
+				value, err := sqlEval(expr, sqlRow{record: record})
+				if err != nil {
+					return nil, err
+				}
+				writeValueKey(&key, value)
+			}
+			index, ok := groups[key.String()]
+			if !ok {
+				index = len(rows)
+				groups[key.String()] = index
+				rows = append(rows, sqlRow{})
+			}
+			rows[index].group = append(rows[index].group, record)
+		}
+	}
+	for i := range rows {
+		if len(rows[i].group) > 0 {
+			rows[i].record = rows[i].group[0]
+		}
+	}
+	if me.having != nil {
+		if err := scope.checkBool(me.having, "HAVING"); err != nil {
+			return nil, err
+		}
+		if err := me.checkGrouped(me.having, "use"); err != nil {
+			return nil, err
+		}
+		having := rows[:0]
+		for _, row := range rows {
+			value, err := sqlEval(me.having, row)
+			if err != nil {
+				return nil, err
+			}
+			if value == true {
+				having = append(having, row)
+			}
+		}
+		rows = having
+	}
+	return rows, nil
+}
+
+// executeOrderBy sorts the result's records (which correspond to the
+// rows).
+func (me *sqlSelect) executeOrderBy(result *Table, items []sqlItem,
+	scope *sqlScope, rows []sqlRow) error {
+	keys := make([][]any, len(rows))
+	for i := range keys {
+		keys[i] = make([]any, 0, len(me.orderBy))
+	}
+	for _, order := range me.orderBy {
+		column, err := me.resultColumn(order.expr, result, items)
+		if err != nil {
+			return err
+		}
+		if column == -1 {
+			if _, err := scope.check(order.expr, true); err != nil {
+				return err
+			}
+			if scope.grouped {
+				if err := me.checkGrouped(order.expr,
+					"ORDER BY"); err != nil {
+					return err
+				}
+			}
+		}
+		for i, row := range rows {
+			var value any
+			if column > -1 {
+				value = result.Records[i][column]
+			} else if value, err = sqlEval(order.expr, row); err != nil {
+				return err
+			}
+			keys[i] = append(keys[i], value)
+		}
+	}
+	indexes := make([]int, len(rows))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		for k, order := range me.orderBy {
+			if c := sqlCompareForSort(keys[a][k], keys[b][k]); c != 0 {
+				if order.desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+	records := make([]Record, 0, len(indexes))
+	for _, index := range indexes {
+		records = append(records, result.Records[index])
+	}
+	result.Records = records
+	return nil
+}
+
+// checkGrouped returns an error if the (checked) expression in a grouped
+// query uses a field that is neither in the GROUP BY nor in an aggregate.
+func (me *sqlSelect) checkGrouped(expr sqlExpr, what string) error {
+	if x := me.ungroupedColumn(expr); x != nil {
+		return fmt.Errorf("e%d#can't %s %q: it isn't in the GROUP BY or "+
+			"an aggregate", e169, what, x.name)
+	}
+	return nil
+}
+
+// ungroupedColumn returns the first field in the (checked) expression that
+// is neither in the GROUP BY nor in an aggregate, or nil if there isn't
+// one. (Such a field's value would be taken from an arbitrary record in
+// each group.)
+func (me *sqlSelect) ungroupedColumn(expr sqlExpr) *sqlColumn {
+	for _, grouped := range me.groupBy {
+		if reflect.DeepEqual(expr, grouped) {
+			return nil
+		}
+	}
+	switch x := expr.(type) {
+	case *sqlColumn:
+		return x
+	case *sqlUnary:
+		return me.ungroupedColumn(x.x)
+	case *sqlIsNull:
+		return me.ungroupedColumn(x.x)
+	case *sqlBinary:
+		return me.firstUngroupedColumn([]sqlExpr{x.x, x.y})
+	case *sqlIn:
+		return me.firstUngroupedColumn(append([]sqlExpr{x.x}, x.list...))
+	case *sqlBetween:
+		return me.firstUngroupedColumn([]sqlExpr{x.x, x.low, x.high})
+	case *sqlCall:
+		if _, ok := sqlAggregateFuncs[x.name]; !ok {
+			return me.firstUngroupedColumn(x.args)
+		}
+	}
+	return nil
+}
+
+func (me *sqlSelect) firstUngroupedColumn(exprs []sqlExpr) *sqlColumn {
+	for _, expr := range exprs {
+		if x := me.ungroupedColumn(expr); x != nil {
+			return x
+		}
+	}
+	return nil
+}
+
+// resultColumn returns the index of the result field an ORDER BY
+// expression refers to (by position, alias, or name), or -1 if it doesn't
+// refer to one.
+func (me *sqlSelect) resultColumn(expr sqlExpr, result *Table,
+	items []sqlItem) (int, error) {
+	switch x := expr.(type) {
+	case *sqlLiteral:
+		if n, ok := x.value.(int); ok {
+			if n < 1 || n > len(result.Fields) {
+				return -1, fmt.Errorf("e%d#ORDER BY position %d out of "+
+					"range (1-%d)", e169, n, len(result.Fields))
+			}
+			return n - 1, nil
+		}
+	case *sqlColumn:
+		if x.table == "" {
+			for i, item := range items {
+				if item.alias == x.name {
+					return i, nil
+				}
+			}
+			if i := result.FieldIndex(x.name); i > -1 {
+				return i, nil
+			}
+		}
+	}
+	return -1, nil
+}
+
+// resolve returns the index of the given (possibly qualified) field.
+func (me *sqlScope) resolve(qualifier, name string) (int, error) {
+	for _, fold := range []bool{false, true} {
+		found := -1
+		for i, column := range me.columns {
+			if (qualifier == "" && column.hidden) || !sqlNameMatches(
+				column.name, name, fold) || (qualifier != "" &&
+				!sqlNameMatches(column.qualifier, qualifier, fold)) {
+				continue
+			}
+			if found > -1 {
+				return -1, fmt.Errorf("e%d#ambiguous field %q", e168,
+					name)
+			}
+			found = i
+		}
+		if found > -1 {
+			return found, nil
+		}
+	}
+	if qualifier != "" {
+		name = qualifier + "." + name
+	}
+	return -1, fmt.Errorf("e%d#no field called %q", e152, name)
+}
+
+func sqlNameMatches(a, b string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func (me *sqlScope) checkBool(expr sqlExpr, what string) error {
+	typ, err := me.check(expr, what == "HAVING")
+	if err != nil {
+		return err
+	}
+	if !typ.null && typ.kind != BoolField {
+		return fmt.Errorf("e%d#%s needs a bool expression, got %s", e169,
+			what, typ.kind)
+	}
+	return nil
+}
+
+// check resolves the expression's fields and returns its type. Aggregates
+// are only allowed if aggregates is true.
+func (me *sqlScope) check(expr sqlExpr, aggregates bool) (sqlType, error) {
+	bool_ := sqlType{kind: BoolField}
+	switch x := expr.(type) {
+	case *sqlLiteral:
+		return sqlType{x.kind, x.value == nil, x.value == nil}, nil
+	case *sqlColumn:
+		index, err := me.resolve(x.table, x.name)
+		if err != nil {
+			return sqlType{}, err
+		}
+		x.index = index
+		field := me.columns[index].field
+		// a field in an empty ungrouped group is null
+		return sqlType{kind: field.Kind,
+			nullable: field.AllowNull || me.ungrouped}, nil
+	case *sqlUnary:
+		typ, err := me.check(x.x, aggregates)
+		if err != nil {
+			return typ, err
+		}
+		if x.op == "NOT" {
+			return typ, sqlCheckKinds(typ, "NOT", BoolField)
+		}
+		return typ, sqlCheckKinds(typ, "-", IntField|RealField)
+	case *sqlBinary:
+		return me.checkBinary(x, aggregates)
+	case *sqlIsNull:
+		_, err := me.check(x.x, aggregates)
+		return bool_, err
+	case *sqlIn:
+		typ, err := me.check(x.x, aggregates)
+		if err != nil {
+			return typ, err
+		}
+		bool_.nullable = typ.nullable
+		for _, item := range x.list {
+			itemType, err := me.check(item, aggregates)
+			if err != nil {
+				return itemType, err
+			}
+			if err = sqlCheckComparable(typ, itemType, "IN"); err != nil {
+				return itemType, err
+			}
+			bool_.nullable = bool_.nullable || itemType.nullable
+		}
+		return bool_, nil
+	case *sqlBetween:
+		typ, err := me.check(x.x, aggregates)
+		if err != nil {
+			return typ, err
+		}
+		bool_.nullable = typ.nullable
+		for _, bound := range []sqlExpr{x.low, x.high} {
+			boundType, err := me.check(bound, aggregates)
+			if err != nil {
+				return boundType, err
+			}
+			if err = sqlCheckComparable(typ, boundType,
+				"BETWEEN"); err != nil {
+				return boundType, err
+			}
+			bool_.nullable = bool_.nullable || boundType.nullable
+		}
+		return bool_, nil
+	case *sqlCall:
+		return me.checkCall(x, aggregates)
+	}
+	return sqlType{}, fmt.Errorf("e%d#unsupported expression", e169)
+}
+
+func (me *sqlScope) checkBinary(x *sqlBinary, aggregates bool) (sqlType,
+	error) {
+	left, err := me.check(x.x, aggregates)
+	if err != nil {
+		return left, err
+	}
+	right, err := me.check(x.y, aggregates)
+	if err != nil {
+		return right, err
+	}
+	typ := sqlType{kind: BoolField,
+		nullable: left.nullable || right.nullable}
+	switch x.op {
+	case "AND", "OR":
+		if err = sqlCheckKinds(left, x.op, BoolField); err == nil {
+			err = sqlCheckKinds(right, x.op, BoolField)
+		}
+	case "+", "-", "*", "/", "%":
+		kinds := IntField | RealField
+		if x.op == "%" {
+			kinds = IntField
+		}
+		if err = sqlCheckKinds(left, x.op, kinds); err == nil {
+			err = sqlCheckKinds(right, x.op, kinds)
+		}
+		typ.kind = IntField
+		if (!left.null && left.kind == RealField) ||
+			(!right.null && right.kind == RealField) {
+			typ.kind = RealField
+		}
+		typ.null = left.null && right.null
+		typ.nullable = typ.nullable || x.op == "/" || x.op == "%"
+	case "||":
+		typ.kind = StrField
+		typ.null = left.null && right.null
+		x.kinds = [2]FieldKind{left.kind, right.kind}
+	case "LIKE":
+		if err = sqlCheckKinds(left, x.op, StrField); err == nil {
+			err = sqlCheckKinds(right, x.op, StrField)
+		}
+	default: // comparisons
+		err = sqlCheckComparable(left, right, x.op)
+	}
+	return typ, err
+}
+
+func (me *sqlScope) checkCall(x *sqlCall, aggregates bool) (sqlType,
+	error) {
+	switch x.name {
+	case "COUNT", "SUM", "AVG", "MIN", "MAX":
+		if !aggregates {
+			return sqlType{}, fmt.Errorf("e%d#%s not allowed here", e169,
+				x.name)
+		}
+		if x.star {
+			return sqlType{kind: IntField}, nil
+		}
+		if len(x.args) != 1 {
+			return sqlType{}, fmt.Errorf("e%d#%s expects one argument",
+				e169, x.name)
+		}
+		typ, err := me.check(x.args[0], false)
+		if err != nil {
+			return typ, err
+		}
+		field := &MetaFieldType{Kind: typ.kind, AllowNull: typ.nullable}
+		if typ.null {
+			field.Kind = IntField
+		}
+		aggregate := Aggregate{Func: sqlAggregateFuncs[x.name]}
+		result, err := aggregate.resultField(field, me.ungrouped)
+		if err != nil {
+			return sqlType{}, fmt.Errorf("e%d#%s", e162, err)
+		}
+		return sqlType{kind: result.Kind, nullable: result.AllowNull}, nil
+	}
+	types := make([]sqlType, 0, len(x.args))
+	for _, arg := range x.args {
+		typ, err := me.check(arg, aggregates)
+		if err != nil {
+			return typ, err
+		}
+		types = append(types, typ)
+	}
+	if x.name == "COALESCE" {
+		return sqlCheckCoalesce(types)
+	}
+	if len(types) != 1 {
+		return sqlType{}, fmt.Errorf("e%d#%s expects one argument", e169,
+			x.name)
+	}
+	typ := types[0]
+	switch x.name {
+	case "ABS":
+		return typ, sqlCheckKinds(typ, x.name, IntField|RealField)
+	case "LENGTH":
+		return sqlType{kind: IntField, nullable: typ.nullable},
+			sqlCheckKinds(typ, x.name, StrField|BytesField)
+	case "LOWER", "UPPER":
+		return typ, sqlCheckKinds(typ, x.name, StrField)
+	}
+	return sqlType{}, fmt.Errorf("e%d#unknown function %s", e169, x.name)
+}
+
+var sqlAggregateFuncs = map[string]AggregateFunc{"COUNT": CountFunc,
+	"SUM": SumFunc, "AVG": AvgFunc, "MIN": MinFunc, "MAX": MaxFunc}
+
+func sqlCheckCoalesce(types []sqlType) (sqlType, error) {
+	if len(types) == 0 {
+		return sqlType{}, fmt.Errorf("e%d#COALESCE expects arguments", e169)
+	}
+	result := sqlType{null: true, nullable: true}
+	for _, typ := range types {
+		if typ.null {
+			continue
+		}
+		if result.null {
+			result = sqlType{kind: typ.kind, nullable: typ.nullable}
+			continue
+		}
+		numbers := IntField | RealField
+		if typ.kind != result.kind && (typ.kind&numbers == 0 ||
+			result.kind&numbers == 0) { // only ints may widen to reals
+			return result, fmt.Errorf("e%d#can't use COALESCE with a %s "+
+				"and a %s", e169, result.kind, typ.kind)
+		}
+		if typ.kind == RealField {
+			result.kind = RealField
+		}
+		result.nullable = result.nullable && typ.nullable
+	}
+	return result, nil
+}
+
+func sqlCheckKinds(typ sqlType, what string, kinds FieldKind) error {
+	if !typ.null && typ.kind&kinds == 0 {
+		return fmt.Errorf("e%d#%s can't be used with a %s", e169, what,
+			typ.kind)
+	}
+	return nil
+}
+
+func sqlCheckComparable(left, right sqlType, what string) error {
+	if left.null || right.null || left.kind == right.kind {
+		return nil
+	}
+	for _, kinds := range []FieldKind{IntField | RealField,
+		DateField | DateTimeField | StrField} {
+		if left.kind&kinds != 0 && right.kind&kinds != 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("e%d#can't use %s with a %s and a %s", e169, what,
+		left.kind, right.kind)
+}
+
+func sqlHasAggregate(expr sqlExpr) bool {
+	switch x := expr.(type) {
+	case *sqlUnary:
+		return sqlHasAggregate(x.x)
+	case *sqlBinary:
+		return sqlHasAggregate(x.x) || sqlHasAggregate(x.y)
+	case *sqlIsNull:
+		return sqlHasAggregate(x.x)
+	case *sqlIn:
+		return sqlHasAggregate(x.x) || slices.ContainsFunc(x.list,
+			sqlHasAggregate)
+	case *sqlBetween:
+		return sqlHasAggregate(x.x) || sqlHasAggregate(x.low) ||
+			sqlHasAggregate(x.high)
+	case *sqlCall:
+		if _, ok := sqlAggregateFuncs[x.name]; ok {
+			return true
+		}
+		return slices.ContainsFunc(x.args, sqlHasAggregate)
+	}
+	return false
+}
+
+// sqlEval returns the expression's value for the given row (the
+// expression must have been checked).
+func sqlEval(expr sqlExpr, row sqlRow) (any, error) {
+	switch x := expr.(type) {
+	case *sqlLiteral:
+		return x.value, nil
+	case *sqlColumn:
+		if row.record == nil {
+			return nil, nil
+		}
+		return row.record[x.index], nil
+	case *sqlUnary:
+		value, err := sqlEval(x.x, row)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		if x.op == "NOT" {
+			return !value.(bool), nil
+		}
+		if i, ok := value.(int); ok {
+			if i == math.MinInt {
+				return nil, sqlOverflowError("-", i)
+			}
+			return -i, nil
+		}
+		return -value.(float64), nil
+	case *sqlBinary:
+		return sqlEvalBinary(x, row)
+	case *sqlIsNull:
+		value, err := sqlEval(x.x, row)
+		return (value == nil) != x.not, err
+	case *sqlIn:
+		return sqlEvalIn(x, row)
+	case *sqlBetween:
+		return sqlEvalBetween(x, row)
+	case *sqlCall:
+		return sqlEvalCall(x, row)
+	}
+	return nil, fmt.Errorf("e%d#unsupported expression", e169)
+}
+
+func sqlEvalBinary(x *sqlBinary, row sqlRow) (any, error) {
+	left, err := sqlEval(x.x, row)
+	if err != nil {
+		return nil, err
+	}
+	if x.op == "AND" || x.op == "OR" { // three-valued logic
+		if left == (x.op == "OR") { // false AND ... or true OR ...
+			return left, nil
+		}
+		right, err := sqlEval(x.y, row)
+		if err != nil || right == (x.op == "OR") {
+			return right, err
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return right, nil
+	}
+	right, err := sqlEval(x.y, row)
+	if err != nil || left == nil || right == nil {
+		return nil, err
+	}
+	switch x.op {
+	case "+", "-", "*", "/", "%":
+		return sqlArithmetic(x.op, left, right)
+	case "||":
+		l, _ := formatValue(left, x.kinds[0])
+		r, _ := formatValue(right, x.kinds[1])
+		return l + r, nil
+	case "LIKE":
+		return sqlLike(left.(string), right.(string)), nil
+	}
+	c := sqlCompare(left, right)
+	switch x.op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil // ">="
+}
+
+// sqlArithmetic returns the result of the operation, or an error if the
+// operands are ints and the result would overflow.
+func sqlArithmetic(op string, left, right any) (any, error) {
+	l, lok := left.(int)
+	r, rok := right.(int)
+	if lok && rok {
+		switch op {
+		case "+":
+			if (r > 0 && l > math.MaxInt-r) || (r < 0 && l < math.MinInt-r) {
+				return nil, sqlOverflowError(op, l, r)
+			}
+			return l + r, nil
+		case "-":
+			if (r < 0 && l > math.MaxInt+r) || (r > 0 && l < math.MinInt+r) {
+				return nil, sqlOverflowError(op, l, r)
+			}
+			return l - r, nil
+		case "*":
+			if l != 0 && r != 0 && ((l == -1 && r == math.MinInt) ||
+				(r == -1 && l == math.MinInt) || (l*r)/r != l) {
+				return nil, sqlOverflowError(op, l, r)
+			}
+			return l * r, nil
+		}
+		if r == 0 {
+			return nil, nil // division by zero is null
+		}
+		if op == "/" {
+			if l == math.MinInt && r == -1 {
+				return nil, sqlOverflowError(op, l, r)
+			}
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	x, y := sqlReal(left), sqlReal(right)
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+	if y == 0 {
+		return nil, nil
+	}
+	if op == "/" {
+		return x / y, nil
+	}
+	return math.Mod(x, y), nil
+}
+
+func sqlOverflowError(op string, operands ...int) error {
+	texts := make([]string, 0, len(operands))
+	for _, operand := range operands {
+		texts = append(texts, strconv.Itoa(operand))
+	}
+	if len(texts) == 1 {
+		return fmt.Errorf("e%d#int overflow: %s%s", e169, op, texts[0])
+	}
+	return fmt.Errorf("e%d#int overflow: %s %s %s", e169, texts[0], op,
+		texts[1])
+}
+
+func sqlReal(value any) float64 {
+	if i, ok := value.(int); ok {
+		return float64(i)
+	}
+	return value.(float64)
+}
+
+func sqlEvalIn(x *sqlIn, row sqlRow) (any, error) {
+	value, err := sqlEval(x.x, row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var result any = false
+	for _, item := range x.list {
+		itemValue, err := sqlEval(item, row)
+		if err != nil {
+			return nil, err
+		}
+		if itemValue == nil {
+			result = nil // x IN (..., NULL) is null if not found
+		} else if sqlCompare(value, itemValue) == 0 {
+			return !x.not, nil
+		}
+	}
+	if result == nil {
+		return nil, nil
+	}
+	return x.not, nil
+}
+
+func sqlEvalBetween(x *sqlBetween, row sqlRow) (any, error) {
+	values := make([]any, 0, 3)
+	for _, expr := range []sqlExpr{x.x, x.low, x.high} {
+		value, err := sqlEval(expr, row)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	between := sqlCompare(values[0], values[1]) >= 0 &&
+		sqlCompare(values[0], values[2]) <= 0
+	return between != x.not, nil
+}
+
+func sqlEvalCall(x *sqlCall, row sqlRow) (any, error) {
+	if fn, ok := sqlAggregateFuncs[x.name]; ok {
+		if x.star {
+			return len(row.group), nil
+		}
+		values := make([]any, 0, len(row.group))
+		for _, record := range row.group {
+			value, err := sqlEval(x.args[0], sqlRow{record: record})
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return aggregateValues(fn, values), nil
+	}
+	values := make([]any, 0, len(x.args))
+	for _, arg := range x.args {
+		value, err := sqlEval(arg, row)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if x.name == "COALESCE" {
+		for _, value := range values {
+			if value != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}
+	value := values[0]
+	if value == nil {
+		return nil, nil
+	}
+	switch x.name {
+	case "ABS":
+		if i, ok := value.(int); ok {
+			if i == math.MinInt {
+				return nil, sqlOverflowError("ABS ", i)
+			}
+			if i < 0 {
+				return -i, nil
+			}
+			return i, nil
+		}
+		return math.Abs(value.(float64)), nil
+	case "LENGTH":
+		if s, ok := value.(string); ok {
+			return utf8.RuneCountInString(s), nil
+		}
+		return len(value.([]byte)), nil
+	case "LOWER":
+		return strings.ToLower(value.(string)), nil
+	}
+	return strings.ToUpper(value.(string)), nil // UPPER
+}
+
+// sqlCompare is like compareValues except that strings are converted to
+// time.Times when compared with them.
+func sqlCompare(a, b any) int {
+	if t, ok := a.(time.Time); ok {
+		if s, ok := b.(string); ok {
+			if d, err := parseValue(s, DateTimeField); err == nil {
+				return t.Compare(d.(time.Time))
+			}
+		}
+	} else if s, ok := a.(string); ok {
+		if _, ok := b.(time.Time); ok {
+			return -sqlCompare(b, s)
+		}
+	}
+	return compareValues(a, b)
+}
+
+// sqlCompareForSort is sqlCompare with nulls first.
+func sqlCompareForSort(a, b any) int {
+	if a == nil || b == nil {
+		return compareValues(a, b)
+	}
+	return sqlCompare(a, b)
+}
+
+// sqlLike returns true if s matches the pattern, where % matches any
+// sequence of characters and _ matches any one character. The match is
+// case-insensitive.
+func sqlLike(s, pattern string) bool {
+	text := []rune(strings.ToLower(s))
+	pat := []rune(strings.ToLower(pattern))
+	var match func(t, p int) bool
+	match = func(t, p int) bool {
+		for p < len(pat) {
+			switch pat[p] {
+			case '%':
+				for p < len(pat) && pat[p] == '%' {
+					p++
+				}
+				if p == len(pat) {
+					return true
+				}
+				for ; t <= len(text); t++ {
+					if match(t, p) {
+						return true
+					}
+				}
+				return false
+			case '_':
+				if t == len(text) {
+					return false
+				}
+			default:
+				if t == len(text) || text[t] != pat[p] {
+					return false
+				}
+			}
+			t++
+			p++
+		}
+		return t == len(text)
+	}
+	return match(0, 0)
+}
+
+// coerceToKind returns the value converted to the given kind if it is an
+// int and the kind is real; otherwise returns the value unchanged.
+func coerceToKind(value any, kind FieldKind) any {
+	if i, ok := value.(int); ok && kind == RealField {
+		return float64(i)
+	}
+	return value
+}
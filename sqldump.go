@@ -0,0 +1,202 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDialect is the dialect of SQL written by [Tdb.WriteSQL].
+type SQLDialect uint8
+
+const (
+	SQLite SQLDialect = iota
+	Postgres
+)
+
+// SQLOptions are the options for [Tdb.WriteSQL]. The zero value writes
+// SQLite SQL with up to 100 records per INSERT.
+type SQLOptions struct {
+	Dialect   SQLDialect
+	BatchSize int // The maximum records per INSERT (default 100)
+}
+
+// WriteSQL writes the Tdb to out as an SQL script (in a transaction) that
+// creates each table and inserts its records.
+//
+// Each table has a CREATE TABLE statement with a column for each field
+// (NOT NULL unless the field allows nulls) followed by INSERT statements
+// for its records, with up to opts.BatchSize records per INSERT. Table and
+// column names are always double-quoted since Tdb identifiers may be SQL
+// keywords.
+//
+// For SQLite, bool, bytes, date, datetime, int, real, and str fields
+// become BOOLEAN (1 or 0), BLOB, DATE, DATETIME, INTEGER, REAL, and TEXT
+// columns. For Postgres they become BOOLEAN, BYTEA, DATE, TIMESTAMP,
+// BIGINT, DOUBLE PRECISION, and TEXT columns. Dates and datetimes are
+// written as ISO 8601 strings. SQLite can't store NaN reals.
+func (me *Tdb) WriteSQL(out io.Writer, opts SQLOptions) error {
+	writer := bufio.NewWriter(out)
+	writer.WriteString("BEGIN;\n")
+	for _, tableName := range me.TableNames {
+		if err := me.Tables[tableName].writeSQL(writer, opts); err != nil {
+			return err
+		}
+	}
+	writer.WriteString("COMMIT;\n")
+	return writer.Flush()
+}
+
+// WriteSQL writes the table to out as SQL CREATE TABLE and INSERT
+// statements (see [Tdb.WriteSQL]).
+func (me *Table) WriteSQL(out io.Writer, opts SQLOptions) error {
+	writer := bufio.NewWriter(out)
+	if err := me.writeSQL(writer, opts); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (me *Table) writeSQL(writer *bufio.Writer, opts SQLOptions) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	name := sqlQuoteName(me.Name)
+	names := make([]string, 0, len(me.Fields))
+	writer.WriteString("CREATE TABLE " + name + " (")
+	for i, field := range me.Fields {
+		if i > 0 {
+			writer.WriteByte(',')
+		}
+		names = append(names, sqlQuoteName(field.Name))
+		writer.WriteString("\n    " + names[i] + " " +
+			sqlTypeName(field.Kind, opts.Dialect))
+		if !field.AllowNull {
+			writer.WriteString(" NOT NULL")
+		}
+	}
+	writer.WriteString("\n);\n")
+	insert := "INSERT INTO " + name + " (" + strings.Join(names, ", ") +
+		") VALUES"
+	for i, record := range me.Records {
+		if i%batchSize == 0 {
+			writer.WriteString(insert)
+		} else {
+			writer.WriteByte(',')
+		}
+		writer.WriteString("\n(")
+		for column, value := range record {
+			if column > 0 {
+				writer.WriteString(", ")
+			}
+			literal, err := sqlValueLiteral(value,
+				me.Fields[column].Kind, opts.Dialect)
+			if err != nil {
+				return fmt.Errorf("e%d#%s.%s:row %d: %s", e177, me.Name,
+					me.Fields[column].Name, i, err)
+			}
+			writer.WriteString(literal)
+		}
+		writer.WriteByte(')')
+		if (i+1)%batchSize == 0 || i+1 == len(me.Records) {
+			writer.WriteString(";\n")
+		}
+	}
+	return nil
+}
+
+// sqlQuoteName returns the given name as a double-quoted SQL identifier.
+func sqlQuoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlTypeName returns the SQL column type for the given kind.
+func sqlTypeName(kind FieldKind, dialect SQLDialect) string {
+	switch kind {
+	case BoolField:
+		return "BOOLEAN"
+	case BytesField:
+		if dialect == Postgres {
+			return "BYTEA"
+		}
+		return "BLOB"
+	case DateField:
+		return "DATE"
+	case DateTimeField:
+		if dialect == Postgres {
+			return "TIMESTAMP"
+		}
+		return "DATETIME"
+	case IntField:
+		if dialect == Postgres {
+			return "BIGINT"
+		}
+		return "INTEGER"
+	case RealField:
+		if dialect == Postgres {
+			return "DOUBLE PRECISION"
+		}
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+// sqlValueLiteral returns the given value as an SQL literal. The error has
+// no error code.
+func sqlValueLiteral(value any, kind FieldKind, dialect SQLDialect) (
+	string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		switch {
+		case dialect == Postgres && v:
+			return "TRUE", nil
+		case dialect == Postgres:
+			return "FALSE", nil
+		case v:
+			return "1", nil
+		}
+		return "0", nil
+	case []byte:
+		if dialect == Postgres {
+			return `'\x` + hex.EncodeToString(v) + "'", nil
+		}
+		return "X'" + hex.EncodeToString(v) + "'", nil
+	case time.Time:
+		if kind == DateField {
+			return "'" + v.Format(DateFormat) + "'", nil
+		}
+		return "'" + v.Format(DateTimeFormat) + "'", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		switch {
+		case math.IsNaN(v) && dialect == Postgres:
+			return "'NaN'", nil
+		case math.IsNaN(v):
+			return "", fmt.Errorf("can't write %g for SQLite", v)
+		case math.IsInf(v, 1) && dialect == Postgres:
+			return "'Infinity'", nil
+		case math.IsInf(v, -1) && dialect == Postgres:
+			return "'-Infinity'", nil
+		case math.IsInf(v, 1):
+			return "1e999", nil // SQLite reads this as Inf
+		case math.IsInf(v, -1):
+			return "-1e999", nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	}
+	return "", fmt.Errorf("can't write %v (%T) as SQL", value, value)
+}
@@ -0,0 +1,672 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// ParseSQLite returns a new Tdb holding the tables in the given SQLite 3
+// database file's raw bytes. It is pure Go and only reads the file format,
+// so it doesn't need SQLite itself.
+//
+// Each ordinary table becomes a Tdb table; SQLite's own tables (sqlite_*)
+// and virtual tables are skipped, and WITHOUT ROWID tables aren't
+// supported. Table and column names are made into valid Tdb identifiers if
+// necessary. A column is nullable unless it is declared NOT NULL or is an
+// INTEGER PRIMARY KEY (or a null is found in it).
+//
+// Each column's kind is taken from its declared type: a type containing
+// BOOL is a bool, INT an int, DATETIME or TIMESTAMP a datetime, DATE a date,
+// CHAR, CLOB, or TEXT a str, BLOB bytes, and REAL, FLOA, or DOUB a real.
+// Other columns (e.g., NUMERIC or untyped) are ints if all their values
+// are integers, reals if all are numbers, bytes if all are blobs, and strs
+// otherwise. Values are converted to their column's kind (e.g., 1 and 0 to
+// true and false for a bool, and ISO 8601 text to a date), and any that
+// can't be are an error.
+//
+// Only the main database file is read: changes still in a write-ahead log
+// (-wal) file must first be checkpointed (e.g., by closing the database).
+// Columns added using ALTER TABLE are null in rows that predate them.
+func ParseSQLite(raw []byte) (*Tdb, error) {
+	db := NewTdb()
+	if len(raw) == 0 { // SQLite treats an empty file as an empty database
+		return &db, nil
+	}
+	file, err := newSQLiteFile(raw)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := file.readRows(1)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, row := range schema { // type name tbl_name rootpage sql
+		if len(row.values) < 5 || row.values[0] != "table" {
+			continue
+		}
+		name, _ := row.values[1].(string)
+		rootpage, _ := row.values[3].(int64)
+		sql, _ := row.values[4].(string)
+		if strings.HasPrefix(strings.ToLower(name), "sqlite_") ||
+			rootpage == 0 { // rootpage is 0 for virtual tables
+			continue
+		}
+		columns, err := parseSQLiteCreate(name, sql)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := file.readRows(int(rootpage))
+		if err != nil {
+			return nil, err
+		}
+		table, err := newSQLiteTable(makeIdentifier(name, used), columns,
+			rows)
+		if err != nil {
+			return nil, err
+		}
+		db.AddTable(table)
+	}
+	return &db, nil
+}
+
+// sqliteFile is an SQLite 3 database file's raw bytes.
+type sqliteFile struct {
+	raw        []byte
+	pageSize   int
+	usableSize int
+	utf16      binary.ByteOrder // nil for UTF-8
+}
+
+// sqliteRow is a table b-tree row.
+type sqliteRow struct {
+	rowid  int64
+	values []any // nil, int64, float64, string, or []byte
+}
+
+// sqliteColumn is a column from a CREATE TABLE statement.
+type sqliteColumn struct {
+	name     string
+	typeName string // uppercase
+	notNull  bool
+	rowid    bool // true for an INTEGER PRIMARY KEY (rowid alias)
+}
+
+func newSQLiteFile(raw []byte) (*sqliteFile, error) {
+	if len(raw) < 100 || string(raw[:16]) != "SQLite format 3\x00" {
+		return nil, fmt.Errorf("e%d#not an SQLite 3 database", e178)
+	}
+	file := &sqliteFile{raw: raw,
+		pageSize: int(binary.BigEndian.Uint16(raw[16:]))}
+	if file.pageSize == 1 {
+		file.pageSize = 65536
+	}
+	if file.pageSize < 512 || file.pageSize&(file.pageSize-1) != 0 {
+		return nil, fmt.Errorf("e%d#invalid SQLite page size %d", e178,
+			file.pageSize)
+	}
+	file.usableSize = file.pageSize - int(raw[20])
+	if file.usableSize < 480 {
+		return nil, fmt.Errorf("e%d#invalid SQLite reserved space %d",
+			e178, raw[20])
+	}
+	switch binary.BigEndian.Uint32(raw[56:]) {
+	case 0, 1: // 0 means not yet set: the database is empty
+	case 2:
+		file.utf16 = binary.LittleEndian
+	case 3:
+		file.utf16 = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("e%d#invalid SQLite text encoding", e178)
+	}
+	return file, nil
+}
+
+// page returns the given (1-based) page.
+func (me *sqliteFile) page(number int) ([]byte, error) {
+	start := (number - 1) * me.pageSize
+	if number < 1 || start+me.pageSize > len(me.raw) {
+		return nil, fmt.Errorf("e%d#SQLite page %d is out of range", e178,
+			number)
+	}
+	return me.raw[start : start+me.pageSize], nil
+}
+
+// readRows returns the rows of the table b-tree with the given root page
+// in rowid order.
+func (me *sqliteFile) readRows(root int) ([]sqliteRow, error) {
+	rows := make([]sqliteRow, 0)
+	seen := make(map[int]bool)
+	var visit func(number int) error
+	visit = func(number int) error {
+		if seen[number] {
+			return fmt.Errorf("e%d#SQLite page %d is in a loop", e178,
+				number)
+		}
+		seen[number] = true
+		page, err := me.page(number)
+		if err != nil {
+			return err
+		}
+		header := 0
+		if number == 1 {
+			header = 100
+		}
+		kind := page[header]
+		if kind != 0x05 && kind != 0x0D {
+			return fmt.Errorf("e%d#SQLite page %d isn't a table b-tree "+
+				"page (WITHOUT ROWID tables aren't supported)", e178,
+				number)
+		}
+		count := int(binary.BigEndian.Uint16(page[header+3:]))
+		pointers := header + 8
+		if kind == 0x05 {
+			pointers = header + 12
+		}
+		if pointers+count*2 > len(page) {
+			return fmt.Errorf("e%d#SQLite page %d is corrupt", e178, number)
+		}
+		for i := 0; i < count; i++ {
+			offset := int(binary.BigEndian.Uint16(page[pointers+i*2:]))
+			if kind == 0x05 { // interior: left child page then key
+				if offset+4 > len(page) {
+					return fmt.Errorf("e%d#SQLite page %d is corrupt", e178,
+						number)
+				}
+				if err = visit(int(binary.BigEndian.Uint32(
+					page[offset:]))); err != nil {
+					return err
+				}
+				continue
+			}
+			row, err := me.readCell(page, offset)
+			if err != nil {
+				return fmt.Errorf("e%d#SQLite page %d: %s", e178, number,
+					err)
+			}
+			rows = append(rows, row)
+		}
+		if kind == 0x05 { // rightmost child
+			return visit(int(binary.BigEndian.Uint32(page[header+8:])))
+		}
+		return nil
+	}
+	if err := visit(root); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// readCell returns the row in the table b-tree leaf cell at the given
+// offset. The error has no error code.
+func (me *sqliteFile) readCell(page []byte, offset int) (sqliteRow,
+	error) {
+	var row sqliteRow
+	if offset >= len(page) {
+		return row, errors.New("cell offset out of range")
+	}
+	size, n := sqliteVarint(page[offset:])
+	if n == 0 || size > uint64(len(me.raw)) {
+		return row, errors.New("invalid cell payload size")
+	}
+	offset += n
+	rowid, n := sqliteVarint(page[offset:])
+	if n == 0 {
+		return row, errors.New("invalid cell rowid")
+	}
+	row.rowid = int64(rowid)
+	payload, err := me.payload(page, offset+n, int(size))
+	if err != nil {
+		return row, err
+	}
+	row.values, err = me.decodeRecord(payload)
+	return row, err
+}
+
+// payload returns the size bytes of payload starting at the given offset
+// in the page and continuing in overflow pages if necessary. The error has
+// no error code.
+func (me *sqliteFile) payload(page []byte, offset, size int) ([]byte,
+	error) {
+	local := size
+	if maxLocal := me.usableSize - 35; size > maxLocal {
+		minLocal := (me.usableSize-12)*32/255 - 23
+		local = minLocal + (size-minLocal)%(me.usableSize-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	end := offset + local
+	if local < size {
+		end += 4 // the first overflow page number
+	}
+	if end > len(page) {
+		return nil, errors.New("cell payload out of range")
+	}
+	if local == size {
+		return page[offset:end], nil
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, page[offset:offset+local]...)
+	next := int(binary.BigEndian.Uint32(page[offset+local:]))
+	for count := 0; len(payload) < size; count++ {
+		if count*me.pageSize > len(me.raw) {
+			return nil, errors.New("overflow pages are in a loop")
+		}
+		overflow, err := me.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = int(binary.BigEndian.Uint32(overflow))
+		payload = append(payload, overflow[4:4+min(size-len(payload),
+			me.usableSize-4)]...)
+	}
+	return payload, nil
+}
+
+// decodeRecord returns the values in the given record. The error has no
+// error code.
+func (me *sqliteFile) decodeRecord(record []byte) ([]any, error) {
+	headerSize, n := sqliteVarint(record)
+	if n == 0 || headerSize > uint64(len(record)) {
+		return nil, errors.New("invalid record header")
+	}
+	serialTypes := make([]uint64, 0)
+	for i := n; i < int(headerSize); i += n {
+		var serialType uint64
+		if serialType, n = sqliteVarint(record[i:int(headerSize)]); n == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		serialTypes = append(serialTypes, serialType)
+	}
+	values := make([]any, 0, len(serialTypes))
+	body := record[headerSize:]
+	for _, serialType := range serialTypes {
+		size := 0
+		switch {
+		case serialType >= 12:
+			size = int((serialType - 12) / 2)
+		case serialType >= 1 && serialType <= 7:
+			size = []int{0, 1, 2, 3, 4, 6, 8, 8}[serialType]
+		case serialType == 10 || serialType == 11:
+			return nil, fmt.Errorf("invalid serial type %d", serialType)
+		}
+		if size > len(body) {
+			return nil, errors.New("record value out of range")
+		}
+		data := body[:size]
+		body = body[size:]
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType <= 6:
+			var i int64
+			if size > 0 && data[0]&0x80 != 0 {
+				i = -1 // sign extend
+			}
+			for _, b := range data {
+				i = i<<8 | int64(b)
+			}
+			values = append(values, i)
+		case serialType == 7:
+			values = append(values, math.Float64frombits(
+				binary.BigEndian.Uint64(data)))
+		case serialType == 8 || serialType == 9:
+			values = append(values, int64(serialType-8))
+		case serialType%2 == 0:
+			values = append(values, append([]byte{}, data...))
+		default:
+			values = append(values, me.text(data))
+		}
+	}
+	return values, nil
+}
+
+// text returns the given data as a string using the database's encoding.
+func (me *sqliteFile) text(data []byte) string {
+	if me.utf16 == nil {
+		return string(data)
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, me.utf16.Uint16(data[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// sqliteVarint returns the SQLite varint at the start of data and its
+// length in bytes, or a length of 0 if data is too short.
+func sqliteVarint(data []byte) (uint64, int) {
+	var value uint64
+	for i, b := range data {
+		if i == 8 {
+			return value<<8 | uint64(b), 9
+		}
+		value = value<<7 | uint64(b&0x7F)
+		if b&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// parseSQLiteCreate returns the stored columns in the given CREATE TABLE
+// statement for the named table.
+func parseSQLiteCreate(tableName, sql string) ([]sqliteColumn, error) {
+	tokens := sqliteTokens(sql)
+	start := 0
+	for start < len(tokens) && tokens[start] != "(" {
+		start++
+	}
+	definitions := make([][]string, 0)
+	definition := make([]string, 0)
+	depth := 0
+	end := start
+	for end = start + 1; end < len(tokens); end++ {
+		token := tokens[end]
+		if token == "(" {
+			depth++
+		} else if token == ")" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		} else if token == "," && depth == 0 {
+			definitions = append(definitions, definition)
+			definition = make([]string, 0)
+			continue
+		}
+		definition = append(definition, token)
+	}
+	if end >= len(tokens) || len(definition) == 0 {
+		return nil, fmt.Errorf("e%d#%s:failed to parse SQLite CREATE "+
+			"TABLE: %q", e179, tableName, sql)
+	}
+	definitions = append(definitions, definition)
+	for _, token := range tokens[end:] {
+		if strings.EqualFold(token, "WITHOUT") {
+			return nil, fmt.Errorf("e%d#%s:SQLite WITHOUT ROWID tables "+
+				"aren't supported", e179, tableName)
+		}
+	}
+	columns := make([]sqliteColumn, 0, len(definitions))
+	var primaryKey []string // from a table constraint
+	for _, definition := range definitions {
+		if len(definition) == 0 { // e.g., a corrupt (a INT,)
+			return nil, fmt.Errorf("e%d#%s:empty column definition in "+
+				"SQLite CREATE TABLE: %q", e179, tableName, sql)
+		}
+		switch strings.ToUpper(definition[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			if i := sqliteFind(definition, "PRIMARY", "KEY"); i > -1 {
+				primaryKey = definition[i+2:]
+			}
+			continue
+		}
+		column, stored := parseSQLiteColumn(definition)
+		if stored {
+			columns = append(columns, column)
+		}
+	}
+	if len(primaryKey) == 4 && primaryKey[0] == "(" && // e.g., (id ASC)
+		primaryKey[3] == ")" {
+		primaryKey = primaryKey[:2]
+	}
+	if len(primaryKey) == 3 && primaryKey[0] == "(" && primaryKey[2] == ")" {
+		name := sqliteUnquote(primaryKey[1])
+		for i, column := range columns {
+			if strings.EqualFold(column.name, name) &&
+				column.typeName == "INTEGER" {
+				columns[i].rowid = true
+			}
+		}
+	}
+	return columns, nil
+}
+
+// parseSQLiteColumn returns the column for the given column definition
+// tokens and whether it is stored (VIRTUAL generated columns aren't).
+func parseSQLiteColumn(definition []string) (sqliteColumn, bool) {
+	column := sqliteColumn{name: sqliteUnquote(definition[0])}
+	typeNames := make([]string, 0)
+	i := 1
+TypeName:
+	for depth := 0; i < len(definition); i++ {
+		token := strings.ToUpper(definition[i])
+		if depth == 0 {
+			switch token {
+			case "CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK",
+				"DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS":
+				break TypeName
+			}
+		}
+		if token == "(" {
+			depth++
+		} else if token == ")" {
+			depth--
+		}
+		typeNames = append(typeNames, token)
+	}
+	column.typeName = strings.Join(typeNames, " ")
+	constraints := definition[i:]
+	column.notNull = sqliteFind(constraints, "NOT", "NULL") > -1
+	column.rowid = column.typeName == "INTEGER" &&
+		sqliteFind(constraints, "PRIMARY", "KEY") > -1 &&
+		sqliteFind(constraints, "DESC") == -1
+	stored := sqliteFind(constraints, "AS") == -1 ||
+		sqliteFind(constraints, "STORED") > -1
+	return column, stored
+}
+
+// sqliteFind returns the index of the given sequence of (case-insensitive)
+// words in tokens, or -1.
+func sqliteFind(tokens []string, words ...string) int {
+	for i := 0; i+len(words) <= len(tokens); i++ {
+		found := true
+		for j, word := range words {
+			if !strings.EqualFold(tokens[i+j], word) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i
+		}
+	}
+	return -1
+}
+
+// sqliteTokens returns the tokens in the given SQL: words (including
+// numbers), quoted names and strings (with their quotes), and single
+// punctuation characters. Whitespace and comments are dropped.
+func sqliteTokens(sql string) []string {
+	tokens := make([]string, 0)
+	runes := []rune(sql)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			continue
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			for i += 2; i+1 < len(runes) && !(runes[i] == '*' &&
+				runes[i+1] == '/'); i++ {
+			}
+			i++
+			continue
+		case c == '"' || c == '\'' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			for i++; i < len(runes); i++ {
+				if runes[i] == closer {
+					if closer != ']' && i+1 < len(runes) &&
+						runes[i+1] == closer {
+						i++ // doubled quote
+						continue
+					}
+					break
+				}
+			}
+		case c == '_' || c == '$' || unicode.IsLetter(c) ||
+			unicode.IsDigit(c):
+			for i+1 < len(runes) && (runes[i+1] == '_' ||
+				runes[i+1] == '$' || unicode.IsLetter(runes[i+1]) ||
+				unicode.IsDigit(runes[i+1])) {
+				i++
+			}
+		}
+		tokens = append(tokens, string(runes[start:min(i+1, len(runes))]))
+	}
+	return tokens
+}
+
+// sqliteUnquote returns the given name without its quotes (if any).
+func sqliteUnquote(name string) string {
+	if len(name) < 2 {
+		return name
+	}
+	switch name[0] {
+	case '"', '\'', '`':
+		quote := name[:1]
+		return strings.ReplaceAll(name[1:len(name)-1], quote+quote, quote)
+	case '[':
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// newSQLiteTable returns a table with the given name, columns, and rows.
+func newSQLiteTable(tableName string, columns []sqliteColumn,
+	rows []sqliteRow) (*Table, error) {
+	table := NewTable()
+	table.Name = tableName
+	used := make(map[string]bool)
+	for _, column := range columns {
+		table.Fields = append(table.Fields, &MetaFieldType{
+			makeIdentifier(column.name, used), StrField,
+			!(column.notNull || column.rowid)})
+	}
+	values := make([]any, len(rows))
+	for column, field := range table.Fields {
+		for i, row := range rows {
+			values[i] = nil
+			if columns[column].rowid {
+				values[i] = row.rowid
+			} else if column < len(row.values) {
+				values[i] = row.values[column]
+			}
+			if values[i] == nil {
+				field.AllowNull = true
+			}
+		}
+		field.Kind = sqliteKind(columns[column].typeName, values)
+	}
+	for i, row := range rows {
+		record := newRecord(len(table.Fields))
+		for column, field := range table.Fields {
+			var value any
+			if columns[column].rowid {
+				value = row.rowid
+			} else if column < len(row.values) {
+				value = row.values[column]
+			}
+			var err error
+			if record[column], err = sqliteConvert(value,
+				field.Kind); err != nil {
+				return nil, fmt.Errorf("e%d#%s.%s:row %d: %s", e180,
+					table.Name, field.Name, i+1, err)
+			}
+		}
+		table.Records = append(table.Records, record)
+	}
+	return &table, nil
+}
+
+// sqliteKind returns the kind for a column with the given (uppercase)
+// declared type and values.
+func sqliteKind(typeName string, values []any) FieldKind {
+	switch {
+	case strings.Contains(typeName, "BOOL"):
+		return BoolField
+	case strings.Contains(typeName, "INT"):
+		return IntField
+	case strings.Contains(typeName, "DATETIME"),
+		strings.Contains(typeName, "TIMESTAMP"):
+		return DateTimeField
+	case strings.Contains(typeName, "DATE"):
+		return DateField
+	case strings.Contains(typeName, "CHAR"),
+		strings.Contains(typeName, "CLOB"),
+		strings.Contains(typeName, "TEXT"):
+		return StrField
+	case strings.Contains(typeName, "BLOB"):
+		return BytesField
+	case strings.Contains(typeName, "REAL"),
+		strings.Contains(typeName, "FLOA"),
+		strings.Contains(typeName, "DOUB"):
+		return RealField
+	}
+	counts := make(map[FieldKind]int)
+	count := 0
+	for _, value := range values {
+		switch value.(type) {
+		case int64:
+			counts[IntField]++
+		case float64:
+			counts[RealField]++
+		case []byte:
+			counts[BytesField]++
+		case string:
+			counts[StrField]++
+		default:
+			continue
+		}
+		count++
+	}
+	switch {
+	case count == 0:
+		return StrField
+	case counts[IntField] == count:
+		return IntField
+	case counts[IntField]+counts[RealField] == count:
+		return RealField
+	case counts[BytesField] == count:
+		return BytesField
+	}
+	return StrField
+}
+
+// sqliteConvert returns the given SQLite value converted to the given kind.
+// The error has no error code.
+func sqliteConvert(value any, kind FieldKind) (any, error) {
+	switch v := value.(type) {
+	case int64:
+		return convertValue(int(v), IntField, kind)
+	case float64:
+		return convertValue(v, RealField, kind)
+	case []byte:
+		if kind == BytesField {
+			return v, nil
+		}
+		if kind == StrField {
+			return formatValue(v, StrField)
+		}
+		return nil, fmt.Errorf("can't convert a blob to %s", kind)
+	}
+	return convertValue(value, StrField, kind) // nil or string
+}
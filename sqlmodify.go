@@ -0,0 +1,207 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import "fmt"
+
+// Exec executes the given SQL INSERT, UPDATE, or DELETE statement against
+// the given [Tdb] and returns how many records were inserted, updated, or
+// deleted. The supported statements are:
+//
+//	INSERT INTO table [(field, ...)] VALUES (expr, ...) [, (expr, ...)] ...
+//	UPDATE table SET field = expr [, field = expr] ... [WHERE expr]
+//	DELETE FROM table [WHERE expr]
+//
+// Expressions are as for [Query]. For INSERT, fields not given are set to
+// null (so must be nullable). Values are converted to their field's kind
+// where possible (e.g., an int to a real or a 'yyyy-mm-dd' string to a
+// date) using the same rules as [Table.ChangeColumnType].
+//
+// Any args are the values for the statement's ? placeholders; each must be
+// nil or one of the Go types that Tdb uses (bool, []byte, int, float64,
+// string, or time.Time).
+//
+// Each statement is all or nothing: if any value is invalid, an error is
+// returned and the table is left unchanged. The table's indexes are kept up
+// to date (see [Index]).
+func Exec(db *Tdb, statement string, args ...any) (int, error) {
+	stmt, err := sqlParse(statement, args)
+	if err != nil {
+		return 0, err
+	}
+	switch stmt := stmt.(type) {
+	case *sqlInsert:
+		return stmt.execute(db)
+	case *sqlUpdate:
+		return stmt.execute(db)
+	case *sqlDelete:
+		return stmt.execute(db)
+	}
+	return 0, fmt.Errorf("e%d#Exec only supports INSERT, UPDATE, and "+
+		"DELETE; use Query", e169)
+}
+
+func (me *sqlInsert) execute(db *Tdb) (int, error) {
+	table, scope, err := sqlTableAndScope(db, sqlTableRef{name: me.table})
+	if err != nil {
+		return 0, err
+	}
+	columns := make([]int, 0, len(table.Fields))
+	if me.fields == nil {
+		for column := range table.Fields {
+			columns = append(columns, column)
+		}
+	} else {
+		for _, name := range me.fields {
+			column, err := scope.resolve("", name)
+			if err != nil {
+				return 0, err
+			}
+			for _, c := range columns {
+				if c == column {
+					return 0, fmt.Errorf("e%d#%s:duplicate field %q", e169,
+						table.Name, name)
+				}
+			}
+			columns = append(columns, column)
+		}
+	}
+	noFields := &sqlScope{} // VALUES can't refer to fields
+	records := make([]Record, 0, len(me.rows))
+	for _, row := range me.rows {
+		if len(row) != len(columns) {
+			return 0, fmt.Errorf("e%d#%s:expected %d values, got %d", e155,
+				table.Name, len(columns), len(row))
+		}
+		record := newRecord(len(table.Fields))
+		for i, expr := range row {
+			field := table.Fields[columns[i]]
+			if record[columns[i]], err = sqlAssignValue(expr, noFields,
+				sqlRow{}, table.Name, field); err != nil {
+				return 0, err
+			}
+		}
+		if err = table.checkRecord(record); err != nil {
+			return 0, err
+		}
+		records = append(records, record)
+	}
+	for _, record := range records {
+		if err = table.AppendRecord(record); err != nil {
+			return 0, err // can't happen: already checked
+		}
+	}
+	return len(records), nil
+}
+
+func (me *sqlUpdate) execute(db *Tdb) (int, error) {
+	table, scope, err := sqlTableAndScope(db, sqlTableRef{name: me.table})
+	if err != nil {
+		return 0, err
+	}
+	rows, err := sqlMatchingRows(table, scope, me.where)
+	if err != nil {
+		return 0, err
+	}
+	columns := make([]int, 0, len(me.sets))
+	for _, set := range me.sets {
+		column, err := scope.resolve("", set.field)
+		if err != nil {
+			return 0, err
+		}
+		columns = append(columns, column)
+	}
+	// Compute every new value first so that SET expressions see the old
+	// values and so that nothing changes if any value is invalid.
+	values := make([][]any, 0, len(rows))
+	for _, row := range rows {
+		rowValues := make([]any, 0, len(me.sets))
+		for i, set := range me.sets {
+			value, err := sqlAssignValue(set.expr, scope,
+				sqlRow{record: table.Records[row]}, table.Name,
+				table.Fields[columns[i]])
+			if err != nil {
+				return 0, err
+			}
+			rowValues = append(rowValues, value)
+		}
+		values = append(values, rowValues)
+	}
+	for i, row := range rows {
+		for j, column := range columns {
+			if err = table.UpdateValue(row, table.Fields[column].Name,
+				values[i][j]); err != nil {
+				return 0, err // can't happen: already checked
+			}
+		}
+	}
+	return len(rows), nil
+}
+
+func (me *sqlDelete) execute(db *Tdb) (int, error) {
+	table, scope, err := sqlTableAndScope(db, sqlTableRef{name: me.table})
+	if err != nil {
+		return 0, err
+	}
+	rows, err := sqlMatchingRows(table, scope, me.where)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	doomed := make(map[int]bool, len(rows))
+	for _, row := range rows {
+		doomed[row] = true
+	}
+	row := -1
+	return table.DeleteRecords(func(Record) bool { // called in row order
+		row++
+		return doomed[row]
+	}), nil
+}
+
+// sqlMatchingRows returns the rows for which where is true (or every row
+// if where is nil).
+func sqlMatchingRows(table *Table, scope *sqlScope, where sqlExpr) ([]int,
+	error) {
+	if where != nil {
+		if err := scope.checkBool(where, "WHERE"); err != nil {
+			return nil, err
+		}
+	}
+	rows := make([]int, 0)
+	for row, record := range table.Records {
+		if where != nil {
+			value, err := sqlEval(where, sqlRow{record: record})
+			if err != nil {
+				return nil, err
+			}
+			if value != true {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// sqlAssignValue returns the value of the expression converted to the
+// given field's kind or an error if the value isn't valid for the field.
+func sqlAssignValue(expr sqlExpr, scope *sqlScope, row sqlRow,
+	tableName string, field *MetaFieldType) (any, error) {
+	typ, err := scope.check(expr, false)
+	if err != nil {
+		return nil, err
+	}
+	value, err := sqlEval(expr, row)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = convertValue(value, typ.kind, field.Kind); err == nil {
+		err = checkValue(field, value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("e%d#%s.%s:%s", e156, tableName, field.Name,
+			err)
+	}
+	return value, nil
+}
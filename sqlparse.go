@@ -0,0 +1,879 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// This file has the lexer and parser for the SQL subset supported by
+// [Query] and [Exec]; the executors are in sql.go and sqlmodify.go.
+
+type sqlTokenKind uint8
+
+const (
+	sqlEOF sqlTokenKind = iota
+	sqlIdent
+	sqlKeyword
+	sqlNumber
+	sqlString
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string // keywords are uppercased; strings are unquoted
+	pos  int    // byte offset in the query
+}
+
+var sqlKeywords = map[string]bool{"ALL": true, "AND": true, "AS": true,
+	"ASC": true, "BETWEEN": true, "BY": true, "DATE": true, "DATETIME": true,
+	"DELETE": true, "DESC": true, "DISTINCT": true, "FALSE": true,
+	"FROM": true, "FULL": true, "GROUP": true, "HAVING": true, "IN": true,
+	"INNER": true, "INSERT": true, "INTO": true, "IS": true, "JOIN": true,
+	"LEFT": true, "LIKE": true, "LIMIT": true, "NOT": true, "NULL": true,
+	"OFFSET": true, "ON": true, "OR": true, "ORDER": true, "OUTER": true,
+	"SELECT": true, "SET": true, "TRUE": true, "UPDATE": true,
+	"USING": true, "VALUES": true, "WHERE": true}
+
+var sqlSymbols = map[string]bool{"(": true, ")": true, ",": true, ".": true,
+	"*": true, "=": true, "<": true, ">": true, "+": true, "-": true,
+	"/": true, "%": true, ";": true, "?": true, "<=": true, ">=": true,
+	"<>": true, "!=": true, "||": true}
+
+func sqlLex(query string) ([]sqlToken, error) {
+	tokens := make([]sqlToken, 0)
+	runes := []rune(query)
+	offset := func(i int) int { return len(string(runes[:i])) }
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '_' || unicode.IsLetter(c):
+			for i < len(runes) && (runes[i] == '_' ||
+				unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			text := string(runes[start:i])
+			upper := strings.ToUpper(text)
+			if sqlKeywords[upper] {
+				tokens = append(tokens, sqlToken{sqlKeyword, upper,
+					offset(start)})
+			} else {
+				tokens = append(tokens, sqlToken{sqlIdent, text,
+					offset(start)})
+			}
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) &&
+			unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) ||
+				runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '-' || runes[i] == '+') &&
+					(runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{sqlNumber,
+				string(runes[start:i]), offset(start)})
+		case c == '\'' || c == '"' || c == '`':
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("e%d#%d:unterminated %c", e166,
+						offset(start), c)
+				}
+				if runes[i] == c {
+					if i+1 < len(runes) && runes[i+1] == c { // e.g., ''
+						text.WriteRune(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			kind := sqlString
+			if c != '\'' {
+				kind = sqlIdent // "quoted" or `quoted` identifier
+			}
+			tokens = append(tokens, sqlToken{kind, text.String(),
+				offset(start)})
+		default:
+			symbol := string(c)
+			if i+1 < len(runes) {
+				switch pair := string(runes[i : i+2]); pair {
+				case "<=", ">=", "<>", "!=", "||":
+					symbol = pair
+				}
+			}
+			if !sqlSymbols[symbol] {
+				return nil, fmt.Errorf("e%d#%d:unexpected character %q",
+					e166, offset(start), c)
+			}
+			i += len([]rune(symbol))
+			tokens = append(tokens, sqlToken{sqlSymbol, symbol,
+				offset(start)})
+		}
+	}
+	return append(tokens, sqlToken{sqlEOF, "", len(query)}), nil
+}
+
+// sqlStatement is one of *sqlSelect, *sqlInsert, *sqlUpdate, or *sqlDelete.
+type sqlStatement interface{}
+
+// sqlExpr is one of the sqlXxx expression types below.
+type sqlExpr interface{}
+
+type sqlLiteral struct {
+	value any // nil for NULL
+	kind  FieldKind
+}
+
+type sqlColumn struct {
+	table string // "" if unqualified
+	name  string
+	index int // set by sqlScope.check()
+}
+
+type sqlUnary struct {
+	op string // "-" or "NOT"
+	x  sqlExpr
+}
+
+type sqlBinary struct {
+	op    string // + - * / % || = <> < <= > >= AND OR LIKE
+	x, y  sqlExpr
+	kinds [2]FieldKind // x's and y's kinds; set by sqlScope.check() for ||
+}
+
+type sqlIsNull struct {
+	x   sqlExpr
+	not bool
+}
+
+type sqlIn struct {
+	x    sqlExpr
+	list []sqlExpr
+	not  bool
+}
+
+type sqlBetween struct {
+	x, low, high sqlExpr
+	not          bool
+}
+
+type sqlCall struct {
+	name string // uppercased
+	args []sqlExpr
+	star bool // COUNT(*)
+}
+
+type sqlSelect struct {
+	distinct bool
+	star     bool
+	items    []sqlItem
+	from     sqlTableRef
+	joins    []sqlJoin
+	where    sqlExpr
+	groupBy  []sqlExpr
+	having   sqlExpr
+	orderBy  []sqlOrder
+	limit    int // -1 for no limit
+	offset   int
+}
+
+type sqlInsert struct {
+	table  string
+	fields []string // nil means all the table's fields in order
+	rows   [][]sqlExpr
+}
+
+type sqlUpdate struct {
+	table string
+	sets  []sqlSet
+	where sqlExpr
+}
+
+type sqlSet struct {
+	field string
+	expr  sqlExpr
+}
+
+type sqlDelete struct {
+	table string
+	where sqlExpr
+}
+
+type sqlItem struct {
+	expr  sqlExpr
+	alias string
+}
+
+type sqlTableRef struct {
+	name  string
+	alias string
+}
+
+type sqlJoin struct {
+	kind  JoinKind
+	table sqlTableRef
+	using []string
+	on    sqlExpr
+}
+
+type sqlOrder struct {
+	expr sqlExpr
+	desc bool
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+	args   []any // values for ? placeholders
+	used   int   // how many args have been used
+}
+
+func sqlParse(query string, args []any) (sqlStatement, error) {
+	tokens, err := sqlLex(query)
+	if err != nil {
+		return nil, err
+	}
+	parser := &sqlParser{tokens: tokens, args: args}
+	var stmt sqlStatement
+	switch token := parser.peek(); token.text {
+	case "INSERT":
+		stmt, err = parser.parseInsert()
+	case "UPDATE":
+		stmt, err = parser.parseUpdate()
+	case "DELETE":
+		stmt, err = parser.parseDelete()
+	default:
+		stmt, err = parser.parseSelect()
+	}
+	if err != nil {
+		return nil, err
+	}
+	parser.accept(sqlSymbol, ";")
+	if parser.peek().kind != sqlEOF {
+		return nil, parser.errorf("unexpected %q", parser.peek().text)
+	}
+	if parser.used != len(args) {
+		return nil, fmt.Errorf("e%d#expected %d arguments, got %d", e167,
+			parser.used, len(args))
+	}
+	return stmt, nil
+}
+
+func (me *sqlParser) peek() sqlToken {
+	return me.tokens[me.pos]
+}
+
+func (me *sqlParser) next() sqlToken {
+	token := me.tokens[me.pos]
+	if token.kind != sqlEOF {
+		me.pos++
+	}
+	return token
+}
+
+// accept consumes and returns true if the next token matches.
+func (me *sqlParser) accept(kind sqlTokenKind, text string) bool {
+	token := me.peek()
+	if token.kind == kind && token.text == text {
+		me.pos++
+		return true
+	}
+	return false
+}
+
+func (me *sqlParser) expect(kind sqlTokenKind, text string) error {
+	if !me.accept(kind, text) {
+		found := me.peek().text
+		if me.peek().kind == sqlEOF {
+			found = "end of query"
+		}
+		return me.errorf("expected %s, got %q", text, found)
+	}
+	return nil
+}
+
+func (me *sqlParser) ident() (string, error) {
+	token := me.next()
+	if token.kind != sqlIdent {
+		me.pos--
+		return "", me.errorf("expected a name, got %q", token.text)
+	}
+	return token.text, nil
+}
+
+func (me *sqlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("e%d#%d:%s", e167, me.peek().pos,
+		fmt.Sprintf(format, args...))
+}
+
+func (me *sqlParser) parseSelect() (*sqlSelect, error) {
+	if err := me.expect(sqlKeyword, "SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &sqlSelect{limit: -1}
+	if me.accept(sqlKeyword, "DISTINCT") {
+		stmt.distinct = true
+	} else {
+		me.accept(sqlKeyword, "ALL")
+	}
+	if err := me.parseItems(stmt); err != nil {
+		return nil, err
+	}
+	if err := me.expect(sqlKeyword, "FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.from, err = me.parseTableRef(); err != nil {
+		return nil, err
+	}
+	if err = me.parseJoins(stmt); err != nil {
+		return nil, err
+	}
+	if me.accept(sqlKeyword, "WHERE") {
+		if stmt.where, err = me.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if me.accept(sqlKeyword, "GROUP") {
+		if err = me.expect(sqlKeyword, "BY"); err != nil {
+			return nil, err
+		}
+		if stmt.groupBy, err = me.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if me.accept(sqlKeyword, "HAVING") {
+		if stmt.having, err = me.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if me.accept(sqlKeyword, "ORDER") {
+		if err = me.parseOrderBy(stmt); err != nil {
+			return nil, err
+		}
+	}
+	if me.accept(sqlKeyword, "LIMIT") {
+		if stmt.limit, err = me.parseCount(); err != nil {
+			return nil, err
+		}
+		if me.accept(sqlKeyword, "OFFSET") {
+			if stmt.offset, err = me.parseCount(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+func (me *sqlParser) parseInsert() (*sqlInsert, error) {
+	me.next() // INSERT
+	if err := me.expect(sqlKeyword, "INTO"); err != nil {
+		return nil, err
+	}
+	stmt := &sqlInsert{}
+	var err error
+	if stmt.table, err = me.ident(); err != nil {
+		return nil, err
+	}
+	if me.accept(sqlSymbol, "(") {
+		if stmt.fields, err = me.parseNames(); err != nil {
+			return nil, err
+		}
+	}
+	if err = me.expect(sqlKeyword, "VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err = me.expect(sqlSymbol, "("); err != nil {
+			return nil, err
+		}
+		row, err := me.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		if err = me.expect(sqlSymbol, ")"); err != nil {
+			return nil, err
+		}
+		stmt.rows = append(stmt.rows, row)
+		if !me.accept(sqlSymbol, ",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (me *sqlParser) parseUpdate() (*sqlUpdate, error) {
+	me.next() // UPDATE
+	stmt := &sqlUpdate{}
+	var err error
+	if stmt.table, err = me.ident(); err != nil {
+		return nil, err
+	}
+	if err = me.expect(sqlKeyword, "SET"); err != nil {
+		return nil, err
+	}
+	for {
+		var set sqlSet
+		if set.field, err = me.ident(); err != nil {
+			return nil, err
+		}
+		if err = me.expect(sqlSymbol, "="); err != nil {
+			return nil, err
+		}
+		if set.expr, err = me.parseExpr(); err != nil {
+			return nil, err
+		}
+		stmt.sets = append(stmt.sets, set)
+		if !me.accept(sqlSymbol, ",") {
+			break
+		}
+	}
+	if me.accept(sqlKeyword, "WHERE") {
+		if stmt.where, err = me.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (me *sqlParser) parseDelete() (*sqlDelete, error) {
+	me.next() // DELETE
+	if err := me.expect(sqlKeyword, "FROM"); err != nil {
+		return nil, err
+	}
+	stmt := &sqlDelete{}
+	var err error
+	if stmt.table, err = me.ident(); err != nil {
+		return nil, err
+	}
+	if me.accept(sqlKeyword, "WHERE") {
+		if stmt.where, err = me.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (me *sqlParser) parseItems(stmt *sqlSelect) error {
+	if me.accept(sqlSymbol, "*") {
+		stmt.star = true
+		return nil
+	}
+	for {
+		expr, err := me.parseExpr()
+		if err != nil {
+			return err
+		}
+		item := sqlItem{expr: expr}
+		if me.accept(sqlKeyword, "AS") {
+			if item.alias, err = me.ident(); err != nil {
+				return err
+			}
+		} else if me.peek().kind == sqlIdent {
+			item.alias = me.next().text
+		}
+		stmt.items = append(stmt.items, item)
+		if !me.accept(sqlSymbol, ",") {
+			return nil
+		}
+	}
+}
+
+func (me *sqlParser) parseTableRef() (sqlTableRef, error) {
+	var ref sqlTableRef
+	var err error
+	if ref.name, err = me.ident(); err != nil {
+		return ref, err
+	}
+	if me.accept(sqlKeyword, "AS") {
+		if ref.alias, err = me.ident(); err != nil {
+			return ref, err
+		}
+	} else if me.peek().kind == sqlIdent {
+		ref.alias = me.next().text
+	}
+	return ref, nil
+}
+
+func (me *sqlParser) parseJoins(stmt *sqlSelect) error {
+	for {
+		join := sqlJoin{kind: InnerJoin}
+		switch {
+		case me.accept(sqlKeyword, "JOIN"):
+		case me.accept(sqlKeyword, "INNER"):
+			if err := me.expect(sqlKeyword, "JOIN"); err != nil {
+				return err
+			}
+		case me.accept(sqlKeyword, "LEFT"), me.accept(sqlKeyword, "FULL"):
+			if me.tokens[me.pos-1].text == "FULL" {
+				join.kind = FullJoin
+			} else {
+				join.kind = LeftJoin
+			}
+			me.accept(sqlKeyword, "OUTER")
+			if err := me.expect(sqlKeyword, "JOIN"); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		var err error
+		if join.table, err = me.parseTableRef(); err != nil {
+			return err
+		}
+		if me.accept(sqlKeyword, "USING") {
+			if err = me.expect(sqlSymbol, "("); err != nil {
+				return err
+			}
+			if join.using, err = me.parseNames(); err != nil {
+				return err
+			}
+		} else if err = me.expect(sqlKeyword, "ON"); err != nil {
+			return err
+		} else if join.on, err = me.parseExpr(); err != nil {
+			return err
+		}
+		stmt.joins = append(stmt.joins, join)
+	}
+}
+
+// parseNames parses a comma-separated list of names and the closing ")".
+func (me *sqlParser) parseNames() ([]string, error) {
+	var names []string
+	for {
+		name, err := me.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !me.accept(sqlSymbol, ",") {
+			return names, me.expect(sqlSymbol, ")")
+		}
+	}
+}
+
+func (me *sqlParser) parseOrderBy(stmt *sqlSelect) error {
+	if err := me.expect(sqlKeyword, "BY"); err != nil {
+		return err
+	}
+	for {
+		expr, err := me.parseExpr()
+		if err != nil {
+			return err
+		}
+		order := sqlOrder{expr: expr}
+		if me.accept(sqlKeyword, "DESC") {
+			order.desc = true
+		} else {
+			me.accept(sqlKeyword, "ASC")
+		}
+		stmt.orderBy = append(stmt.orderBy, order)
+		if !me.accept(sqlSymbol, ",") {
+			return nil
+		}
+	}
+}
+
+func (me *sqlParser) parseCount() (int, error) {
+	token := me.next()
+	n, err := strconv.Atoi(token.text)
+	if token.kind != sqlNumber || err != nil || n < 0 {
+		me.pos--
+		return 0, me.errorf("expected a count, got %q", token.text)
+	}
+	return n, nil
+}
+
+func (me *sqlParser) parseExprList() ([]sqlExpr, error) {
+	exprs := make([]sqlExpr, 0, 1)
+	for {
+		expr, err := me.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !me.accept(sqlSymbol, ",") {
+			return exprs, nil
+		}
+	}
+}
+
+func (me *sqlParser) parseExpr() (sqlExpr, error) {
+	return me.parseOr()
+}
+
+func (me *sqlParser) parseOr() (sqlExpr, error) {
+	x, err := me.parseAnd()
+	for err == nil && me.accept(sqlKeyword, "OR") {
+		var y sqlExpr
+		if y, err = me.parseAnd(); err == nil {
+			x = &sqlBinary{op: "OR", x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (me *sqlParser) parseAnd() (sqlExpr, error) {
+	x, err := me.parseNot()
+	for err == nil && me.accept(sqlKeyword, "AND") {
+		var y sqlExpr
+		if y, err = me.parseNot(); err == nil {
+			x = &sqlBinary{op: "AND", x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (me *sqlParser) parseNot() (sqlExpr, error) {
+	if me.accept(sqlKeyword, "NOT") {
+		x, err := me.parseNot()
+		return &sqlUnary{"NOT", x}, err
+	}
+	return me.parseComparison()
+}
+
+func (me *sqlParser) parseComparison() (sqlExpr, error) {
+	x, err := me.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	token := me.peek()
+	if token.kind == sqlSymbol {
+		switch token.text {
+		case "=", "<>", "!=", "<", "<=", ">", ">=":
+			me.next()
+			y, err := me.parseAdditive()
+			op := token.text
+			if op == "!=" {
+				op = "<>"
+			}
+			return &sqlBinary{op: op, x: x, y: y}, err
+		}
+		return x, nil
+	}
+	if me.accept(sqlKeyword, "IS") {
+		not := me.accept(sqlKeyword, "NOT")
+		return &sqlIsNull{x, not}, me.expect(sqlKeyword, "NULL")
+	}
+	not := me.accept(sqlKeyword, "NOT")
+	switch {
+	case me.accept(sqlKeyword, "LIKE"):
+		y, err := me.parseAdditive()
+		var expr sqlExpr = &sqlBinary{op: "LIKE", x: x, y: y}
+		if not {
+			expr = &sqlUnary{"NOT", expr}
+		}
+		return expr, err
+	case me.accept(sqlKeyword, "IN"):
+		if err := me.expect(sqlSymbol, "("); err != nil {
+			return nil, err
+		}
+		list, err := me.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlIn{x, list, not}, me.expect(sqlSymbol, ")")
+	case me.accept(sqlKeyword, "BETWEEN"):
+		low, err := me.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err = me.expect(sqlKeyword, "AND"); err != nil {
+			return nil, err
+		}
+		high, err := me.parseAdditive()
+		return &sqlBetween{x, low, high, not}, err
+	}
+	if not {
+		return nil, me.errorf("expected LIKE, IN, or BETWEEN after NOT")
+	}
+	return x, nil
+}
+
+func (me *sqlParser) parseAdditive() (sqlExpr, error) {
+	x, err := me.parseMultiplicative()
+	for err == nil {
+		token := me.peek()
+		if token.kind != sqlSymbol || (token.text != "+" &&
+			token.text != "-" && token.text != "||") {
+			break
+		}
+		me.next()
+		var y sqlExpr
+		if y, err = me.parseMultiplicative(); err == nil {
+			x = &sqlBinary{op: token.text, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (me *sqlParser) parseMultiplicative() (sqlExpr, error) {
+	x, err := me.parseUnary()
+	for err == nil {
+		token := me.peek()
+		if token.kind != sqlSymbol || (token.text != "*" &&
+			token.text != "/" && token.text != "%") {
+			break
+		}
+		me.next()
+		var y sqlExpr
+		if y, err = me.parseUnary(); err == nil {
+			x = &sqlBinary{op: token.text, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (me *sqlParser) parseUnary() (sqlExpr, error) {
+	if me.accept(sqlSymbol, "-") {
+		if token := me.peek(); token.kind == sqlNumber {
+			me.next() // so that, e.g., -9223372036854775808 is an int
+			return me.parseNumber(token, "-")
+		}
+		x, err := me.parseUnary()
+		return &sqlUnary{"-", x}, err
+	}
+	me.accept(sqlSymbol, "+")
+	return me.parsePrimary()
+}
+
+// parseNumber returns the number token (with the given sign) as an int
+// literal if it has only digits, or as a real literal otherwise.
+func (me *sqlParser) parseNumber(token sqlToken,
+	sign string) (sqlExpr, error) {
+	text := sign + token.text
+	if !strings.ContainsAny(token.text, ".eE") {
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			me.pos--
+			return nil, me.errorf("int %s out of range", text)
+		}
+		return &sqlLiteral{i, IntField}, nil
+	}
+	r, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		me.pos--
+		return nil, me.errorf("invalid number %q", text)
+	}
+	return &sqlLiteral{r, RealField}, nil
+}
+
+func (me *sqlParser) parsePrimary() (sqlExpr, error) {
+	token := me.next()
+	switch token.kind {
+	case sqlNumber:
+		return me.parseNumber(token, "")
+	case sqlString:
+		return &sqlLiteral{token.text, StrField}, nil
+	case sqlKeyword:
+		switch token.text {
+		case "NULL":
+			return &sqlLiteral{nil, StrField}, nil
+		case "TRUE", "FALSE":
+			return &sqlLiteral{token.text == "TRUE", BoolField}, nil
+		case "DATE", "DATETIME":
+			return me.parseDateLiteral(token.text)
+		}
+	case sqlSymbol:
+		if token.text == "?" {
+			return me.parseArg()
+		}
+		if token.text == "(" {
+			x, err := me.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, me.expect(sqlSymbol, ")")
+		}
+	case sqlIdent:
+		if me.accept(sqlSymbol, "(") {
+			return me.parseCall(strings.ToUpper(token.text))
+		}
+		if me.accept(sqlSymbol, ".") {
+			name, err := me.ident()
+			return &sqlColumn{table: token.text, name: name}, err
+		}
+		return &sqlColumn{name: token.text}, nil
+	}
+	me.pos--
+	if token.kind == sqlEOF {
+		return nil, me.errorf("unexpected end of query")
+	}
+	return nil, me.errorf("unexpected %q", token.text)
+}
+
+// parseArg returns the next argument as a literal. The argument must be nil
+// or one of the Go types that Tdb uses (bool, []byte, int, float64, string,
+// or time.Time).
+func (me *sqlParser) parseArg() (sqlExpr, error) {
+	if me.used == len(me.args) {
+		me.pos--
+		return nil, me.errorf("missing argument %d", me.used+1)
+	}
+	value := me.args[me.used]
+	me.used++
+	if value == nil {
+		return &sqlLiteral{nil, StrField}, nil
+	}
+	for _, kind := range []FieldKind{BoolField, BytesField, DateTimeField,
+		IntField, RealField, StrField} {
+		if valueIsKind(value, kind) {
+			return &sqlLiteral{value, kind}, nil
+		}
+	}
+	me.pos--
+	return nil, me.errorf("argument %d has unsupported type %T", me.used,
+		value)
+}
+
+func (me *sqlParser) parseDateLiteral(what string) (sqlExpr, error) {
+	token := me.next()
+	if token.kind != sqlString {
+		me.pos--
+		return nil, me.errorf("expected a quoted %s", strings.ToLower(what))
+	}
+	format, kind := DateFormat, DateField
+	if what == "DATETIME" {
+		format, kind = DateTimeFormat, DateTimeField
+	}
+	d, err := time.Parse(format, token.text)
+	if err != nil {
+		me.pos--
+		return nil, me.errorf("invalid %s %q", strings.ToLower(what),
+			token.text)
+	}
+	return &sqlLiteral{d, kind}, nil
+}
+
+func (me *sqlParser) parseCall(name string) (sqlExpr, error) {
+	call := &sqlCall{name: name}
+	if name == "COUNT" && me.accept(sqlSymbol, "*") {
+		call.star = true
+		return call, me.expect(sqlSymbol, ")")
+	}
+	if !me.accept(sqlSymbol, ")") {
+		args, err := me.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		call.args = args
+		return call, me.expect(sqlSymbol, ")")
+	}
+	return call, nil
+}
@@ -1,5 +1,5 @@
 #!/bin/bash
-clc -s -e eg doc.go tdb_test.go tdb1_test.go tdb2_test.go tdb3_test.go
+clc -s -e eg doc.go tdb_test.go tdb1_test.go tdb2_test.go tdb3_test.go tdb4_test.go
 go mod tidy
 go fmt .
 staticcheck .
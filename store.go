@@ -0,0 +1,203 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Store is a Tdb that is read from and saved to a .tdb file, e.g.:
+//
+//	store, err := tdb.Open(filename)
+//	if err != nil { ... }
+//	defer store.Close()
+//	... // read or change store.Db
+//	err = store.Save()
+//
+// While a Store is open it holds an exclusive advisory lock on a
+// filename.lock file (which is left in place after Close), so other
+// processes that use a Store can't open the same file until it is closed.
+// (Locking is only supported on BSD, Linux, macOS, and Windows; on other
+// platforms [Open] returns an error.) Processes that don't use a Store aren't
+// prevented from reading or writing the file; nor are a [KV] or
+// [Config.Save], which don't take the lock.
+//
+// Small changes can be made without rewriting the whole .tdb file using
+// [Store.Insert], [Store.Update], and [Store.Delete], which append each
+// change to a filename.journal file. Each change is a one-record Tdb table
+// with the changed table's name and an op str field (insert, update, or
+// delete) followed by the table's fields (or, for deletes, just its first
+// field). Each change is preceded by a line holding the size (in bytes) and
+// CRC-32 (in hex) of its Tdb text, e.g.:
+//
+//	79 ffc3f32a
+//	[dept op str deptno int dname str loc str
+//	%
+//	<insert> 50 <MARKETING> <DENVER>
+//	]
+//	40 b390c6d7
+//	[dept op str deptno int
+//	%
+//	<delete> 40
+//	]
+//
+// These methods identify records by their first value, which is treated as
+// the table's primary key. Open replays the journal, so journalled changes
+// are never lost, and Save (or Compact) writes them into the .tdb file and
+// deletes the journal. If the journal's last change was only partly written
+// (e.g., because of a crash), it is ignored and removed. After adding a
+// table or changing a table's fields, call Save before journalling changes
+// to the table. Reals that are NaN or infinite can't be journalled.
+type Store struct {
+	Db       *Tdb
+	Filename string
+	Backup   bool // If true, Save keeps the previous file as filename.bak
+	lock     *os.File
+}
+
+// Open returns a Store for the given .tdb file with its Tdb read from the
+// file (with any journalled changes applied), or with an empty Tdb if the
+// file doesn't exist (in which case Save will create it). It is an error if
+// another Store has the file open.
+func Open(filename string) (*Store, error) {
+	lock, err := os.OpenFile(filename+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("e%d#%s:is in use by another process: %s",
+			e188, filename, err)
+	}
+	store := &Store{Filename: filename, lock: lock}
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		db := NewTdb()
+		store.Db = &db
+		return store, nil
+	}
+	if err == nil {
+		if store.Db, err = Parse(raw); err == nil {
+			err = store.replay()
+		}
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Save writes the Store's Tdb to its file atomically: the Tdb is written
+// to a temporary file that is synced to disk and then renamed over the
+// original, so a failed save leaves the original unchanged. If Backup is
+// true the original (if any) is kept as filename.bak (replacing any
+// previous backup). Any journal is deleted since its changes are now in
+// the file.
+func (me *Store) Save() error {
+	if me.lock == nil {
+		return fmt.Errorf("e%d#%s:can't save a closed store", e189,
+			me.Filename)
+	}
+	if err := writeFileAtomically(me.Filename, me.Backup,
+		me.Db.Write); err != nil {
+		return err
+	}
+	if err := os.Remove(me.journalName()); err != nil &&
+		!errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Close releases the Store's lock. It does not save the Tdb.
+func (me *Store) Close() error {
+	if me.lock == nil {
+		return nil
+	}
+	err := unlockFile(me.lock)
+	if closeErr := me.lock.Close(); err == nil {
+		err = closeErr
+	}
+	me.lock = nil
+	return err
+}
+
+// WriteFile writes the Tdb to the named file atomically, in the same way
+// as [Store.Save] (but without a backup, a lock, or a journal).
+func (me *Tdb) WriteFile(filename string) error {
+	return writeFileAtomically(filename, false, me.Write)
+}
+
+// writeFileAtomically calls write to write to a temporary file in the
+// same directory as filename, syncs it, and then renames the temporary file
+// to filename, so that filename is only replaced if the write succeeds. An
+// existing file's permissions are preserved, and if backup is true the
+// existing file is kept as filename.bak.
+func writeFileAtomically(filename string, backup bool,
+	write func(io.Writer) error) error {
+	mode := os.FileMode(0644)
+	info, err := os.Stat(filename)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+	exists := err == nil
+	file, err := os.CreateTemp(filepath.Dir(filename),
+		"."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	tempname := file.Name()
+	defer os.Remove(tempname) // fails harmlessly after a successful rename
+	if err = write(file); err == nil {
+		if err = file.Sync(); err == nil {
+			err = file.Chmod(mode)
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if backup && exists {
+		if err = backupFile(filename); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tempname, filename); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(filename)); err == nil {
+		dir.Sync() // make the rename durable; not supported everywhere
+		dir.Close()
+	}
+	return nil
+}
+
+// backupFile makes filename.bak a copy of filename (as a hard link if
+// possible, since filename is about to be replaced).
+func backupFile(filename string) error {
+	bakname := filename + ".bak"
+	if err := os.Remove(bakname); err != nil &&
+		!errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if os.Link(filename, bakname) == nil {
+		return nil
+	}
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(bakname, raw, info.Mode().Perm())
+}
@@ -0,0 +1,68 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import "sync"
+
+// SyncTdb is a Tdb that is safe to share between goroutines, e.g., between
+// HTTP handlers. Readers use [SyncTdb.Read] (or [SyncTdb.Snapshot] for a
+// copy of their own) and see a Tdb that is never changed; writers use
+// [SyncTdb.Update], which changes a copy of the Tdb and then replaces the
+// current Tdb with it, so readers never see a partly applied change.
+//
+// Since every update copies the whole Tdb, SyncTdb suits data that is read
+// often and changed rarely (such as configuration).
+type SyncTdb struct {
+	mutex  sync.RWMutex // guards db
+	update sync.Mutex   // serializes updates
+	db     *Tdb
+}
+
+// NewSyncTdb returns a SyncTdb that holds a copy of the given Tdb (or a new
+// empty Tdb if db is nil).
+func NewSyncTdb(db *Tdb) *SyncTdb {
+	if db == nil {
+		empty := NewTdb()
+		return &SyncTdb{db: &empty}
+	}
+	return &SyncTdb{db: db.Clone()}
+}
+
+// Snapshot returns a copy of the current Tdb. The copy shares nothing with
+// the SyncTdb, so it may be read or changed freely; but since copying takes
+// time, readers that don't need their own copy should use [SyncTdb.Read].
+func (me *SyncTdb) Snapshot() *Tdb {
+	return me.current().Clone()
+}
+
+// Read calls fn with the current Tdb and returns fn's error. The Tdb isn't
+// copied: it is shared with every other reader, so fn must not change it
+// (nor create indexes on its tables). It may be read without locking, even
+// after fn returns, since updates never change it but replace it instead.
+func (me *SyncTdb) Read(fn func(db *Tdb) error) error {
+	return fn(me.current())
+}
+
+func (me *SyncTdb) current() *Tdb {
+	me.mutex.RLock()
+	defer me.mutex.RUnlock()
+	return me.db
+}
+
+// Update calls fn with a copy of the current Tdb for fn to change. If fn
+// returns nil the copy becomes the current Tdb; otherwise the copy is
+// discarded and fn's error is returned. Updates are applied one at a time;
+// readers aren't blocked while fn runs. The copy's tables have no indexes.
+func (me *SyncTdb) Update(fn func(db *Tdb) error) error {
+	me.update.Lock()
+	defer me.update.Unlock()
+	db := me.current().Clone()
+	if err := fn(db); err != nil {
+		return err
+	}
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.db = db
+	return nil
+}
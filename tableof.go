@@ -0,0 +1,172 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"fmt"
+	"iter"
+	"os"
+	"reflect"
+)
+
+// TableOf is a typed table whose records are structs of type T. The
+// struct must follow the same conventions as the inner structs used by
+// [Marshal] and [Unmarshal] (e.g., pointer fields for nullable types, and a
+// `tdb:"date"` tag for time.Time fields that are dates).
+//
+// Use [TableOf.Table] to convert to a dynamic [Table] (e.g., to add to a
+// [Tdb] for writing), and [NewTableOfTable] to convert from one.
+type TableOf[T any] struct {
+	MetaTableType // table name and field names and kinds
+	records       []T
+	plan          *recordPlan
+}
+
+// NewTableOf returns a new empty [TableOf] with the given name whose field
+// names and kinds are taken from the struct type T.
+func NewTableOf[T any](tableName string) (*TableOf[T], error) {
+	plan, err := recordPlanOf[T](tableName)
+	if err != nil {
+		return nil, err
+	}
+	meta := MetaTableType{tableName,
+		make([]*MetaFieldType, 0, len(plan.fields))}
+	for _, field := range plan.fields {
+		meta.Fields = append(meta.Fields, &MetaFieldType{field.name,
+			field.kind, field.nullable})
+	}
+	return &TableOf[T]{meta, make([]T, 0), plan}, nil
+}
+
+// NewTableOfTable returns a new [TableOf] populated from the given [Table].
+// The table's fields must correspond (in order and kind) to the fields of
+// the struct type T, and each of the table's nullable fields must
+// correspond to a pointer field. It is an error if a record has the wrong
+// number of values or a value of the wrong Go type (see [Table.AppendRecord]).
+func NewTableOfTable[T any](table *Table) (*TableOf[T], error) {
+	plan, err := recordPlanOf[T](table.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTableOf(table, plan); err != nil {
+		return nil, err
+	}
+	typed := &TableOf[T]{table.MetaTableType.clone(),
+		make([]T, 0, len(table.Records)), plan}
+	for _, record := range table.Records {
+		if err = table.checkRecord(record); err != nil {
+			return nil, err
+		}
+		var value T
+		recVal := reflect.ValueOf(&value).Elem()
+		for column, x := range record {
+			if x != nil {
+				plan.fields[column].set(recVal.Field(column), x)
+			}
+		}
+		typed.records = append(typed.records, value)
+	}
+	return typed, nil
+}
+
+// ReadTableOf reads the named Tdb file and returns the table called
+// tableName as a [TableOf]. If tableName is "" the file's first table is
+// used.
+//
+// See also [NewTableOfTable].
+func ReadTableOf[T any](filename, tableName string) (*TableOf[T], error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	db, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if tableName == "" && len(db.TableNames) > 0 {
+		tableName = db.TableNames[0]
+	}
+	table, ok := db.Tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("e%d#%s:no such table in %s", e147,
+			tableName, filename)
+	}
+	return NewTableOfTable[T](table)
+}
+
+// Len returns the number of records in the table.
+func (me *TableOf[T]) Len() int {
+	return len(me.records)
+}
+
+// Rows returns an iterator over the table's records.
+func (me *TableOf[T]) Rows() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for _, record := range me.records {
+			if !yield(record) {
+				return
+			}
+		}
+	}
+}
+
+// Append adds the given records to the end of the table.
+func (me *TableOf[T]) Append(records ...T) {
+	me.records = append(me.records, records...)
+}
+
+// Table returns a new dynamic [Table] with the same name, fields and
+// records as this table.
+func (me *TableOf[T]) Table() *Table {
+	table := Table{me.MetaTableType.clone(),
+		make([]Record, 0, len(me.records)), nil}
+	for _, value := range me.records {
+		recVal := reflect.ValueOf(value)
+		record := newRecord(len(me.plan.fields))
+		for column, field := range me.plan.fields {
+			record[column] = field.get(recVal.Field(column))
+		}
+		table.Records = append(table.Records, record)
+	}
+	return &table
+}
+
+func recordPlanOf[T any](tableName string) (*recordPlan, error) {
+	recordType := reflect.TypeFor[T]()
+	if recordType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("e%d#%s:expected a struct type, got %s",
+			e148, tableName, recordType)
+	}
+	plan := recordPlanFor(recordType)
+	for i, field := range plan.fields {
+		if !field.ok || !field.exported {
+			return nil, fmt.Errorf("e%d#%s.%s:unusable field type %s",
+				e148, tableName, field.goName, recordType.Field(i).Type)
+		}
+	}
+	return plan, nil
+}
+
+func checkTableOf(table *Table, plan *recordPlan) error {
+	if len(table.Fields) != len(plan.fields) {
+		return fmt.Errorf("e%d#%s:table has %d fields, struct has %d",
+			e149, table.Name, len(table.Fields), len(plan.fields))
+	}
+	for column, field := range table.Fields {
+		fieldPlan := plan.fields[column]
+		timeKinds := DateField | DateTimeField // time.Time works for both
+		if fieldPlan.kind != field.Kind && !(fieldPlan.kind&timeKinds != 0 &&
+			field.Kind&timeKinds != 0) {
+			return fmt.Errorf("e%d#%s.%s:table field is %s, struct field "+
+				"%s is %s", e149, table.Name, field.Name, field.Kind,
+				fieldPlan.goName, fieldPlan.kind)
+		}
+		if field.AllowNull && !fieldPlan.nullable {
+			return fmt.Errorf("e%d#%s.%s:table field is nullable, struct "+
+				"field %s must be a pointer", e150, table.Name, field.Name,
+				fieldPlan.goName)
+		}
+	}
+	return nil
+}
@@ -25,11 +25,12 @@ func (me *Tdb) AddTable(table *Table) {
 type Table struct {
 	MetaTableType // table name and field names and kinds
 	Records       []Record
+	indexes       []*Index
 }
 
 func NewTable() Table {
 	return Table{MetaTableType{Fields: make([]*MetaFieldType, 0)},
-		make([]Record, 0)}
+		make([]Record, 0), nil}
 }
 
 type Record []any
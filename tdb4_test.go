@@ -0,0 +1,1882 @@
+package tdb_test
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//go:embed eg/classic.sqlite
+var ClassicSQLite []byte
+
+func TestTableOf(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emps, err := tdb.NewTableOfTable[Employee](db.Tables["emp"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emps.Len() != 14 {
+		t.Errorf("expected 14 employees, got %d", emps.Len())
+	}
+	total := 0.0
+	for emp := range emps.Rows() {
+		total += emp.Salary
+	}
+	if total != 29025 {
+		t.Errorf("expected total salary 29025, got %v", total)
+	}
+	emps.Append(Employee{EID: 8000, Name: "NEW", Job: "CLERK",
+		HireDate: date(2022, 1, 2), Salary: 900, DeptID: 40})
+	db.Tables["emp"] = emps.Table()
+	var out bytes.Buffer
+	if err = db.WriteDecimals(&out, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := Classic[:bytes.Index([]byte(Classic), []byte("]\n[dept"))] +
+		"8000 <NEW> <CLERK> ? 2022-01-02 900.0 ? 40\n"
+	if !bytes.HasPrefix(out.Bytes(), []byte(expected)) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	depts, err := tdb.NewTableOf[Department]("dept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := depts.String(); s != "[dept deptno int dname str loc str%]" {
+		t.Errorf("unexpected meta data %s", s)
+	}
+	if _, err = tdb.NewTableOfTable[Department](
+		db.Tables["emp"]); err == nil {
+		t.Error("expected a field mismatch error")
+	}
+	dept := db.Tables["dept"]
+	dept.Records[0][0] = int32(10)
+	if _, err = tdb.NewTableOfTable[Department](dept); err == nil {
+		t.Error("expected a value type error")
+	}
+	dept.Records[0] = tdb.Record{10, "ACCOUNTING", "NEW YORK", "X"}
+	if _, err = tdb.NewTableOfTable[Department](dept); err == nil {
+		t.Error("expected a record length error")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emp := db.Tables["emp"]
+	sal := emp.FieldIndex("sal")
+	rich := emp.Where(func(record tdb.Record) bool {
+		return record[sal].(float64) >= 2900
+	})
+	rich, err = rich.Select("ename", "sal", "deptno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rich, err = rich.OrderBy("ename", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rich, err = rich.OrderBy("sal", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rich = rich.Limit(4)
+	jobs, err := emp.Select("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err = jobs.Distinct().OrderBy("job", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.Name = "jobs"
+	out := tdb.NewTdb()
+	out.AddTable(rich)
+	out.AddTable(jobs)
+	var buf bytes.Buffer
+	if err = out.WriteDecimals(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[emp ename str sal real deptno int
+%
+<KING> 5000.0 10
+<FORD> 3000.0 20
+<SCOTT> 3000.0 20
+<JONES> 2975.0 20
+]
+[jobs job str
+%
+<ANALYST>
+<CLERK>
+<MANAGER>
+<PRESIDENT>
+<SALESMAN>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if _, err = emp.Select("salary"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	if len(emp.Records) != 14 || emp.Len() != 8 {
+		t.Error("expected the original table to be unchanged")
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emp := db.Tables["emp"]
+	totals, err := emp.GroupBy("deptno").Aggregate(tdb.Count(""),
+		tdb.Sum("sal").As("total"), tdb.Avg("comm"), tdb.Max("hiredate"),
+		tdb.Sum("empno"), tdb.Count("comm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals, err = totals.OrderBy("deptno", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := emp.Where(func(tdb.Record) bool {
+		return false
+	}).GroupBy().Aggregate(tdb.Count(""), tdb.Sum("sal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all.Name = "none"
+	out := tdb.NewTdb()
+	out.AddTable(totals)
+	out.AddTable(all)
+	var buf bytes.Buffer
+	if err = out.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[emp deptno int count int total real avg_comm real? max_hiredate date sum_empno int count_comm int
+%
+10 3 8750 ? 1982-01-23 23555 0
+20 5 10875 ? 1983-01-12 38501 0
+30 6 9400 550 1981-12-03 46116 4
+]
+[none count int sum_sal real?
+%
+0 ?
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	_, err = emp.GroupBy("deptno").Aggregate(tdb.Sum("ename"))
+	if err == nil {
+		t.Error("expected an error summing a str field")
+	}
+	_, err = emp.GroupBy("dept").Aggregate(tdb.Count(""))
+	if err == nil {
+		t.Error("expected an error grouping by a missing field")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	db, err := tdb.Parse([]byte(Db1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoices, err := db.Tables["Invoices"].Select("INUM", "CID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	customers, err := db.Tables["Customers"].Select("CID", "Company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := invoices.Join(customers, tdb.InnerJoin, "CID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := db.Tables["Items"].Select("LIID", "INUM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = items.AppendRecord(tdb.Record{1999, 999})
+	full, err := items.JoinOn(invoices, tdb.FullJoin, []string{"INUM"},
+		[]string{"INUM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = invoices.AppendRecord(tdb.Record{154, 77})
+	left, err := invoices.Join(customers, tdb.LeftJoin, "CID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	left.Name = "Left"
+	out := tdb.NewTdb()
+	out.AddTable(joined)
+	out.AddTable(full)
+	out.AddTable(left)
+	var buf bytes.Buffer
+	if err = out.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[Invoices_Customers Invoices_INUM int Invoices_CID int Customers_CID int Customers_Company str
+%
+152 50 50 <Best People>
+153 19 19 <Supersuppliers>
+]
+[Items_Invoices Items_LIID int? Items_INUM int? Invoices_INUM int? Invoices_CID int?
+%
+1839 152 152 50
+1840 152 152 50
+1620 153 153 19
+1999 999 ? ?
+]
+[Left Invoices_INUM int Invoices_CID int Customers_CID int? Customers_Company str?
+%
+152 50 50 <Best People>
+153 19 19 <Supersuppliers>
+154 77 ? ?
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if _, err = invoices.Join(customers, tdb.InnerJoin, "Company"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	if _, err = invoices.JoinOn(customers, tdb.InnerJoin,
+		[]string{"INUM"}, []string{"Company"}); err == nil {
+		t.Error("expected an error joining an int with a str")
+	}
+}
+
+func TestSQL(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := tdb.NewTdb()
+	for i, query := range []string{
+		`SELECT ename, sal, dname FROM emp JOIN dept USING (deptno)
+		WHERE sal > 2000 ORDER BY sal DESC`,
+		`SELECT dname, COUNT(*), SUM(sal) AS total, AVG(comm)
+		FROM emp e LEFT JOIN dept d ON e.deptno = d.deptno
+		GROUP BY dname HAVING COUNT(*) > 3 ORDER BY total`,
+		`SELECT * FROM dept FULL JOIN emp USING (deptno)
+		WHERE ename IS NULL`,
+		`SELECT DISTINCT job FROM emp ORDER BY 1 LIMIT 3 OFFSET 1`,
+	} {
+		table, err := tdb.Query(db, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table.Name = fmt.Sprintf("Q%d", i+1)
+		out.AddTable(table)
+	}
+	var buf bytes.Buffer
+	if err = out.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[Q1 ename str sal real dname str
+%
+<KING> 5000 <ACCOUNTING>
+<SCOTT> 3000 <RESEARCH>
+<FORD> 3000 <RESEARCH>
+<JONES> 2975 <RESEARCH>
+<BLAKE> 2850 <SALES>
+<CLARK> 2450 <ACCOUNTING>
+]
+[Q2 dname str? count int total real avg_comm real?
+%
+<SALES> 6 9400 550
+<RESEARCH> 5 10875 ?
+]
+[Q3 deptno int? dname str? loc str? empno int? ename str? job str? mgr int? hiredate date? sal real? comm real?
+%
+40 <OPERATIONS> <BOSTON> ? ? ? ? ? ? ?
+]
+[Q4 job str
+%
+<CLERK>
+<MANAGER>
+<PRESIDENT>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if _, err = tdb.Query(db, "SELECT nosuch FROM emp"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	if _, err = tdb.Query(db, "SELECT * FROM emp WHERE"); err == nil {
+		t.Error("expected a syntax error")
+	}
+	for _, query := range []string{
+		"SELECT deptno, COUNT(*) FROM emp GROUP BY deptno ORDER BY sal",
+		"SELECT COUNT(*) FROM emp ORDER BY LOWER(ename)",
+		"SELECT 9223372036854775808 FROM dept",
+		"SELECT 9223372036854775807 + deptno FROM dept",
+		"SELECT -(-9223372036854775808) FROM dept",
+		"SELECT ABS(-9223372036854775807 - 1) FROM dept",
+		"SELECT ename, COUNT(*) FROM emp GROUP BY deptno",
+		"SELECT deptno FROM emp GROUP BY deptno HAVING ename = 'KING'",
+		"SELECT ename, MAX(sal) FROM emp",
+		`SELECT e.ename, COALESCE(m.hiredate, 'none') AS h
+		FROM emp e LEFT JOIN emp m ON e.mgr = m.empno`,
+	} {
+		if _, err = tdb.Query(db, query); err == nil {
+			t.Errorf("expected an error for %s", query)
+		}
+	}
+	table, err := tdb.Query(db,
+		"SELECT COALESCE(comm, 0) AS c FROM emp WHERE empno = 7369")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := table.Records[0][0]; c != 0.0 ||
+		table.Fields[0].Kind != tdb.RealField || table.Fields[0].AllowNull {
+		t.Errorf("unexpected COALESCE result %v %v", c, *table.Fields[0])
+	}
+	table, err = tdb.Query(db, `SELECT -9223372036854775808 AS n, deptno
+		FROM emp GROUP BY deptno ORDER BY deptno + 1 DESC, MAX(sal)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := table.Records[0][0]; n != math.MinInt64 || table.Fields[0].Kind !=
+		tdb.IntField || table.Records[0][1] != 30 {
+		t.Errorf("unexpected result %v", table.Records)
+	}
+	table, err = tdb.Query(db, `SELECT hiredate || 'x' AS h, 'y' || hiredate
+		AS g FROM emp WHERE empno = 7369`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, g := table.Records[0][0], table.Records[0][1]; h != "1980-12-17x" ||
+		g != "y1980-12-17" {
+		t.Errorf("unexpected || results %v %v", h, g)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emp := db.Tables["emp"]
+	byEmpno, err := emp.CreateIndex("empno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDeptJob, err := emp.CreateIndex("deptno", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bySal, err := emp.CreateOrderedIndex("sal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = emp.CreateIndex("empno"); err == nil {
+		t.Error("expected an error for a duplicate index")
+	}
+	if _, err = emp.CreateIndex("nosuch"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	names := func(records []tdb.Record) string {
+		var names []string
+		for _, record := range records {
+			names = append(names, record[1].(string))
+		}
+		return strings.Join(names, " ")
+	}
+	check := func(what, expected, got string) {
+		t.Helper()
+		if got != expected {
+			t.Errorf("%s: expected %q, got %q", what, expected, got)
+		}
+	}
+	check("empno", "SCOTT", names(byEmpno.Lookup(7788)))
+	check("missing empno", "", names(byEmpno.Lookup(1)))
+	check("deptno job", "SMITH ADAMS", names(byDeptJob.Lookup(20, "CLERK")))
+	records, err := bySal.Range([]any{1250}, []any{1600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("sal range", "WARD MARTIN MILLER TURNER ALLEN", names(records))
+	if _, err = byEmpno.Range(nil, nil); err == nil {
+		t.Error("expected an error for Range on a hash index")
+	}
+
+	if err = emp.AppendRecord(tdb.Record{8000, "NEWBIE", "CLERK", 7902,
+		time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), 1300.0, nil,
+		20}); err != nil {
+		t.Fatal(err)
+	}
+	check("appended", "NEWBIE", names(byEmpno.Lookup(8000)))
+	check("appended deptno job", "SMITH ADAMS NEWBIE",
+		names(byDeptJob.Lookup(20, "CLERK")))
+	row := byEmpno.Rows(7369)[0]
+	if err = emp.UpdateValue(row, "job", "ANALYST"); err != nil {
+		t.Fatal(err)
+	}
+	check("updated", "ADAMS NEWBIE", names(byDeptJob.Lookup(20, "CLERK")))
+	if err = emp.UpdateValue(row, "sal", 1500.0); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = bySal.Range([]any{1300}, []any{1500})
+	check("updated sal range", "MILLER NEWBIE SMITH TURNER", names(records))
+	emp.DeleteRecords(func(record tdb.Record) bool {
+		return record[2] == "SALESMAN"
+	})
+	records, _ = bySal.Range(nil, []any{1500})
+	check("after delete", "JAMES ADAMS MILLER NEWBIE SMITH", names(records))
+	check("after delete empno", "KING", names(byEmpno.Lookup(7839)))
+	if err = emp.DropColumn("job"); err != nil {
+		t.Fatal(err)
+	}
+	if byDeptJob.Fields() != nil || byDeptJob.Lookup(20, "CLERK") != nil {
+		t.Error("expected the deptno job index to be dropped")
+	}
+	check("after drop", "FORD", names(byEmpno.Lookup(7902)))
+	if !emp.DropIndex(byEmpno) || emp.DropIndex(byEmpno) {
+		t.Error("expected the empno index to be dropped just once")
+	}
+	copied := *emp
+	if copied.DropIndex(bySal) {
+		t.Error("expected a copy to have no indexes")
+	}
+	if err = copied.AppendRecord(tdb.Record{8001, "COPY", 7902,
+		time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC), 1400.0, nil,
+		20}); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = bySal.Range([]any{1400}, []any{1400})
+	check("after copy append", "", names(records))
+	if _, err = copied.CreateOrderedIndex("sal"); err != nil {
+		t.Fatal(err)
+	}
+	if !emp.DropIndex(bySal) {
+		t.Error("expected the original's sal index to be kept")
+	}
+}
+
+func TestExec(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		statement string
+		args      []any
+		count     int
+	}{
+		{"INSERT INTO dept VALUES (50, 'DEV', 'LEEDS'), (60, ?, ?)",
+			[]any{"OPS", "YORK"}, 2},
+		{`INSERT INTO emp (empno, ename, job, hiredate, sal, deptno)
+		VALUES (8000, 'NEWBIE', 'CLERK', '2022-05-01', 900, 50)`, nil, 1},
+		{"UPDATE emp SET sal = sal + 100, comm = 0 WHERE deptno = ?",
+			[]any{50}, 1},
+		{"DELETE FROM emp WHERE hiredate < ?",
+			[]any{time.Date(1981, 12, 1, 0, 0, 0, 0, time.UTC)}, 9},
+	} {
+		count, err := tdb.Exec(db, test.statement, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != test.count {
+			t.Errorf("%s: expected %d, got %d", test.statement, test.count,
+				count)
+		}
+	}
+	table, err := tdb.Query(db, "SELECT ename, sal, comm, dname FROM emp "+
+		"JOIN dept USING (deptno) WHERE sal < ?", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := tdb.NewTdb()
+	out.AddTable(table)
+	var buf bytes.Buffer
+	if err = out.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[emp_dept ename str sal real comm real? dname str
+%
+<ADAMS> 1100 ? <RESEARCH>
+<JAMES> 950 ? <SALES>
+<MILLER> 1300 ? <ACCOUNTING>
+<NEWBIE> 1000 0 <DEV>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	for _, statement := range []string{
+		"INSERT INTO dept VALUES (70, 'X', 'Y'), (80, 'Z', NULL)",
+		"UPDATE emp SET sal = ename",
+		"DELETE FROM emp WHERE ename = ? AND job = ?",
+		"SELECT * FROM emp",
+	} {
+		var args []any
+		if strings.Contains(statement, "?") {
+			args = append(args, "KING") // one too few
+		}
+		if _, err = tdb.Exec(db, statement, args...); err == nil {
+			t.Errorf("%s: expected an error", statement)
+		}
+	}
+	if len(db.Tables["dept"].Records) != 6 {
+		t.Error("expected a failed insert to leave the table unchanged")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	data := `id,Unit Price,zip,when,ok,note,stamp
+1,2.5,01234,2022-01-02,yes,hello,2022-01-02 10:11:12
+2,,02345,2022-02-03,no,"a, b",2022-01-03T00:00:00
+`
+	table, err := tdb.FromCSV(strings.NewReader(data),
+		tdb.CSVOptions{TableName: "items", EmptyIsNull: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := tdb.FromCSV(strings.NewReader(data),
+		tdb.CSVOptions{TableName: "typed", Types: map[string]string{
+			"id": "real", "Unit Price": "str", "zip": "int"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, err := tdb.FromCSV(strings.NewReader("1;T\n2;F\n"),
+		tdb.CSVOptions{NoHeader: true, Comma: ';'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := tdb.NewTdb()
+	db.AddTable(table)
+	db.AddTable(typed)
+	db.AddTable(bare)
+	var buf bytes.Buffer
+	if err = db.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[items id int Unit_Price real? zip str when date ok bool note str stamp datetime
+%
+1 2.5 <01234> 2022-01-02 T <hello> 2022-01-02T10:11:12
+2 ? <02345> 2022-02-03 F <a, b> 2022-01-03T00:00:00
+]
+[typed id real Unit_Price str zip int when date ok bool note str stamp datetime
+%
+1 <2.5> 1234 2022-01-02 T <hello> 2022-01-02T10:11:12
+2 <> 2345 2022-02-03 F <a, b> 2022-01-03T00:00:00
+]
+[Table Field1 int Field2 bool
+%
+1 T
+2 F
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	if err = table.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = `id,Unit_Price,zip,when,ok,note,stamp
+1,2.5,01234,2022-01-02,T,hello,2022-01-02T10:11:12
+2,,02345,2022-02-03,F,"a, b",2022-01-03T00:00:00
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	again, err := tdb.FromCSV(&buf, tdb.CSVOptions{TableName: "items",
+		EmptyIsNull: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, field := range again.Fields {
+		if *field != *table.Fields[i] {
+			t.Errorf("expected %v, got %v", *table.Fields[i], *field)
+		}
+	}
+	if _, err = tdb.FromCSV(strings.NewReader(data), tdb.CSVOptions{
+		Types: map[string]string{"note": "int"}}); err == nil {
+		t.Error("expected an error converting a str to an int")
+	}
+	if _, err = tdb.FromCSV(strings.NewReader(data), tdb.CSVOptions{
+		Types: map[string]string{"nosuch": "int"}}); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	table, err = tdb.FromCSV(strings.NewReader("x\n1.5\nNaN\n"),
+		tdb.CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Fields[0].Kind != tdb.StrField {
+		t.Errorf("expected NaN to make a str field, got %s",
+			table.Fields[0].Kind)
+	}
+}
+
+func TestInfer(t *testing.T) {
+	for _, test := range []struct {
+		values        []string
+		minConfidence float64
+		typeName      string
+		confidence    float64
+		invalid       int
+	}{
+		{[]string{"yes", "No", "T", "f"}, 0, "bool", 1, 0},
+		{[]string{"1", "0", "", "-17"}, 0, "int?", 1, 0},
+		{[]string{"1", "2.5", "-3e2"}, 0, "real", 1, 0},
+		{[]string{"01234", "12345"}, 0, "str", 1, 0},
+		{[]string{"1.5", "NaN"}, 0, "str", 1, 0},
+		{[]string{"1.5", "inf", "-Infinity"}, 0, "str", 1, 0},
+		{[]string{"1_000", "2.5"}, 0, "str", 1, 0},
+		{[]string{"1.5", "0x1p-2", "1e400"}, 0, "str", 1, 0},
+		{[]string{"2022-01-02", " 2021-12-31 "}, 0, "date", 1, 0},
+		{[]string{"2022-01-02", "2022-01-02 10:11:12", ""}, 0,
+			"datetime?", 1, 0},
+		{[]string{"1", "2", "3", "x"}, 0, "str", 1, 0},
+		{[]string{"1", "2", "3", "x"}, 0.75, "int", 0.75, 1},
+		{[]string{"", " "}, 0, "str?", 0, 0},
+	} {
+		inference := tdb.InferKind(test.values, test.minConfidence)
+		if inference.TypeName() != test.typeName ||
+			inference.Confidence != test.confidence ||
+			inference.Invalid != test.invalid {
+			t.Errorf("%q: expected %s %g %d, got %s %g %d", test.values,
+				test.typeName, test.confidence, test.invalid,
+				inference.TypeName(), inference.Confidence,
+				inference.Invalid)
+		}
+	}
+	table, inferences := tdb.InferTable("my data",
+		[]string{"id", "Unit Price"}, [][]string{{"1", "2.5"}, {"2"}}, 1)
+	db := tdb.NewTdb()
+	db.AddTable(table)
+	var buf bytes.Buffer
+	if err := db.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[my_data id int Unit_Price real?\n%\n]\n" {
+		t.Errorf("unexpected table definition %q", got)
+	}
+	if len(inferences) != 2 || !inferences[1].AllowNull {
+		t.Errorf("unexpected inferences %v", inferences)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	db, err := tdb.Parse([]byte(`[items id int name str? pic bytes when date ok bool
+%
+1 <A &amp; B> (00FF) 2022-01-02 T
+2 ? () 2022-02-03 F
+]
+[empty x real %]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"name":"items","fields":[{"name":"id","type":"int"},` +
+		`{"name":"name","type":"str?"},{"name":"pic","type":"bytes"},` +
+		`{"name":"when","type":"date"},{"name":"ok","type":"bool"}],` +
+		`"rows":[[1,"A \u0026 B","AP8=","2022-01-02",true],` +
+		`[2,null,"","2022-02-03",false]]},` +
+		`{"name":"empty","fields":[{"name":"x","type":"real"}],"rows":[]}]`
+	if string(raw) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, raw)
+	}
+	var buf bytes.Buffer
+	table := db.Tables["items"]
+	if err = table.WriteNDJSON(&buf, tdb.JSONOptions{HexBytes: true}); err !=
+		nil {
+		t.Fatal(err)
+	}
+	expected = `{"id": 1, "name": "A & B", "pic": "00ff", "when": "2022-01-02", "ok": true}
+{"id": 2, "name": null, "pic": "", "when": "2022-02-03", "ok": false}
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	for _, data := range []string{
+		`[{"id": 1, "when": "2022-01-02", "note": "", "score": 1.5,
+		   "tags": ["a", "b"]},
+		  {"id": 2, "when": null, "note": "x", "ok": true, "score": ""}]`,
+		`{"id": 1, "when": "2022-01-02", "note": "", "score": 1.5,
+		  "tags": ["a", "b"]}
+		 {"id": 2, "when": null, "note": "x", "ok": true, "score": ""}`,
+	} {
+		table, err := tdb.FromJSON(strings.NewReader(data), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := tdb.NewTdb()
+		db.AddTable(table)
+		buf.Reset()
+		if err = db.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		expected := `[Table id int when date? note str score real? tags str? ok bool?
+%
+1 2022-01-02 <> 1.5 <["a","b"]> ?
+2 ? <x> ? ? T
+]
+`
+		if buf.String() != expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+		}
+	}
+	for _, data := range []string{`[1, 2]`, `{"a": 1`, `[]`, `[{"a": 1}] x`} {
+		if _, err = tdb.FromJSON(strings.NewReader(data), "t"); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+}
+
+func TestWriteSQL(t *testing.T) {
+	db, err := tdb.Parse([]byte(`[odd id int name str? pic bytes? when date
+stamp datetime ok bool x real
+%
+1 <O'Neil> (00FF) 2022-01-02 2022-01-02T10:11:12 T 1.5
+2 ? ? 2022-02-03 2022-02-03T00:00:00 F -1e300
+3 <> () 2022-03-04 2022-03-04T05:06:07 T 0.0
+]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = db.WriteSQL(&buf, tdb.SQLOptions{BatchSize: 2}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `BEGIN;
+CREATE TABLE "odd" (
+    "id" INTEGER NOT NULL,
+    "name" TEXT,
+    "pic" BLOB,
+    "when" DATE NOT NULL,
+    "stamp" DATETIME NOT NULL,
+    "ok" BOOLEAN NOT NULL,
+    "x" REAL NOT NULL
+);
+INSERT INTO "odd" ("id", "name", "pic", "when", "stamp", "ok", "x") VALUES
+(1, 'O''Neil', X'00ff', '2022-01-02', '2022-01-02T10:11:12', 1, 1.5),
+(2, NULL, NULL, '2022-02-03', '2022-02-03T00:00:00', 0, -1e+300);
+INSERT INTO "odd" ("id", "name", "pic", "when", "stamp", "ok", "x") VALUES
+(3, '', X'', '2022-03-04', '2022-03-04T05:06:07', 1, 0);
+COMMIT;
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	table := db.Tables["odd"]
+	table.Records = table.Records[:1]
+	table.Records[0][6] = math.Inf(-1)
+	if err = table.WriteSQL(&buf, tdb.SQLOptions{
+		Dialect: tdb.Postgres}); err != nil {
+		t.Fatal(err)
+	}
+	expected = `CREATE TABLE "odd" (
+    "id" BIGINT NOT NULL,
+    "name" TEXT,
+    "pic" BYTEA,
+    "when" DATE NOT NULL,
+    "stamp" TIMESTAMP NOT NULL,
+    "ok" BOOLEAN NOT NULL,
+    "x" DOUBLE PRECISION NOT NULL
+);
+INSERT INTO "odd" ("id", "name", "pic", "when", "stamp", "ok", "x") VALUES
+(1, 'O''Neil', '\x00ff', '2022-01-02', '2022-01-02T10:11:12', TRUE, '-Infinity');
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	table.Records[0][6] = math.NaN()
+	if err = table.WriteSQL(&buf, tdb.SQLOptions{}); err == nil {
+		t.Error("expected an error writing NaN for SQLite")
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	// eg/classic.sqlite is made by eg/classic_sqlite.sh, which runs tdb
+	// export sql on eg/classic.tdb through sqlite3 and then adds the misc
+	// items table (which has an INTEGER PRIMARY KEY, a VIRTUAL column, and
+	// a column added by ALTER TABLE) and the numbers table (which has 1000
+	// rows, so spans several pages, and a value that overflows its page).
+	db, err := tdb.ParseSQLite(ClassicSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers := db.Tables["numbers"]
+	delete(db.Tables, "numbers")
+	db.TableNames = db.TableNames[:len(db.TableNames)-1]
+	classic, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = classic.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := buf.String() + `[misc_items id int Unit_Price real flag bool? stamp datetime? pic bytes? note str? anything str? extra str?
+%
+1 2.5 T 2022-01-02T10:11:12 (00ff) <hello> <7> ?
+2 3 F ? ? <it's> <text> ?
+5 4.25 ? 2022-03-04T05:06:07 () ? ? <added>
+]
+`
+	buf.Reset()
+	if err = db.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if numbers == nil || len(numbers.Records) != 1000 {
+		t.Fatal("expected a numbers table with 1000 records")
+	}
+	total := 0
+	for _, record := range numbers.Records {
+		total += record[1].(int)
+	}
+	if total != 333833500 {
+		t.Errorf("expected a total of 333833500, got %d", total)
+	}
+	if big := numbers.Records[499][2]; big != strings.Repeat("x", 10000) {
+		t.Errorf("expected 10000 x's, got %v", big)
+	}
+	if db, err = tdb.ParseSQLite(nil); err != nil || len(db.TableNames) != 0 {
+		t.Errorf("expected an empty Tdb, got %v %v", db, err)
+	}
+	corrupt := bytes.Replace(ClassicSQLite, []byte("NULL, big"),
+		[]byte("NULL,,big"), 1) // an empty column definition
+	for _, raw := range [][]byte{[]byte("SQLite format 2\x00"),
+		ClassicSQLite[:len(ClassicSQLite)/2], corrupt} {
+		if _, err = tdb.ParseSQLite(raw); err == nil {
+			t.Error("expected an error for an invalid SQLite database")
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	db, err := tdb.Parse([]byte(`[notes id int name str? note str ok bool
+%
+1 <日本> <a &lt;b&gt; | c> T
+22 ? <first line
+second line is longer> F
+]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := db.Tables["notes"]
+	var buf bytes.Buffer
+	if err = table.RenderWidth(&buf, tdb.Markdown, 12); err != nil {
+		t.Fatal(err)
+	}
+	expected := `|  id | name | note             | ok  |
+| --: | ---- | ---------------- | --- |
+|   1 | 日本 | a &lt;b&gt; \| c | T   |
+|  22 |      | first line<br>s… | F   |
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	if err = table.Render(&buf, tdb.HTML); err != nil {
+		t.Fatal(err)
+	}
+	expected = `<table>
+<thead>
+<tr><th>id</th><th>name</th><th>note</th><th>ok</th></tr>
+</thead>
+<tbody>
+<tr><td style="text-align: right">1</td><td>日本</td><td>a &lt;b&gt; | c</td><td>T</td></tr>
+<tr><td style="text-align: right">22</td><td></td><td>first line<br>second line is longer</td><td>F</td></tr>
+</tbody>
+</table>
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	if err = table.RenderWidth(&buf, tdb.Grid, 12); err != nil {
+		t.Fatal(err)
+	}
+	expected = `┌────┬──────┬─────────────┬────┐
+│ id │ name │ note        │ ok │
+├────┼──────┼─────────────┼────┤
+│  1 │ 日本 │ a <b> | c   │ T  │
+├────┼──────┼─────────────┼────┤
+│ 22 │      │ first line  │ F  │
+│    │      │ second line │    │
+│    │      │ is longer   │    │
+└────┴──────┴─────────────┴────┘
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestConfig(t *testing.T) {
+	ini := `symbols=latin
+[Window]
+x=32
+y=28
+; a comment
+[Colors]
+foreground=lightyellow
+background=#FFE7FF
+`
+	config, err := tdb.ConfigFromINI(strings.NewReader(ini))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = config.Db.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[config_int group str? key str value int
+%
+<Window> <x> 32
+<Window> <y> 28
+]
+[config_str group str? key str value str
+%
+? <symbols> <latin>
+<Colors> <foreground> <lightyellow>
+<Colors> <background> <#FFE7FF>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if x, ok := config.GetInt("Window", "x"); !ok || x != 32 {
+		t.Errorf("expected 32 true, got %d %t", x, ok)
+	}
+	if s, ok := config.GetStr("", "symbols"); !ok || s != "latin" {
+		t.Errorf("expected latin true, got %q %t", s, ok)
+	}
+	if _, ok := config.GetStr("Window", "x"); ok {
+		t.Error("expected x not to be a str")
+	}
+	if err = config.Set("Window", "x", "wide"); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []float64{math.NaN(), math.Inf(1)} {
+		if err = config.Set("Window", "ratio", r); err == nil {
+			t.Errorf("expected an error setting %v", r)
+		}
+	}
+	if err = config.Set("Window", "y", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err = config.Set("", "scale", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if err = config.Set("", "ok", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = config.Set("", "bad", []int{1}); err == nil {
+		t.Error("expected an error setting a slice")
+	}
+	if !config.Delete("Colors", "background") ||
+		config.Delete("Colors", "background") {
+		t.Error("expected background to be deleted once")
+	}
+	if groups := config.Groups(); fmt.Sprint(groups) != "[ Window Colors]" {
+		t.Errorf("unexpected groups %q", groups)
+	}
+	buf.Reset()
+	if err = config.WriteINI(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = `ok=true
+scale=1.5
+symbols=latin
+
+[Window]
+y=30
+x=wide
+
+[Colors]
+foreground=lightyellow
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	if err = config.WriteTOML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = `ok = true
+scale = 1.5
+symbols = "latin"
+
+[Window]
+y = 30
+x = "wide"
+
+[Colors]
+foreground = "lightyellow"
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	filename := filepath.Join(t.TempDir(), "settings.tdb")
+	saved, err := tdb.ReadConfig(filename) // doesn't exist yet
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.Db = config.Db
+	if err = saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := tdb.ReadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := reread.GetReal("", "scale"); !ok || r != 1.5 {
+		t.Errorf("expected 1.5 true, got %g %t", r, ok)
+	}
+	if err = tdb.NewConfig(nil).Save(); err == nil {
+		t.Error("expected an error saving without a filename")
+	}
+	if _, err = tdb.ConfigFromINI(strings.NewReader("x\n")); err == nil {
+		t.Error("expected an error for an invalid INI line")
+	}
+}
+
+func TestTOML(t *testing.T) {
+	toml := `# settings
+title = "TOML \"test\"\t\u00E9"
+count = 1_000
+mask = 0xFF
+ratio = 6.5e-1
+path = 'C:\Users'
+text = """
+line one \
+    still one
+line two"""
+born = 1979-05-27 07:32:00Z
+
+[window] # a table
+"the width" = 640
+a.b = false
+[window.inner]
+depth = -2.5
+`
+	config, err := tdb.ConfigFromTOML(strings.NewReader(toml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = config.WriteTOML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `count = 1000
+mask = 255
+ratio = 0.65
+title = "TOML \"test\"\té"
+path = "C:\\Users"
+text = "line one still one\nline two"
+born = "1979-05-27 07:32:00Z"
+
+[window.a]
+b = false
+
+[window]
+"the width" = 640
+
+[window.inner]
+depth = -2.5
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	again, err := tdb.ConfigFromTOML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := again.GetStr("", "text"); !ok ||
+		s != "line one still one\nline two" {
+		t.Errorf("unexpected text %q %t", s, ok)
+	}
+	for _, bad := range []string{"x = [1, 2]", "[[items]]", "x = 1 2",
+		`x = "open`, "x = 012", "= 1", "x = nan", "x = -inf",
+		"x = 1e400"} {
+		if _, err = tdb.ConfigFromTOML(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestKV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "store.tdb")
+	kv, err := tdb.OpenKV(filename) // doesn't exist yet
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := kv.Len(); err != nil || n != 0 {
+		t.Errorf("expected 0 <nil>, got %d %v", n, err)
+	}
+	for _, pair := range []struct {
+		key   string
+		value any
+	}{{"name", "Alice"}, {"age", 42}, {"admin", true},
+		{"scale", 1.5}, {"key", []byte{0, 0xFF}},
+		{"seen", time.Date(2022, 10, 19, 8, 30, 0, 0, time.UTC)},
+		{"age", 43}} {
+		if err = kv.Put(pair.key, pair.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = kv.Put("bad", uint(1)); err == nil {
+		t.Error("expected an error putting a uint")
+	}
+	for _, r := range []float64{math.NaN(), math.Inf(-1)} {
+		if err = kv.Put("bad", r); err == nil {
+			t.Errorf("expected an error putting %v", r)
+		}
+	}
+	if _, err = tdb.OpenKV(filename); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := kv.Delete("scale"); err != nil || !deleted {
+		t.Errorf("expected true <nil>, got %t %v", deleted, err)
+	}
+	if deleted, err := kv.Delete("scale"); err != nil || deleted {
+		t.Errorf("expected false <nil>, got %t %v", deleted, err)
+	}
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[kv key str bool_value bool? bytes_value bytes? date_value date? datetime_value datetime? int_value int? real_value real? str_value str?
+%
+<admin> T ? ? ? ? ? ?
+<age> ? ? ? ? 43 ? ?
+<key> ? (00ff) ? ? ? ? ?
+<name> ? ? ? ? ? ? <Alice>
+<seen> ? ? ? 2022-10-19T08:30:00 ? ? ?
+]
+`
+	if string(raw) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, raw)
+	}
+	if value, ok, err := kv.Get("age"); err != nil || !ok || value != 43 {
+		t.Errorf("expected 43 true <nil>, got %v %t %v", value, ok, err)
+	}
+	if _, ok, _ := kv.Get("scale"); ok {
+		t.Error("expected scale to have been deleted")
+	}
+	keys, err := kv.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(keys, " "); s != "admin age key name seen" {
+		t.Errorf("unexpected keys %q", s)
+	}
+	names := make([]string, 0)
+	for key, value := range kv.All() {
+		names = append(names, fmt.Sprintf("%s=%v", key, value))
+		if key == "key" {
+			break
+		}
+	}
+	if s := strings.Join(names, " "); s != "admin=true age=43 key=[0 255]" {
+		t.Errorf("unexpected iteration %q", s)
+	}
+	// Hand edit the file: the KV must notice and reload.
+	edited := strings.Replace(expected, "<age> ? ? ? ? 43",
+		"<age> ? ? ? ? 44", 1)
+	edited = strings.Replace(edited, "<key>",
+		"<born> ? ? 1980-01-02 ? ? ? ?\n<key>", 1)
+	if err = os.WriteFile(filename, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err = os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := kv.Get("age"); value != 44 {
+		t.Errorf("expected 44 after reload, got %v", value)
+	}
+	if value, _, _ := kv.Get("born"); value !=
+		time.Date(1980, 1, 2, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("expected 1980-01-02 after reload, got %v", value)
+	}
+	reopened, err := tdb.OpenKV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := reopened.Len(); err != nil || n != 6 {
+		t.Errorf("expected 6 <nil>, got %d %v", n, err)
+	}
+	if err = os.WriteFile(filename, []byte("[kv key int\n%\n]\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tdb.OpenKV(filename); err == nil {
+		t.Error("expected an error for an invalid kv table")
+	}
+}
+
+func TestStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "classic.tdb")
+	store, err := tdb.Open(filename) // doesn't exist yet
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Db.TableNames) != 0 {
+		t.Errorf("expected an empty Tdb, got %v", store.Db.TableNames)
+	}
+	if store.Db, err = tdb.Parse([]byte(Classic)); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tdb.Open(filename); err == nil &&
+		runtime.GOOS == "linux" {
+		t.Error("expected an error opening a locked file")
+	}
+	store.Backup = true
+	first, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Db.TableNames = store.Db.TableNames[:1]
+	if err = store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if bak, err := os.ReadFile(filename + ".bak"); err != nil ||
+		!bytes.Equal(bak, first) {
+		t.Errorf("expected the backup to be the first save %v", err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(); err == nil {
+		t.Error("expected an error saving a closed store")
+	}
+	reopened, err := tdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if len(reopened.Db.TableNames) != 1 {
+		t.Errorf("expected 1 table, got %v", reopened.Db.TableNames)
+	}
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) >= len(first) {
+		t.Errorf("expected a shorter file, got:\n%s", raw)
+	}
+	if err = os.Chmod(filename, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = reopened.Db.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filename); err != nil ||
+		info.Mode().Perm() != 0600 {
+		t.Errorf("expected WriteFile to keep the file's mode %v", err)
+	}
+}
+
+func TestSyncTdb(t *testing.T) {
+	db, err := tdb.Parse([]byte("[a n int\n%\n]\n[b n int\n%\n]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := tdb.NewSyncTdb(db)
+	first := shared.Snapshot()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() { // each update appends to both tables
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				if err := shared.Update(func(db *tdb.Tdb) error {
+					for _, name := range []string{"a", "b"} {
+						if err := db.Tables[name].AppendRecord(
+							tdb.Record{n}); err != nil {
+							return err
+						}
+					}
+					return nil
+				}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() { // so readers must always see equal lengths
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				shared.Read(func(db *tdb.Tdb) error {
+					a, b := db.Tables["a"], db.Tables["b"]
+					if len(a.Records) != len(b.Records) {
+						t.Errorf("saw a partial update: %d != %d",
+							len(a.Records), len(b.Records))
+					}
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(shared.Snapshot().Tables["a"].Records); n != 200 {
+		t.Errorf("expected 200 records, got %d", n)
+	}
+	if n := len(first.Tables["a"].Records); n != 0 {
+		t.Errorf("expected the first snapshot to be unchanged, got %d", n)
+	}
+	if n := len(db.Tables["a"].Records); n != 0 {
+		t.Errorf("expected the original Tdb to be unchanged, got %d", n)
+	}
+	before := shared.Snapshot()
+	before.Tables["b"].Records = nil // doesn't affect shared
+	err = shared.Update(func(db *tdb.Tdb) error {
+		db.Tables["a"].Records = nil
+		return fmt.Errorf("abandoned")
+	})
+	shared.Read(func(db *tdb.Tdb) error {
+		if err == nil || len(db.Tables["a"].Records) != 200 ||
+			len(db.Tables["b"].Records) != 200 {
+			t.Errorf("expected a failed update and a changed snapshot "+
+				"to change nothing: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "classic.tdb")
+	if err := os.WriteFile(filename, []byte(Classic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := tdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Insert("dept", tdb.Record{50, "MARKETING",
+		"DENVER"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Insert("dept", tdb.Record{50, "X", "Y"}); err == nil {
+		t.Error("expected an error inserting a duplicate key")
+	}
+	if err = store.Update("dept", tdb.Record{50, "MARKETING",
+		"AUSTIN"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update("dept", tdb.Record{99, "X", "Y"}); err == nil {
+		t.Error("expected an error updating a missing key")
+	}
+	if deleted, err := store.Delete("dept", 40); err != nil || !deleted {
+		t.Errorf("expected true <nil>, got %t %v", deleted, err)
+	}
+	if err = store.Insert("nosuch", tdb.Record{1}); err == nil {
+		t.Error("expected an error for a missing table")
+	}
+	raw, err := os.ReadFile(filename + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `79 ffc3f32a
+[dept op str deptno int dname str loc str
+%
+<insert> 50 <MARKETING> <DENVER>
+]
+79 aeda06d6
+[dept op str deptno int dname str loc str
+%
+<update> 50 <MARKETING> <AUSTIN>
+]
+40 b390c6d7
+[dept op str deptno int
+%
+<delete> 40
+]
+`
+	if string(raw) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, raw)
+	}
+	var buf bytes.Buffer
+	if err = store.Db.Tables["dept"].Render(&buf, tdb.Markdown); err != nil {
+		t.Fatal(err)
+	}
+	depts := buf.String()
+	if saved, _ := os.ReadFile(filename); string(saved) != Classic {
+		t.Error("expected the .tdb file to be unchanged")
+	}
+	for _, sal := range []float64{math.NaN(), math.Inf(1)} {
+		if err = store.Update("emp", tdb.Record{7369, "SMITH", "CLERK",
+			7902, time.Date(1980, 12, 17, 0, 0, 0, 0, time.UTC), sal, nil,
+			20}); err == nil {
+			t.Errorf("expected an error journalling %v", sal)
+		}
+	}
+	// Simulate a crash part way through appending a change whose str
+	// looks like the start of another entry.
+	if err = store.Insert("dept", tdb.Record{60, "x\n[y",
+		"LEEDS"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if raw, _ = os.ReadFile(filename + ".journal"); len(raw) !=
+		len(expected)+85 {
+		t.Fatalf("unexpected journal:\n%s", raw)
+	}
+	if err = os.WriteFile(filename+".journal", raw[:len(raw)-3],
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = tdb.Open(filename); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = store.Db.Tables["dept"].Render(&buf, tdb.Markdown); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != depts {
+		t.Errorf("expected replayed:\n%s\ngot:\n%s", depts, buf.String())
+	}
+	if raw, _ = os.ReadFile(filename + ".journal"); string(raw) !=
+		expected {
+		t.Errorf("expected the torn change to be removed, got:\n%s", raw)
+	}
+	if err = store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filename + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("expected the journal to be deleted: %v", err)
+	}
+	store.Close()
+	if store, err = tdb.Open(filename); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.Db.Tables["dept"].Records); n != 4 {
+		t.Errorf("expected 4 depts, got %d", n)
+	}
+	entry := func(text string) string {
+		return fmt.Sprintf("%d %08x\n%s", len(text),
+			crc32.ChecksumIEEE([]byte(text)), text)
+	}
+	store.Close()
+	deleteDept := entry("[dept op str deptno int\n%\n<delete> 10\n]\n")
+	for _, journal := range []string{
+		deleteDept + entry("[nosuch op str x int\n%\n<delete> 1\n]\n"),
+		strings.Replace(deleteDept, "> 10", "> 20", 1) + deleteDept,
+		"x\n" + deleteDept,
+	} {
+		if err = os.WriteFile(filename+".journal", []byte(journal),
+			0644); err != nil {
+			t.Fatal(err)
+		}
+		if store, err = tdb.Open(filename); err == nil {
+			store.Close()
+			t.Errorf("expected an error for journal:\n%s", journal)
+		}
+	}
+	// A header with a huge size is treated as a torn last entry.
+	if err = os.WriteFile(filename+".journal",
+		[]byte(deleteDept+"9223372036854775807 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = tdb.Open(filename); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if n := len(store.Db.Tables["dept"].Records); n != 3 {
+		t.Errorf("expected 3 depts, got %d", n)
+	}
+	if raw, _ = os.ReadFile(filename + ".journal"); string(raw) !=
+		deleteDept {
+		t.Errorf("expected the oversized entry to be removed, got:\n%s",
+			raw)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	db, err := tdb.Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := db.Clone()
+	if !db.Equal(clone, tdb.EqualOptions{}) {
+		t.Error("expected a clone to be equal")
+	}
+	emp := clone.Tables["emp"]
+	emp.Records[0], emp.Records[1] = emp.Records[1], emp.Records[0]
+	if db.Equal(clone, tdb.EqualOptions{}) {
+		t.Error("expected records in a different order to differ")
+	}
+	if !db.Equal(clone, tdb.EqualOptions{IgnoreRecordOrder: true}) {
+		t.Error("expected equality ignoring record order")
+	}
+	clone.TableNames[0], clone.TableNames[1] = clone.TableNames[1],
+		clone.TableNames[0]
+	if db.Equal(clone, tdb.EqualOptions{IgnoreRecordOrder: true}) {
+		t.Error("expected tables in a different order to differ")
+	}
+	if !db.Equal(clone, tdb.EqualOptions{IgnoreTableOrder: true,
+		IgnoreRecordOrder: true}) {
+		t.Error("expected equality ignoring table and record order")
+	}
+	emp.Records[0][5] = emp.Records[0][5].(float64) + 0.001
+	opts := tdb.EqualOptions{IgnoreTableOrder: true,
+		IgnoreRecordOrder: true}
+	if db.Equal(clone, opts) {
+		t.Error("expected a changed real to differ")
+	}
+	opts.Tolerance = 0.01
+	if !db.Equal(clone, opts) {
+		t.Error("expected a changed real within tolerance to be equal")
+	}
+	emp.Records[0][1] = "X"
+	if db.Equal(clone, opts) {
+		t.Error("expected a changed str to differ")
+	}
+	if db.Tables["emp"].Records[1][1] != "ALLEN" {
+		t.Error("expected changes to a clone not to affect the original")
+	}
+	x, err := tdb.Parse([]byte("[T r real s str\n%\n1.00 <b> 1.01 <a>\n]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := tdb.Parse([]byte("[T r real s str\n%\n1.005 <a> 1.006 <b>\n]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.Equal(y, opts) {
+		t.Error("expected reals within tolerance in any order to be equal")
+	}
+	a, err := tdb.Parse([]byte("[T b bytes d datetime r real?\n%\n" +
+		"() 2022-10-19T08:30:00 ?\n]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := a.Clone()
+	record := b.Tables["T"].Records[0]
+	record[0] = []byte(nil)
+	record[1] = time.Date(2022, 10, 19, 9, 30, 0, 0,
+		time.FixedZone("BST", 3600))
+	if !a.Equal(b, tdb.EqualOptions{}) {
+		t.Error("expected nil and empty bytes and the same instant to be " +
+			"equal")
+	}
+	a.Tables["T"].Records[0][2] = math.NaN()
+	if a.Equal(b, tdb.EqualOptions{}) {
+		t.Error("expected NaN not to equal null")
+	}
+	record[2] = math.NaN()
+	if !a.Equal(b, tdb.EqualOptions{}) {
+		t.Error("expected NaN to equal NaN")
+	}
+	b.Tables["T"].Fields[2].AllowNull = false
+	if a.Equal(b, tdb.EqualOptions{}) {
+		t.Error("expected different fields to differ")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, err := tdb.Parse([]byte(`[emp empno int ename str job str mgr int?
+%
+7369 <SMITH> <CLERK> 7902
+7499 <ALLEN> <SALESMAN> 7698
+7521 <WARD> <SALESMAN> 7698
+]
+[dept deptno int
+%
+10
+]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tdb.Parse([]byte(`[emp empno int ename str job str mgr int bonus real?
+%
+7499 <ALLEN> <MANAGER> 7698 ?
+7369 <SMITH> <CLERK> 7902 5.5
+9999 <NEW> <CLERK> 7902 ?
+]
+[bonus id int
+%
+1
+]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := tdb.Diff(a, a.Clone()); !diff.Empty() {
+		t.Errorf("expected no differences, got %v", diff)
+	}
+	diff, err := tdb.DiffKeys(a, b, map[string][]string{"emp": {"empno"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = diff.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `+ table bonus
+- table dept
+~ table emp
+  + field bonus real?
+  ~ field mgr int? -> int
+  - 7521 <WARD> <SALESMAN> 7698
+  + 9999 <NEW> <CLERK> 7902 ?
+  ~ 7499: job <SALESMAN> -> <MANAGER>
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	if err = diff.Tdb().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = `[diff_tables table str change str
+%
+<bonus> <added>
+<dept> <removed>
+]
+[diff_fields table str field str from str? to str?
+%
+<emp> <bonus> ? <real?>
+<emp> <mgr> <int?> <int>
+]
+[emp_inserted empno int ename str job str mgr int bonus real?
+%
+9999 <NEW> <CLERK> 7902 ?
+]
+[emp_deleted empno int ename str job str mgr int?
+%
+7521 <WARD> <SALESMAN> 7698
+]
+[diff_values table str key str? row int field str from str to str
+%
+<emp> <7499> 0 <job> <&lt;SALESMAN&gt;> <&lt;MANAGER&gt;>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	if err = tdb.Diff(a, b).Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = `+ table bonus
+- table dept
+~ table emp
+  + field bonus real?
+  ~ field mgr int? -> int
+  ~ row 0: empno 7369 -> 7499, ename <SMITH> -> <ALLEN>, job <CLERK> -> <MANAGER>, mgr 7902 -> 7698
+  ~ row 1: empno 7499 -> 7369, ename <ALLEN> -> <SMITH>, job <SALESMAN> -> <CLERK>, mgr 7698 -> 7902
+  ~ row 2: empno 7521 -> 9999, ename <WARD> -> <NEW>, job <SALESMAN> -> <CLERK>, mgr 7698 -> 7902
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if _, err = tdb.DiffKeys(a, b, map[string][]string{
+		"emp": {"nosuch"}}); err == nil {
+		t.Error("expected an error for a missing key field")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	parse := func(text string) *tdb.Tdb {
+		db, err := tdb.Parse([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	base := parse(`[emp empno int ename str job str sal real
+%
+1 <ANN> <CLERK> 800
+2 <BOB> <CLERK> 900
+3 <CAT> <ANALYST> 3000
+4 <DAN> <CLERK> 950
+]
+[dept deptno int dname str
+%
+10 <ACCOUNTING>
+]
+`)
+	ours := parse(`[emp empno int ename str job str sal real
+%
+1 <ANN> <MANAGER> 800
+2 <BOB> <CLERK> 1000
+3 <CAT> <ANALYST> 3000
+5 <EVE> <CLERK> 700
+]
+[dept deptno int dname str
+%
+10 <ACCOUNTING>
+]
+`)
+	theirs := parse(`[emp empno int ename str job str sal real bonus real?
+%
+1 <ANN> <CLERK> 850 ?
+2 <BOB> <CLERK> 1100 ?
+4 <DAN> <CLERK> 950 ?
+6 <FAY> <CLERK> 750 100
+]
+[dept deptno int dname str
+%
+10 <ACCOUNTING>
+]
+[loc id int
+%
+1
+]
+`)
+	merged, conflicts, err := tdb.Merge(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = merged.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[emp empno int ename str job str sal real bonus real?
+%
+1 <ANN> <MANAGER> 850 ?
+2 <BOB> <CLERK> 1000 ?
+5 <EVE> <CLERK> 700 ?
+6 <FAY> <CLERK> 750 100
+]
+[dept deptno int dname str
+%
+10 <ACCOUNTING>
+]
+[loc id int
+%
+1
+]
+[tdb_conflicts table str key str? field str? base str? ours str? theirs str?
+%
+<emp> <2> <sal> <900> <1000> <1100>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if len(conflicts) != 1 || conflicts[0] != (tdb.MergeConflict{"emp",
+		"2", "sal", "900", "1000", "1100"}) {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+	// Ours deletes a record that theirs changes: it's kept.
+	theirs.Tables["emp"].Records[2][2] = "MANAGER" // DAN, deleted by ours
+	theirs.Tables["emp"].Records[1][3] = 1000.0    // BOB, as ours
+	merged, conflicts, err = tdb.Merge(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != (tdb.MergeConflict{"emp",
+		"4", "", "4 <DAN> <CLERK> 950 ?", "",
+		"4 <DAN> <MANAGER> 950 ?"}) {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+	if n := len(merged.Tables["emp"].Records); n != 5 {
+		t.Errorf("expected 5 emps, got %d", n)
+	}
+	if merged, conflicts, err = tdb.Merge(base, ours, ours); err != nil ||
+		len(conflicts) != 0 || !merged.Equal(ours, tdb.EqualOptions{}) {
+		t.Errorf("expected merging ours with ours to give ours: %v %v",
+			conflicts, err)
+	}
+	if _, _, err = tdb.MergeKeys(base, ours, ours, map[string][]string{
+		"emp": {"nosuch"}}); err == nil {
+		t.Error("expected an error for a missing key field")
+	}
+}
+
+func TestWriteCanonical(t *testing.T) {
+	db, err := tdb.Parse([]byte(`[z b bytes r real s str
+%
+(000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2021) 800 <two
+lines>
+(ff) 1.5 <x>
+]
+[a n int
+%
+3
+1
+]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	opts := tdb.CanonicalOptions{SortTables: true, SortRecords: true}
+	if err = db.WriteCanonical(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[a n int
+%
+1
+3
+]
+[z b bytes r real s str
+%
+(000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+2021) 800.0 <two
+lines>
+(ff) 1.5 <x>
+]
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if db.TableNames[0] != "z" || db.Tables["a"].Records[0][0] != 3 {
+		t.Error("expected WriteCanonical not to change the Tdb")
+	}
+	again, err := tdb.Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equal(db, tdb.EqualOptions{IgnoreTableOrder: true,
+		IgnoreRecordOrder: true}) {
+		t.Error("expected canonical output to read back the same")
+	}
+	var rewritten bytes.Buffer
+	if err = again.WriteCanonical(&rewritten, opts); err != nil {
+		t.Fatal(err)
+	}
+	if rewritten.String() != expected {
+		t.Errorf("expected canonical output to be stable, got:\n%s",
+			rewritten.String())
+	}
+	buf.Reset()
+	if err = db.WriteCanonical(&buf, tdb.CanonicalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "[z b bytes") ||
+		!strings.HasSuffix(buf.String(), "[a n int\n%\n3\n1\n]\n") {
+		t.Errorf("expected unsorted output, got:\n%s", buf.String())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -479,3 +480,198 @@ func TestE130(t *testing.T) {
 	err := Unmarshal(raw, &db)
 	expectError(e130, err, t)
 }
+
+func TestPlanCache(t *testing.T) {
+	type Rec struct {
+		Small int32
+		Count *uint16
+		Ratio float32
+	}
+	type DBA struct {
+		Recs []Rec
+	}
+	data := "[Recs Small int Count int? Ratio real\n%\n-7 3 0.5\n8 ? 1.25\n]\n"
+	recType := reflect.TypeOf(Rec{})
+	if recordPlanFor(recType) != recordPlanFor(recType) {
+		t.Error("expected the cached record plan to be reused")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db := DBA{}
+			if err := Unmarshal([]byte(data), &db); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			raw, err := Marshal(db)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			compare("PlanCache", raw, data, t)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAccessors(t *testing.T) {
+	db, err := Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emp := db.Tables["emp"]
+	if i := emp.FieldIndex("sal"); i != 5 {
+		t.Errorf("expected sal at 5, got %d", i)
+	}
+	if i := emp.FieldIndex("salary"); i != -1 {
+		t.Errorf("expected -1 for missing field, got %d", i)
+	}
+	sal, err := emp.Get(8, "sal")
+	if err != nil || sal != 5000.0 {
+		t.Errorf("expected 5000, got %v (%v)", sal, err)
+	}
+	_, err = emp.Get(14, "sal")
+	expectError(e151, err, t)
+	_, err = emp.Get(0, "salary")
+	expectError(e152, err, t)
+	record := emp.Records[0]
+	if name, err := record.Str(1); err != nil || name != "SMITH" {
+		t.Errorf("expected SMITH, got %q (%v)", name, err)
+	}
+	if eid, err := record.Int(0); err != nil || eid != 7369 {
+		t.Errorf("expected 7369, got %d (%v)", eid, err)
+	}
+	if d, err := record.Date(4); err != nil || d.Year() != 1980 {
+		t.Errorf("expected 1980, got %v (%v)", d, err)
+	}
+	_, err = record.Int(1)
+	expectError(e153, err, t)
+	_, err = record.Real(6)
+	expectError(e154, err, t)
+	if !record.IsNull(6) {
+		t.Error("expected comm to be null")
+	}
+	emp.Records[0][5] = float32(800)
+	_, err = emp.Get(0, "sal")
+	expectError(e153, err, t)
+}
+
+func TestMutations(t *testing.T) {
+	db, err := Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dept := db.Tables["dept"]
+	if err = dept.AppendRecord(Record{50, "MARKETING", "MIAMI"}); err != nil {
+		t.Error(err)
+	}
+	err = dept.AppendRecord(Record{int32(60), "LEGAL", "DENVER"})
+	expectError(e156, err, t)
+	err = dept.AppendRecord(Record{60, "LEGAL"})
+	expectError(e155, err, t)
+	err = dept.AppendRecord(Record{60, nil, "DENVER"})
+	expectError(e156, err, t)
+	if err = dept.InsertRecord(0, Record{5, "BOARD", "NEW YORK"}); err != nil {
+		t.Error(err)
+	}
+	err = dept.InsertRecord(9, Record{6, "X", "Y"})
+	expectError(e151, err, t)
+	if err = dept.UpdateValue(1, "loc", "BROOKLYN"); err != nil {
+		t.Error(err)
+	}
+	err = dept.UpdateValue(1, "deptno", 1.5)
+	expectError(e156, err, t)
+	err = dept.UpdateValue(1, "place", "X")
+	expectError(e152, err, t)
+	emp := db.Tables["emp"]
+	if err = emp.UpdateValue(0, "comm", nil); err != nil {
+		t.Error(err)
+	}
+	deleted := dept.DeleteRecords(func(record Record) bool {
+		return record[0].(int) >= 40
+	})
+	if deleted != 2 {
+		t.Errorf("expected 2 deletions, got %d", deleted)
+	}
+	var out bytes.Buffer
+	if err = db.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	compare("Mutations", out.Bytes()[bytes.Index(out.Bytes(),
+		[]byte("[dept")):], `[dept deptno int dname str loc str
+%
+5 <BOARD> <NEW YORK>
+10 <ACCOUNTING> <BROOKLYN>
+20 <RESEARCH> <DALLAS>
+30 <SALES> <CHICAGO>
+]
+`, t)
+}
+
+func TestSchemaChanges(t *testing.T) {
+	db, err := Parse([]byte(Classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dept := db.Tables["dept"]
+	if err = dept.AddColumn("budget", "int", 1000); err != nil {
+		t.Error(err)
+	}
+	err = dept.AddColumn("budget", "int", 1000)
+	expectError(e159, err, t)
+	err = dept.AddColumn("str", "int", 1000)
+	expectError(e158, err, t)
+	err = dept.AddColumn("head", "str", nil)
+	expectError(e156, err, t)
+	if err = dept.ChangeColumnType("budget", "real"); err != nil {
+		t.Error(err)
+	}
+	if err = dept.ChangeColumnType("deptno", "str"); err != nil {
+		t.Error(err)
+	}
+	err = dept.ChangeColumnType("dname", "int")
+	expectError(e161, err, t)
+	if err = dept.RenameColumn("loc", "location"); err != nil {
+		t.Error(err)
+	}
+	if err = dept.DropColumn("dname"); err != nil {
+		t.Error(err)
+	}
+	if err = dept.SetNullable("location", true); err != nil {
+		t.Error(err)
+	}
+	emp := db.Tables["emp"]
+	err = emp.SetNullable("comm", false)
+	expectError(e161, err, t)
+	if err = emp.ChangeColumnType("hiredate", "str"); err != nil {
+		t.Error(err)
+	}
+	if err = emp.ChangeColumnType("hiredate", "datetime"); err != nil {
+		t.Error(err)
+	}
+	if err = db.RenameTable("dept", "departments"); err != nil {
+		t.Error(err)
+	}
+	err = db.RenameTable("emp", "departments")
+	expectError(e159, err, t)
+	err = db.DropTable("dept")
+	expectError(e157, err, t)
+	if err = db.DropTable("emp"); err != nil {
+		t.Error(err)
+	}
+	var out bytes.Buffer
+	if err = db.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	compare("SchemaChanges", out.Bytes(),
+		`[departments deptno str location str? budget real
+%
+<10> <NEW YORK> 1000
+<20> <DALLAS> 1000
+<30> <CHICAGO> 1000
+<40> <BOSTON> 1000
+]
+`, t)
+}
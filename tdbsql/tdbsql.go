@@ -0,0 +1,282 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+/*
+Tdbsql is a database/sql driver for Tdb “Text DataBase” files. It registers
+itself as "tdb", so import it for its side effect and then open a .tdb file
+using [sql.Open]:
+
+	import (
+		"database/sql"
+		_ "github.com/mark-summerfield/tdb-go/tdbsql"
+	)
+
+	db, err := sql.Open("tdb", "data.tdb")
+	rows, err := db.Query("SELECT ename, sal FROM emp WHERE deptno = ?", 20)
+
+Queries and statements use the SQL subset supported by [tdb.Query] and
+[tdb.Exec] and may use ? placeholders.
+
+Tdb values map to [driver.Value]s as follows: bool to bool, bytes to
+[]byte, date and datetime to time.Time, int to int64, real to float64, str
+to string, and null to nil (SQL NULL).
+
+The file is read (using [tdb.Parse]) when first opened and is then shared
+by all the connections to it in the process. Every successful INSERT,
+UPDATE, or DELETE outside a transaction, and every transaction commit, is
+written back to the file atomically (using [tdb.Tdb.WriteFile], which
+writes a temporary file that is then renamed over the original). A
+transaction holds an exclusive lock on the database until it is committed
+or rolled back; a rollback rereads the file. The driver assumes that no
+other process writes to the file while it is open.
+*/
+package tdbsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	tdb "github.com/mark-summerfield/tdb-go"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func init() {
+	sql.Register("tdb", &Driver{})
+}
+
+// Driver is the database/sql driver for Tdb files.
+type Driver struct{}
+
+var (
+	databasesMutex sync.Mutex
+	databases      = make(map[string]*database) // key is absolute filename
+)
+
+// database is a Tdb file shared by all its connections.
+type database struct {
+	mutex    sync.Mutex // held for each operation or for a transaction
+	filename string
+	db       *tdb.Tdb
+}
+
+// Open opens the given .tdb file.
+func (me *Driver) Open(filename string) (driver.Conn, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	databasesMutex.Lock()
+	defer databasesMutex.Unlock()
+	data, ok := databases[filename]
+	if !ok {
+		data = &database{filename: filename}
+		if err := data.read(); err != nil {
+			return nil, err
+		}
+		databases[filename] = data
+	}
+	return &conn{data: data}, nil
+}
+
+func (me *database) read() error {
+	raw, err := os.ReadFile(me.filename)
+	if err != nil {
+		return err
+	}
+	db, err := tdb.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse %q: %w", me.filename, err)
+	}
+	me.db = db
+	return nil
+}
+
+// save writes the database; if this fails, the database is reread so that
+// it matches the file.
+func (me *database) save() error {
+	err := me.write()
+	if err != nil {
+		if readErr := me.read(); readErr != nil {
+			err = errors.Join(err, readErr)
+		}
+	}
+	return err
+}
+
+// write writes the database to its file atomically, so that the original
+// is only replaced if the write succeeds.
+func (me *database) write() error {
+	return me.db.WriteFile(me.filename)
+}
+
+type conn struct {
+	data *database
+	tx   bool // true while this connection has an open transaction
+}
+
+func (me *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{me, query}, nil
+}
+
+func (me *conn) Close() error {
+	if me.tx {
+		return me.rollback()
+	}
+	return nil
+}
+
+func (me *conn) Begin() (driver.Tx, error) {
+	return me.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (me *conn) BeginTx(_ context.Context, opts driver.TxOptions) (
+	driver.Tx, error) {
+	if me.tx {
+		return nil, errors.New("a transaction is already open")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("read-only transactions aren't supported")
+	}
+	me.data.mutex.Lock()
+	me.tx = true
+	return me, nil
+}
+
+func (me *conn) Commit() error {
+	if !me.tx {
+		return errors.New("no transaction is open")
+	}
+	defer me.endTx()
+	return me.data.save()
+}
+
+func (me *conn) Rollback() error {
+	if !me.tx {
+		return errors.New("no transaction is open")
+	}
+	return me.rollback()
+}
+
+func (me *conn) rollback() error {
+	defer me.endTx()
+	return me.data.read()
+}
+
+func (me *conn) endTx() {
+	me.tx = false
+	me.data.mutex.Unlock()
+}
+
+// lock locks the database for a single operation unless a transaction is
+// open (in which case it is already locked); the returned func unlocks.
+func (me *conn) lock() func() {
+	if me.tx {
+		return func() {}
+	}
+	me.data.mutex.Lock()
+	return me.data.mutex.Unlock
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (me *stmt) Close() error { return nil }
+
+// NumInput returns -1 since the placeholders are counted when executing.
+func (me *stmt) NumInput() int { return -1 }
+
+func (me *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	unlock := me.conn.lock()
+	defer unlock()
+	count, err := tdb.Exec(me.conn.data.db, me.query,
+		fromValues(args)...)
+	if err != nil {
+		return nil, err
+	}
+	if !me.conn.tx && count > 0 {
+		if err = me.conn.data.save(); err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(count), nil
+}
+
+func (me *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	unlock := me.conn.lock()
+	defer unlock()
+	table, err := tdb.Query(me.conn.data.db, me.query,
+		fromValues(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{table: table}, nil
+}
+
+// fromValues returns the given values converted to the Go types that Tdb
+// uses.
+func fromValues(values []driver.Value) []any {
+	args := make([]any, 0, len(values))
+	for _, value := range values {
+		if i, ok := value.(int64); ok {
+			args = append(args, int(i))
+		} else {
+			args = append(args, value)
+		}
+	}
+	return args
+}
+
+type rows struct {
+	table *tdb.Table
+	row   int
+}
+
+func (me *rows) Columns() []string {
+	names := make([]string, 0, len(me.table.Fields))
+	for _, field := range me.table.Fields {
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+func (me *rows) Close() error { return nil }
+
+func (me *rows) Next(dest []driver.Value) error {
+	if me.row >= len(me.table.Records) {
+		return io.EOF
+	}
+	for i, value := range me.table.Records[me.row] {
+		if n, ok := value.(int); ok {
+			dest[i] = int64(n)
+		} else {
+			dest[i] = value
+		}
+	}
+	me.row++
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the column's Tdb typename in
+// uppercase, e.g., "INT" or "DATE".
+func (me *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(me.table.Fields[index].Kind.String())
+}
+
+func (me *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return me.table.Fields[index].AllowNull, true
+}
+
+// guarantee that the optional interfaces are implemented
+var (
+	_ driver.ConnBeginTx                    = &conn{}
+	_ driver.RowsColumnTypeDatabaseTypeName = &rows{}
+	_ driver.RowsColumnTypeNullable         = &rows{}
+)
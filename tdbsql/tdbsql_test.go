@@ -0,0 +1,134 @@
+package tdbsql_test
+
+import (
+	"database/sql"
+	_ "github.com/mark-summerfield/tdb-go/tdbsql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDriver(t *testing.T) {
+	raw, err := os.ReadFile("../eg/classic.tdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "classic.tdb")
+	if err = os.WriteFile(filename, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("tdb", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT ename, sal, comm, hiredate FROM emp
+		JOIN dept USING (deptno) WHERE dname = ? ORDER BY sal DESC`, "SALES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := types[3].DatabaseTypeName(); name != "DATE" {
+		t.Errorf("expected DATE, got %s", name)
+	}
+	if nullable, ok := types[2].Nullable(); !nullable || !ok {
+		t.Error("expected comm to be nullable")
+	}
+	var names []string
+	var comms int
+	for rows.Next() {
+		var ename string
+		var sal float64
+		var comm sql.NullFloat64
+		var hired time.Time
+		if err = rows.Scan(&ename, &sal, &comm, &hired); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, ename)
+		if comm.Valid {
+			comms++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, " "); got !=
+		"BLAKE ALLEN TURNER WARD MARTIN JAMES" {
+		t.Errorf("unexpected names %q", got)
+	}
+	if comms != 4 {
+		t.Errorf("expected 4 commissions, got %d", comms)
+	}
+
+	result, err := db.Exec("INSERT INTO dept VALUES (?, ?, ?)", 50, "DEV",
+		"LEEDS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		t.Errorf("expected 1 row affected, got %d", n)
+	}
+	raw, err = os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "50 <DEV> <LEEDS>") {
+		t.Error("expected the insert to be written to the file")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("DELETE FROM emp WHERE deptno = 30"); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err = tx.QueryRow("SELECT COUNT(*) FROM emp").Scan(
+		&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 8 {
+		t.Errorf("expected 8 employees in the transaction, got %d", count)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.QueryRow("SELECT COUNT(*) FROM emp").Scan(
+		&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 14 {
+		t.Errorf("expected 14 employees after rollback, got %d", count)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("UPDATE emp SET comm = NULL"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var nulls int
+	if err = db.QueryRow(
+		"SELECT COUNT(*) FROM emp WHERE comm IS NULL").Scan(
+		&nulls); err != nil {
+		t.Fatal(err)
+	}
+	if nulls != 14 {
+		t.Errorf("expected 14 null commissions, got %d", nulls)
+	}
+
+	if _, err = db.Exec("UPDATE emp SET sal = NULL"); err == nil {
+		t.Error("expected an error setting a not null field to null")
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright © 2022 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package tdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ConfigFromTOML reads a TOML file from r and returns it as a [Config]
+// (with no filename). Keys before the first [table] are ungrouped, and
+// other keys are in a group named after their table (e.g., [window] or
+// [a.b]). Dotted keys are grouped too, so a.b.c = 1 is key c in group a.b.
+//
+// Bools go in the config_bool table, integers in config_int, floats in
+// config_real, and strings in config_str. Dates, datetimes, and times are
+// stored as strs holding their TOML text. Arrays, inline tables, arrays of
+// tables, and the floats nan and inf aren't supported.
+func ConfigFromTOML(r io.Reader) (*Config, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("e%d#failed to read TOML: %s", e183, err)
+	}
+	if !utf8.Valid(raw) {
+		return nil, fmt.Errorf("e%d#TOML must be UTF-8", e183)
+	}
+	parser := tomlParser{text: string(raw), lino: 1}
+	entries, err := parser.parse()
+	if err != nil {
+		return nil, fmt.Errorf("e%d#%d:invalid TOML: %s", e183, parser.lino,
+			err)
+	}
+	return newConfigFromEntries(entries), nil
+}
+
+// WriteTOML writes the Config to out in TOML format with the ungrouped keys
+// first and each group as a [table]. Keys and table name parts are quoted
+// if they aren't bare TOML keys.
+func (me *Config) WriteTOML(out io.Writer) error {
+	writer := bufio.NewWriter(out)
+	group := ""
+	for i, entry := range me.groupedEntries() {
+		if entry.group != group {
+			if i > 0 {
+				writer.WriteByte('\n')
+			}
+			group = entry.group
+			parts := strings.Split(group, ".")
+			for j, part := range parts {
+				parts[j] = tomlKey(part)
+			}
+			writer.WriteString("[" + strings.Join(parts, ".") + "]\n")
+		}
+		writer.WriteString(tomlKey(entry.key) + " = " +
+			tomlValue(entry.value) + "\n")
+	}
+	return writer.Flush()
+}
+
+// tomlKey returns the given key as is if it is a bare key, or quoted.
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for i := 0; i < len(key); i++ {
+		if !isTOMLBareKeyChar(key[i]) {
+			return tomlString(key)
+		}
+	}
+	return key
+}
+
+// tomlValue returns the given config value as TOML.
+func tomlValue(value any) string {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "nan"
+		case math.IsInf(v, 1):
+			return "inf"
+		case math.IsInf(v, -1):
+			return "-inf"
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0" // e.g., 2.0 so that it is read back as a float
+		}
+		return s
+	}
+	return tomlString(value.(string))
+}
+
+// tomlString returns s as a TOML basic string.
+func tomlString(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '\r':
+			out.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7F {
+				fmt.Fprintf(&out, `\u%04X`, c)
+			} else {
+				out.WriteRune(c)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+// tomlParser parses the subset of TOML that maps to a Config.
+type tomlParser struct {
+	text string
+	pos  int
+	lino int
+}
+
+func (me *tomlParser) parse() ([]configEntry, error) {
+	entries := make([]configEntry, 0)
+	group := ""
+	for {
+		me.skipBlankLines()
+		if me.pos >= len(me.text) {
+			return entries, nil
+		}
+		if me.text[me.pos] == '[' {
+			if strings.HasPrefix(me.text[me.pos:], "[[") {
+				return nil, errors.New("arrays of tables aren't supported")
+			}
+			me.pos++
+			parts, err := me.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if !me.accept("]") {
+				return nil, errors.New("expected ] after table name")
+			}
+			group = strings.Join(parts, ".")
+		} else {
+			parts, err := me.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if !me.accept("=") {
+				return nil, errors.New("expected = after key")
+			}
+			entry := configEntry{group: group, key: parts[len(parts)-1]}
+			if len(parts) > 1 {
+				dotted := strings.Join(parts[:len(parts)-1], ".")
+				if group != "" {
+					dotted = group + "." + dotted
+				}
+				entry.group = dotted
+			}
+			if entry.value, entry.kind, err = me.parseValue(); err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		if err := me.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipBlankLines skips whitespace, comments, and newlines.
+func (me *tomlParser) skipBlankLines() {
+	for me.pos < len(me.text) {
+		switch me.text[me.pos] {
+		case ' ', '\t', '\r':
+			me.pos++
+		case '\n':
+			me.pos++
+			me.lino++
+		case '#':
+			me.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (me *tomlParser) skipComment() {
+	if i := strings.IndexByte(me.text[me.pos:], '\n'); i > -1 {
+		me.pos += i
+	} else {
+		me.pos = len(me.text)
+	}
+}
+
+func (me *tomlParser) skipSpaces() {
+	for me.pos < len(me.text) && (me.text[me.pos] == ' ' ||
+		me.text[me.pos] == '\t') {
+		me.pos++
+	}
+}
+
+// accept skips spaces and then the given text if it is next and returns
+// true, or returns false.
+func (me *tomlParser) accept(text string) bool {
+	me.skipSpaces()
+	if strings.HasPrefix(me.text[me.pos:], text) {
+		me.pos += len(text)
+		return true
+	}
+	return false
+}
+
+// endOfLine skips spaces and any comment and the newline (or end of text).
+func (me *tomlParser) endOfLine() error {
+	me.skipSpaces()
+	if me.pos < len(me.text) && me.text[me.pos] == '#' {
+		me.skipComment()
+	}
+	if strings.HasPrefix(me.text[me.pos:], "\r\n") {
+		me.pos++
+	}
+	if me.pos < len(me.text) && me.text[me.pos] != '\n' {
+		return fmt.Errorf("unexpected %q", me.rest())
+	}
+	return nil
+}
+
+// rest returns the rest of the current line (for error messages).
+func (me *tomlParser) rest() string {
+	rest := me.text[me.pos:]
+	if i := strings.IndexByte(rest, '\n'); i > -1 {
+		rest = rest[:i]
+	}
+	return rest
+}
+
+// parseKey returns the parts of a (possibly dotted) key.
+func (me *tomlParser) parseKey() ([]string, error) {
+	parts := make([]string, 0, 1)
+	for {
+		me.skipSpaces()
+		if me.pos >= len(me.text) {
+			return nil, errors.New("expected a key")
+		}
+		var part string
+		var err error
+		switch me.text[me.pos] {
+		case '"':
+			part, err = me.parseBasicString()
+		case '\'':
+			part, err = me.parseLiteralString()
+		default:
+			start := me.pos
+			for me.pos < len(me.text) && isTOMLBareKeyChar(
+				me.text[me.pos]) {
+				me.pos++
+			}
+			if start == me.pos {
+				return nil, fmt.Errorf("expected a key, got %q", me.rest())
+			}
+			part = me.text[start:me.pos]
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		if !me.accept(".") {
+			return parts, nil
+		}
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'A' && c <= 'Z') ||
+		(c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// parseValue returns the value and its kind.
+func (me *tomlParser) parseValue() (any, FieldKind, error) {
+	me.skipSpaces()
+	rest := me.text[me.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"""`):
+		s, err := me.parseMultilineString(`"""`)
+		return s, StrField, err
+	case strings.HasPrefix(rest, `'''`):
+		s, err := me.parseMultilineString(`'''`)
+		return s, StrField, err
+	case strings.HasPrefix(rest, `"`):
+		s, err := me.parseBasicString()
+		return s, StrField, err
+	case strings.HasPrefix(rest, `'`):
+		s, err := me.parseLiteralString()
+		return s, StrField, err
+	case strings.HasPrefix(rest, "["), strings.HasPrefix(rest, "{"):
+		return nil, StrField, errors.New("arrays and inline tables " +
+			"aren't supported")
+	}
+	end := 0
+	for end < len(rest) && !strings.ContainsRune(" \t\r\n#,]}",
+		rune(rest[end])) {
+		end++
+	}
+	token := rest[:end]
+	if len(token) == 10 && token[4] == '-' && len(rest) > 13 &&
+		rest[10] == ' ' && rest[13] == ':' { // date time with a space
+		for end = 11; end < len(rest) && !strings.ContainsRune(
+			" \t\r\n#", rune(rest[end])); end++ {
+		}
+		token = rest[:end]
+	}
+	me.pos += end
+	switch token {
+	case "true":
+		return true, BoolField, nil
+	case "false":
+		return false, BoolField, nil
+	case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+		return nil, RealField, fmt.Errorf("unsupported float %s (Tdb "+
+			"reals must be finite)", token)
+	case "":
+		return nil, StrField, errors.New("expected a value")
+	}
+	if (len(token) >= 10 && token[4] == '-') || (len(token) >= 8 &&
+		token[2] == ':') { // date, datetime, or time
+		return token, StrField, nil
+	}
+	digits := strings.TrimLeft(token, "+-")
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0o") ||
+		strings.HasPrefix(digits, "0b") {
+		if i, err := strconv.ParseInt(digits, 0, 64); err == nil &&
+			digits == token {
+			return int(i), IntField, nil
+		}
+	} else if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' &&
+		digits[1] != 'e' && digits[1] != 'E' {
+		return nil, StrField, fmt.Errorf("invalid number %q", token)
+	} else if i, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return int(i), IntField, nil
+	} else if i, err := strconv.ParseInt(token, 0, 64); err == nil {
+		return int(i), IntField, nil // has underscores
+	} else if r, err := strconv.ParseFloat(token, 64); err == nil &&
+		!math.IsInf(r, 0) && !math.IsNaN(r) {
+		return r, RealField, nil
+	}
+	return nil, StrField, fmt.Errorf("invalid value %q", token)
+}
+
+// parseBasicString returns the "…" string at pos.
+func (me *tomlParser) parseBasicString() (string, error) {
+	var out strings.Builder
+	for me.pos++; me.pos < len(me.text); me.pos++ {
+		switch c := me.text[me.pos]; c {
+		case '"':
+			me.pos++
+			return out.String(), nil
+		case '\n':
+			return "", errors.New("unterminated string")
+		case '\\':
+			if err := me.parseEscape(&out); err != nil {
+				return "", err
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated string")
+}
+
+// parseLiteralString returns the '…' string at pos.
+func (me *tomlParser) parseLiteralString() (string, error) {
+	me.pos++
+	end := strings.IndexAny(me.text[me.pos:], "'\n")
+	if end == -1 || me.text[me.pos+end] != '\'' {
+		return "", errors.New("unterminated string")
+	}
+	s := me.text[me.pos : me.pos+end]
+	me.pos += end + 1
+	return s, nil
+}
+
+// parseMultilineString returns the multiline string (delimited by the
+// given triple quotes) at pos.
+func (me *tomlParser) parseMultilineString(quotes string) (string,
+	error) {
+	me.pos += 3
+	if strings.HasPrefix(me.text[me.pos:], "\r\n") {
+		me.pos += 2
+		me.lino++
+	} else if strings.HasPrefix(me.text[me.pos:], "\n") {
+		me.pos++
+		me.lino++
+	}
+	var out strings.Builder
+	for me.pos < len(me.text) {
+		if strings.HasPrefix(me.text[me.pos:], quotes) {
+			for strings.HasPrefix(me.text[me.pos+1:], quotes) {
+				out.WriteByte(quotes[0]) // up to two quotes before the end
+				me.pos++
+			}
+			me.pos += 3
+			return out.String(), nil
+		}
+		c := me.text[me.pos]
+		if c == '\n' {
+			me.lino++
+		}
+		if c == '\\' && quotes == `"""` {
+			rest := strings.TrimLeft(me.text[me.pos+1:], " \t\r")
+			if strings.HasPrefix(rest, "\n") { // line ending backslash
+				me.pos = len(me.text) - len(rest)
+				me.skipBlankSpace()
+				continue
+			}
+			if err := me.parseEscape(&out); err != nil {
+				return "", err
+			}
+		} else {
+			out.WriteByte(c)
+		}
+		me.pos++
+	}
+	return "", errors.New("unterminated multiline string")
+}
+
+// skipBlankSpace skips whitespace including newlines.
+func (me *tomlParser) skipBlankSpace() {
+	for me.pos < len(me.text) && strings.ContainsRune(" \t\r\n",
+		rune(me.text[me.pos])) {
+		if me.text[me.pos] == '\n' {
+			me.lino++
+		}
+		me.pos++
+	}
+}
+
+// parseEscape writes the character for the escape at pos (a backslash)
+// and leaves pos at the escape's last character.
+func (me *tomlParser) parseEscape(out *strings.Builder) error {
+	me.pos++
+	if me.pos >= len(me.text) {
+		return errors.New("incomplete escape")
+	}
+	switch c := me.text[me.pos]; c {
+	case 'b':
+		out.WriteByte('\b')
+	case 't':
+		out.WriteByte('\t')
+	case 'n':
+		out.WriteByte('\n')
+	case 'f':
+		out.WriteByte('\f')
+	case 'r':
+		out.WriteByte('\r')
+	case 'e':
+		out.WriteByte(0x1B)
+	case '"', '\\':
+		out.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if me.pos+size >= len(me.text) {
+			return errors.New("incomplete escape")
+		}
+		code, err := strconv.ParseUint(me.text[me.pos+1:me.pos+1+size],
+			16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return fmt.Errorf("invalid escape \\%c%s", c,
+				me.text[me.pos+1:me.pos+1+size])
+		}
+		out.WriteRune(rune(code))
+		me.pos += size
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
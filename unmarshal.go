@@ -22,7 +22,7 @@ func Unmarshal(data []byte, db any) error {
 	if err != nil {
 		return err
 	}
-	tableNames := getTableNames(dbVal)
+	plan := dbPlanFor(dbVal.Type())
 	metaData := make(metaDataType)
 	var metaTable *MetaTableType
 	lino := 1
@@ -37,7 +37,7 @@ func Unmarshal(data []byte, db any) error {
 			}
 		} else if metaTable != nil {
 			if data, err = unmarshalRecords(data, metaTable, dbVal,
-				tableNames, &lino); err == nil {
+				plan, &lino); err == nil {
 				metaTable = nil
 			} else {
 				return err
@@ -65,21 +65,6 @@ func getDbValue(data []byte, db any) (reflect.Value, error) {
 	return dbVal, nil
 }
 
-func getTableNames(dbVal reflect.Value) map[string]string {
-	// key=tableName | tagName value=tableName
-	tableNames := make(map[string]string)
-	dbType := dbVal.Type()
-	for i := 0; i < dbVal.NumField(); i++ {
-		tableName := dbVal.Type().Field(i).Name
-		tableNames[tableName] = tableName
-		tableTagName := dbType.Field(i).Tag.Get("tdb")
-		if tableTagName != "" {
-			tableNames[tableTagName] = tableName
-		}
-	}
-	return tableNames
-}
-
 func unmarshalTableMetaData(data []byte, metaData metaDataType,
 	dbVal reflect.Value, lino *int) ([]byte, *MetaTableType, error) {
 	end, err := scanToByte(data, '%', lino)
@@ -121,14 +106,14 @@ func addField(fieldName, typeName string, metaTable *MetaTableType,
 }
 
 func unmarshalRecords(data []byte, metaTable *MetaTableType,
-	dbVal reflect.Value, tableNames map[string]string, lino *int) ([]byte,
-	error) {
+	dbVal reflect.Value, plan *dbPlan, lino *int) ([]byte, error) {
 	var err error
 	var table reflect.Value
-	var rec reflect.Value
+	var recPlan *recordPlan
 	var recVal reflect.Value
 	var field reflect.Value
 	var metaField *MetaFieldType
+	var fieldPlan *fieldPlan
 	inRecord := false
 	columns := metaTable.Len()
 	oldColumn := -1
@@ -140,21 +125,24 @@ func unmarshalRecords(data []byte, metaTable *MetaTableType,
 			if err != nil {
 				return data, err
 			}
-			table, rec, err = makeRecordType(metaTable.Name, dbVal,
-				tableNames)
-			if err != nil {
-				return data, err
+			if recPlan == nil {
+				table, recPlan, err = makeRecordType(metaTable.Name,
+					dbVal, plan)
+				if err != nil {
+					return data, err
+				}
 			}
-			recVal = reflect.New(rec.Type().Elem()).Elem()
+			recVal = reflect.New(table.Type().Elem()).Elem()
 		}
 		if column != oldColumn {
 			oldColumn = column
-			err = checkField(recVal, column, metaTable.Len(), *lino)
+			err = checkField(recPlan, column, metaTable.Len(), *lino)
 			if err != nil {
 				return data, err
 			}
 			field = recVal.Field(column)
 			metaField = metaTable.Field(column)
+			fieldPlan = recPlan.fields[column]
 		}
 		switch data[0] {
 		case '\n': // ignore whitespace separators
@@ -166,23 +154,27 @@ func unmarshalRecords(data []byte, metaTable *MetaTableType,
 			data, err = unmarshalNull(data, metaField, field, lino)
 			column++
 		case 'F', 'f', 'N', 'n':
-			data, err = unmarshalBool(data, false, metaField, field, lino)
+			data, err = unmarshalBool(data, false, metaField, field,
+				fieldPlan, lino)
 			column++
 		case 'T', 't', 'Y', 'y':
-			data, err = unmarshalBool(data, true, metaField, field, lino)
+			data, err = unmarshalBool(data, true, metaField, field,
+				fieldPlan, lino)
 			column++
 		case '(':
-			data, err = unmarshalBytes(data, metaField, field, lino)
+			data, err = unmarshalBytes(data, metaField, field, fieldPlan, lino)
 			column++
 		case '<':
-			data, err = unmarshalStr(data, metaField, field, lino)
+			data, err = unmarshalStr(data, metaField, field, fieldPlan, lino)
 			column++
 		case '-':
 			switch metaField.Kind {
 			case IntField:
-				data, err = unmarshalInt(data, metaField, field, lino)
+				data, err = unmarshalInt(data, metaField, field, fieldPlan,
+					lino)
 			case RealField:
-				data, err = unmarshalReal(data, metaField, field, lino)
+				data, err = unmarshalReal(data, metaField, field, fieldPlan,
+					lino)
 			default:
 				err = fmt.Errorf("e%d#%d:got -, expected %s", e118, *lino,
 					metaField.Kind)
@@ -195,21 +187,23 @@ func unmarshalRecords(data []byte, metaTable *MetaTableType,
 					bytes.IndexByte([]byte{'.', 'e', 'E', '0', '1', '2',
 						'3', '4', '5', '6', '7', '8', '9'}, data[1]) == -1 {
 					data, err = unmarshalBool(data, data[0] == '1',
-						metaField, field, lino)
+						metaField, field, fieldPlan, lino)
 				} else {
 					err = fmt.Errorf("e%d#%d:got %c%c, expected %s", e130,
 						*lino, data[0], data[1], metaField.Kind)
 				}
 			case IntField:
-				data, err = unmarshalInt(data, metaField, field, lino)
+				data, err = unmarshalInt(data, metaField, field, fieldPlan,
+					lino)
 			case RealField:
-				data, err = unmarshalReal(data, metaField, field, lino)
+				data, err = unmarshalReal(data, metaField, field, fieldPlan,
+					lino)
 			case DateField:
 				data, err = unmarshalDateTime(data, DateFormat, metaField,
-					field, lino)
+					field, fieldPlan, lino)
 			case DateTimeField:
 				data, err = unmarshalDateTime(data, DateTimeFormat,
-					metaField, field, lino)
+					metaField, field, fieldPlan, lino)
 			default: // Should never happend
 				err = fmt.Errorf("e%d#%d:got %c, expected %s", e119, *lino,
 					data[0], metaField.Kind)
@@ -241,15 +235,14 @@ func unmarshalRecords(data []byte, metaTable *MetaTableType,
 }
 
 func makeRecordType(tableName string, dbVal reflect.Value,
-	tableNames map[string]string) (reflect.Value, reflect.Value, error) {
-	field := dbVal.FieldByNameFunc(func(name string) bool {
-		return name == tableName || name == tableNames[tableName]
-	})
-	if field.Kind() == reflect.Invalid {
-		return field, field, fmt.Errorf("e%d#:invalid record type for %q",
-			e128, tableName)
-	}
-	return field, reflect.New(field.Type().Elem()), nil
+	plan *dbPlan) (reflect.Value, *recordPlan, error) {
+	index, ok := plan.indexes[tableName]
+	if !ok || dbVal.Field(index).Kind() != reflect.Slice {
+		return reflect.Value{}, nil, fmt.Errorf(
+			"e%d#:invalid record type for %q", e128, tableName)
+	}
+	table := dbVal.Field(index)
+	return table, recordPlanFor(table.Type().Elem()), nil
 }
 
 func startRecord(data []byte, inRecord *bool, oldColumn, column,
@@ -265,14 +258,14 @@ func startRecord(data []byte, inRecord *bool, oldColumn, column,
 	return data, nil
 }
 
-func checkField(recVal reflect.Value, column, size, lino int) error {
-	if !recVal.Type().Field(column).IsExported() {
+func checkField(plan *recordPlan, column, size, lino int) error {
+	if column >= size || column >= len(plan.fields) {
+		return fmt.Errorf("e%d#%d:missing field name or type", e129, lino)
+	}
+	if !plan.fields[column].exported {
 		return fmt.Errorf(
 			"e%d#%d:can't unmarshal to an unexported field: %q",
-			e122, lino, recVal.Type().Field(column).Name)
-	}
-	if column >= size {
-		return fmt.Errorf("e%d#%d:missing field name or type", e129, lino)
+			e122, lino, plan.fields[column].goName)
 	}
 	return nil
 }
@@ -291,22 +284,17 @@ func unmarshalNull(data []byte, metaField *MetaFieldType,
 }
 
 func unmarshalBool(data []byte, value bool, metaField *MetaFieldType,
-	field reflect.Value, lino *int) ([]byte, error) {
+	field reflect.Value, plan *fieldPlan, lino *int) ([]byte, error) {
 	if metaField.Kind != BoolField {
 		return data, fmt.Errorf("e%d#%d:got bool, expected %s", e114, *lino,
 			metaField.Kind)
 	}
-	if field.Kind() == reflect.Ptr {
-		pv := reflect.ValueOf(&value)
-		field.Set(pv)
-	} else {
-		field.SetBool(value)
-	}
+	plan.set(field, value)
 	return data[1:], nil
 }
 
 func unmarshalBytes(data []byte, metaField *MetaFieldType,
-	field reflect.Value, lino *int) ([]byte, error) {
+	field reflect.Value, plan *fieldPlan, lino *int) ([]byte, error) {
 	data = data[1:] // skip (
 	if metaField.Kind != BytesField {
 		return data, fmt.Errorf("e%d#%d:got bytes, expected %s", e116,
@@ -316,17 +304,12 @@ func unmarshalBytes(data []byte, metaField *MetaFieldType,
 	if err != nil {
 		return data, err
 	}
-	if field.Kind() == reflect.Ptr {
-		pv := reflect.ValueOf(&raw)
-		field.Set(pv)
-	} else {
-		field.SetBytes(raw)
-	}
+	plan.set(field, raw)
 	return data, nil
 }
 
 func unmarshalStr(data []byte, metaField *MetaFieldType,
-	field reflect.Value, lino *int) ([]byte, error) {
+	field reflect.Value, plan *fieldPlan, lino *int) ([]byte, error) {
 	data = data[1:] // skip <
 	if metaField.Kind != StrField {
 		return data, fmt.Errorf("e%d#%d:got str, expected %s", e117, *lino,
@@ -336,57 +319,37 @@ func unmarshalStr(data []byte, metaField *MetaFieldType,
 	if err != nil {
 		return data, err
 	}
-	if field.Kind() == reflect.Ptr {
-		pv := reflect.ValueOf(&s)
-		field.Set(pv)
-	} else {
-		field.SetString(s)
-	}
+	plan.set(field, s)
 	return data, nil
 }
 
 func unmarshalInt(data []byte, metaField *MetaFieldType,
-	field reflect.Value, lino *int) ([]byte, error) {
+	field reflect.Value, plan *fieldPlan, lino *int) ([]byte, error) {
 	data, i, err := readInt(data, lino)
 	if err != nil {
 		return data, err
 	}
-	if field.Kind() == reflect.Ptr {
-		pv := reflect.ValueOf(&i)
-		field.Set(pv)
-	} else {
-		field.SetInt(int64(i))
-	}
+	plan.set(field, i)
 	return data, nil
 }
 
 func unmarshalReal(data []byte, metaField *MetaFieldType,
-	field reflect.Value, lino *int) ([]byte, error) {
+	field reflect.Value, plan *fieldPlan, lino *int) ([]byte, error) {
 	data, r, err := readReal(data, lino)
 	if err != nil {
 		return data, err
 	}
-	if field.Kind() == reflect.Ptr {
-		pv := reflect.ValueOf(&r)
-		field.Set(pv)
-	} else {
-		field.SetFloat(r)
-	}
+	plan.set(field, r)
 	return data, nil
 }
 
 func unmarshalDateTime(data []byte, format string, metaField *MetaFieldType,
-	field reflect.Value, lino *int) ([]byte, error) {
+	field reflect.Value, plan *fieldPlan, lino *int) ([]byte, error) {
 	data, d, err := readDateTime(data, format, lino)
 	if err != nil {
 		return data, err
 	}
-	if field.Kind() == reflect.Ptr {
-		pv := reflect.ValueOf(&d)
-		field.Set(pv)
-	} else {
-		field.Set(reflect.ValueOf(d))
-	}
+	plan.set(field, d)
 	return data, err
 }
 
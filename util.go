@@ -3,7 +3,13 @@
 
 package tdb
 
-import "bytes"
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 // Escape returns an XML-escaped string, i.e., where runes are replaced as
 // follows: & → &amp;, < → &lt;, > → &gt;.
@@ -55,3 +61,70 @@ func Unescape(s string) string {
 	}
 	return string(result)
 }
+
+// maxIdentifierLen is the maximum length (in runes) of a table or field
+// name.
+const maxIdentifierLen = 32
+
+// isIdentifier returns true if the given name is a valid Tdb tablename or
+// fieldname, i.e., it starts with a letter or underscore, contains only
+// letters, digits, and underscores, is at most 32 characters long, and
+// isn't a built-in type or bool constant.
+func isIdentifier(name string) bool {
+	if name == "" || utf8.RuneCountInString(name) > maxIdentifierLen ||
+		reservedWords.Contains(name) || builtinConstants.Contains(name) {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || unicode.IsLetter(c) ||
+			(i > 0 && unicode.IsDigit(c))) {
+			return false
+		}
+	}
+	return true
+}
+
+// makeIdentifier returns a valid Tdb identifier based on the given name
+// that isn't in used (and adds it to used). Invalid characters are replaced
+// with underscores, names that would clash with built-in types or constants
+// get a leading underscore, and over-long names are truncated—with a
+// numeric suffix added if needed for uniqueness.
+func makeIdentifier(name string, used map[string]bool) string {
+	var s strings.Builder
+	for i, c := range name {
+		if c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c)) {
+			s.WriteRune(c)
+		} else if i == 0 && unicode.IsDigit(c) {
+			s.WriteRune('_')
+			s.WriteRune(c)
+		} else {
+			s.WriteRune('_')
+		}
+	}
+	base := s.String()
+	if base == "" || reservedWords.Contains(base) ||
+		builtinConstants.Contains(base) {
+		base = "_" + base
+	}
+	base = truncateRunes(base, maxIdentifierLen)
+	identifier := base
+	for i := 2; used[identifier]; i++ {
+		suffix := "_" + strconv.Itoa(i)
+		identifier = truncateRunes(base,
+			maxIdentifierLen-len(suffix)) + suffix
+	}
+	used[identifier] = true
+	return identifier
+}
+
+// truncateRunes returns s truncated to at most size runes.
+func truncateRunes(s string, size int) string {
+	i := 0
+	for j := range s {
+		if i == size {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
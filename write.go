@@ -34,9 +34,18 @@ func (me *Tdb) Write(out io.Writer) error {
 // See also [WriteDecimals] and [Parse].
 func (me *Tdb) WriteDecimals(out io.Writer, decimals int) error {
 	decimals = sanitizedDecimals(decimals)
+	return me.write(out, func(out io.Writer, value any,
+		fieldMeta *MetaFieldType) error {
+		return writeValue(out, value, fieldMeta, decimals)
+	})
+}
+
+// write writes the Tdb's tables in Tdb format using writeValue to write
+// each value.
+func (me *Tdb) write(out io.Writer, writeValue func(io.Writer, any,
+	*MetaFieldType) error) error {
 	var err error
 	nl := []byte{'\n'}
-	null := []byte{'?'}
 	for _, tableName := range me.TableNames {
 		table := me.Tables[tableName]
 		if err = writeTableMetaData(out, table); err != nil {
@@ -50,36 +59,8 @@ func (me *Tdb) WriteDecimals(out io.Writer, decimals int) error {
 					return err
 				}
 				sep = " "
-				fieldMeta := table.Fields[column]
-				kind := fieldMeta.Kind
-				if value == nil {
-					if fieldMeta.AllowNull {
-						_, err = out.Write(null)
-					} else {
-						return fmt.Errorf(e146str, e146, kind, kind)
-					}
-				} else {
-					switch kind {
-					case BoolField:
-						err = writeBool(out, value, kind)
-					case BytesField:
-						err = writeBytes(out, value, kind)
-					case DateField:
-						err = writeDateTime(out, value, kind, DateFormat)
-					case DateTimeField:
-						err = writeDateTime(out, value, kind,
-							DateTimeFormat)
-					case IntField:
-						err = writeInt(out, value, kind)
-					case RealField:
-						err = writeReal(out, value, kind, decimals)
-					case StrField:
-						err = writeStr(out, value, kind)
-					default: // should never happen
-						return fmt.Errorf("e%d:invalid kind %q", e142, kind)
-					}
-				}
-				if err != nil {
+				if err = writeValue(out, value,
+					table.Fields[column]); err != nil {
 					return err
 				}
 			}
@@ -96,6 +77,37 @@ func (me *Tdb) WriteDecimals(out io.Writer, decimals int) error {
 	return nil
 }
 
+// writeValue writes the value in Tdb format; decimals must already be
+// sanitized (see [Tdb.WriteDecimals]).
+func writeValue(out io.Writer, value any, fieldMeta *MetaFieldType,
+	decimals int) error {
+	kind := fieldMeta.Kind
+	if value == nil {
+		if fieldMeta.AllowNull {
+			_, err := out.Write([]byte{'?'})
+			return err
+		}
+		return fmt.Errorf(e146str, e146, kind, kind)
+	}
+	switch kind {
+	case BoolField:
+		return writeBool(out, value, kind)
+	case BytesField:
+		return writeBytes(out, value, kind)
+	case DateField:
+		return writeDateTime(out, value, kind, DateFormat)
+	case DateTimeField:
+		return writeDateTime(out, value, kind, DateTimeFormat)
+	case IntField:
+		return writeInt(out, value, kind)
+	case RealField:
+		return writeReal(out, value, kind, decimals)
+	case StrField:
+		return writeStr(out, value, kind)
+	}
+	return fmt.Errorf("e%d:invalid kind %q", e142, kind) // should never happen
+}
+
 func writeTableMetaData(out io.Writer, table *Table) error {
 	_, err := out.Write([]byte{'['})
 	if err != nil {